@@ -0,0 +1,147 @@
+package abx_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sagernet/sing/common/abx"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readTokens(t *testing.T, content []byte) []xml.Token {
+	reader, isABX := abx.NewReader(content)
+	require.True(t, isABX)
+	var tokens []xml.Token
+	for {
+		token, err := reader.Token()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if token != nil {
+			tokens = append(tokens, xml.CopyToken(token))
+		}
+	}
+	return tokens
+}
+
+func TestWriter(t *testing.T) {
+	t.Parallel()
+	var buffer bytes.Buffer
+	writer := abx.NewWriter(&buffer)
+	require.NoError(t, writer.EncodeToken(xml.StartElement{Name: xml.Name{Local: "packages"}}))
+	for _, name := range []string{"com.example.a", "com.example.b"} {
+		require.NoError(t, writer.EncodeToken(xml.StartElement{
+			Name: xml.Name{Local: "package"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: name}},
+		}))
+		require.NoError(t, writer.AttributeInterned("codePath", "/data/app"))
+		require.NoError(t, writer.AttributeInt("userId", -10086))
+		require.NoError(t, writer.AttributeIntHex("flags", 0x2a))
+		require.NoError(t, writer.AttributeLong("ft", 1700000000000))
+		require.NoError(t, writer.AttributeLongHex("mask", 0x1234567890))
+		require.NoError(t, writer.AttributeFloat("scale", 1.5))
+		require.NoError(t, writer.AttributeDouble("ratio", 0.1))
+		require.NoError(t, writer.AttributeBoolean("enabled", true))
+		require.NoError(t, writer.AttributeBoolean("stopped", false))
+		require.NoError(t, writer.AttributeBytesHex("sig", []byte{0xde, 0xad}))
+		require.NoError(t, writer.AttributeBytesBase64("key", []byte("key")))
+		require.NoError(t, writer.EncodeToken(xml.CharData("text")))
+		require.NoError(t, writer.EncodeToken(xml.EndElement{Name: xml.Name{Local: "package"}}))
+	}
+	require.Error(t, writer.AttributeInt("late", 1))
+	require.NoError(t, writer.EncodeToken(xml.Comment("comment")))
+	require.NoError(t, writer.EncodeToken(xml.Directive("[CDATA[<raw>]]")))
+	require.NoError(t, writer.EncodeToken(xml.EndElement{Name: xml.Name{Local: "packages"}}))
+	require.NoError(t, writer.Close())
+
+	packageAttrs := func(name string) []xml.Attr {
+		return []xml.Attr{
+			{Name: xml.Name{Local: "name"}, Value: name},
+			{Name: xml.Name{Local: "codePath"}, Value: "/data/app"},
+			{Name: xml.Name{Local: "userId"}, Value: "-10086"},
+			{Name: xml.Name{Local: "flags"}, Value: "0x2a"},
+			{Name: xml.Name{Local: "ft"}, Value: "1700000000000"},
+			{Name: xml.Name{Local: "mask"}, Value: "0x1234567890"},
+			{Name: xml.Name{Local: "scale"}, Value: "1.5"},
+			{Name: xml.Name{Local: "ratio"}, Value: "0.1"},
+			{Name: xml.Name{Local: "enabled"}, Value: "true"},
+			{Name: xml.Name{Local: "stopped"}, Value: "false"},
+			{Name: xml.Name{Local: "sig"}, Value: "dead"},
+			{Name: xml.Name{Local: "key"}, Value: "a2V5"},
+		}
+	}
+	require.Equal(t, []xml.Token{
+		xml.StartElement{Name: xml.Name{Local: "packages"}, Attr: []xml.Attr{}},
+		xml.StartElement{Name: xml.Name{Local: "package"}, Attr: packageAttrs("com.example.a")},
+		xml.CharData("text"),
+		xml.EndElement{Name: xml.Name{Local: "package"}},
+		xml.StartElement{Name: xml.Name{Local: "package"}, Attr: packageAttrs("com.example.b")},
+		xml.CharData("text"),
+		xml.EndElement{Name: xml.Name{Local: "package"}},
+		xml.Comment("comment"),
+		xml.Directive("[CDATA[<raw>]]"),
+		xml.EndElement{Name: xml.Name{Local: "packages"}},
+	}, readTokens(t, buffer.Bytes()))
+	// interned names are written once
+	require.Equal(t, 1, bytes.Count(buffer.Bytes(), []byte("codePath")))
+	require.Equal(t, 1, bytes.Count(buffer.Bytes(), []byte("/data/app")))
+}
+
+func TestWriterUnclosed(t *testing.T) {
+	t.Parallel()
+	writer := abx.NewWriter(io.Discard)
+	require.NoError(t, writer.EncodeToken(xml.StartElement{Name: xml.Name{Local: "a"}}))
+	require.Error(t, writer.Close())
+	require.Error(t, abx.NewWriter(io.Discard).EncodeToken(xml.EndElement{Name: xml.Name{Local: "a"}}))
+}
+
+const testDocument = `<?xml version="1.0" encoding="UTF-8"?>
+<packages>
+  <version sdkVersion="34" fingerprint="google/raven:14/UQ1A"></version>
+  <package name="com.example" userId="10086" ft="0x18b0a8c3d40" flags="0x2a" enabled="true" scale="0.75" code="007"></package>
+  <!-- comment -->
+  <shared-user name="android.uid.system">text &amp; more</shared-user>
+</packages>`
+
+func TestConvertRoundTrip(t *testing.T) {
+	t.Parallel()
+	for _, inferTypes := range []bool{false, true} {
+		var abxContent bytes.Buffer
+		require.NoError(t, abx.FromXML(&abxContent, strings.NewReader(testDocument), abx.ConvertOptions{InferTypes: inferTypes}))
+		var xmlContent bytes.Buffer
+		require.NoError(t, abx.ToXML(&xmlContent, abxContent.Bytes()))
+		require.Equal(t, testDocument, xmlContent.String())
+
+		var abxContent2 bytes.Buffer
+		require.NoError(t, abx.FromXML(&abxContent2, &xmlContent, abx.ConvertOptions{InferTypes: inferTypes}))
+		require.Equal(t, abxContent.Bytes(), abxContent2.Bytes())
+	}
+}
+
+func TestConvertInferTypes(t *testing.T) {
+	t.Parallel()
+	var expected bytes.Buffer
+	writer := abx.NewWriter(&expected)
+	require.NoError(t, writer.EncodeToken(xml.StartElement{Name: xml.Name{Local: "package"}}))
+	require.NoError(t, writer.AttributeInt("userId", 10086))
+	require.NoError(t, writer.AttributeLong("ft", 1700000000000))
+	require.NoError(t, writer.AttributeIntHex("flags", 0x2a))
+	require.NoError(t, writer.AttributeLongHex("mask", 0x1234567890))
+	require.NoError(t, writer.AttributeBoolean("enabled", true))
+	require.NoError(t, writer.AttributeDouble("scale", 0.75))
+	require.NoError(t, writer.Attribute("code", "007"))
+	require.NoError(t, writer.Attribute("hex", "0xABC"))
+	require.NoError(t, writer.EncodeToken(xml.EndElement{Name: xml.Name{Local: "package"}}))
+	require.NoError(t, writer.Close())
+
+	var xmlContent bytes.Buffer
+	require.NoError(t, abx.ToXML(&xmlContent, expected.Bytes()))
+	var actual bytes.Buffer
+	require.NoError(t, abx.FromXML(&actual, &xmlContent, abx.ConvertOptions{InferTypes: true}))
+	require.Equal(t, expected.Bytes(), actual.Bytes())
+}
@@ -0,0 +1,113 @@
+package abx
+
+import (
+	"encoding/xml"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+type ConvertOptions struct {
+	// InferTypes writes attribute values as typed values when Reader formats them back identically,
+	// e.g. "true" as a boolean, "42" as an int and "0x2a" as a hex int. Otherwise, all values are written as strings.
+	InferTypes bool
+}
+
+// FromXML converts a text XML document to Android Binary XML.
+func FromXML(writer io.Writer, reader io.Reader, options ConvertOptions) error {
+	decoder := xml.NewDecoder(reader)
+	abxWriter := NewWriter(writer)
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		startElement, isStart := token.(xml.StartElement)
+		if !isStart || !options.InferTypes {
+			err = abxWriter.EncodeToken(token)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		attrs := startElement.Attr
+		startElement.Attr = nil
+		err = abxWriter.EncodeToken(startElement)
+		if err != nil {
+			return err
+		}
+		for _, attr := range attrs {
+			err = writeInferredAttribute(abxWriter, joinName(attr.Name), attr.Value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return abxWriter.Close()
+}
+
+// ToXML converts an Android Binary XML document to text XML.
+func ToXML(writer io.Writer, content []byte) error {
+	reader, isABX := NewReader(content)
+	if !isABX {
+		return E.New("invalid ABX content")
+	}
+	encoder := xml.NewEncoder(writer)
+	_, err := io.WriteString(writer, strings.TrimSuffix(xml.Header, "\n"))
+	if err != nil {
+		return err
+	}
+	for {
+		token, err := reader.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if token == nil {
+			continue
+		}
+		err = encoder.EncodeToken(token)
+		if err != nil {
+			return err
+		}
+	}
+	return encoder.Flush()
+}
+
+func writeInferredAttribute(writer *Writer, name string, value string) error {
+	switch value {
+	case "true":
+		return writer.AttributeBoolean(name, true)
+	case "false":
+		return writer.AttributeBoolean(name, false)
+	}
+	if strings.HasPrefix(value, "0x") {
+		if intValue, err := strconv.ParseInt(value[2:], 16, 32); err == nil && "0x"+strconv.FormatInt(intValue, 16) == value {
+			return writer.AttributeIntHex(name, int32(intValue))
+		}
+		if longValue, err := strconv.ParseInt(value[2:], 16, 64); err == nil && "0x"+strconv.FormatInt(longValue, 16) == value {
+			return writer.AttributeLongHex(name, longValue)
+		}
+		return writer.Attribute(name, value)
+	}
+	if longValue, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if strconv.FormatInt(longValue, 10) != value {
+			return writer.Attribute(name, value)
+		}
+		if longValue >= math.MinInt32 && longValue <= math.MaxInt32 {
+			return writer.AttributeInt(name, int32(longValue))
+		}
+		return writer.AttributeLong(name, longValue)
+	}
+	if floatValue, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(floatValue, 0) && !math.IsNaN(floatValue) &&
+		strconv.FormatFloat(floatValue, 'g', -1, 64) == value {
+		return writer.AttributeDouble(name, floatValue)
+	}
+	return writer.Attribute(name, value)
+}
@@ -0,0 +1,259 @@
+package abx
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+
+	. "github.com/sagernet/sing/common/abx/internal"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+type EventType uint8
+
+const (
+	EventStartDocument         EventType = StartDocument
+	EventEndDocument           EventType = EndDocument
+	EventStartTag              EventType = StartTag
+	EventEndTag                EventType = EndTag
+	EventText                  EventType = TEXT
+	EventCDSect                EventType = CDSECT
+	EventEntityRef             EventType = EntityRef
+	EventIgnorableWhitespace   EventType = IgnorableWhitespace
+	EventProcessingInstruction EventType = ProcessingInstruction
+	EventComment               EventType = COMMENT
+	EventDocDecl               EventType = DOCDECL
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventStartDocument:
+		return "start document"
+	case EventEndDocument:
+		return "end document"
+	case EventStartTag:
+		return "start tag"
+	case EventEndTag:
+		return "end tag"
+	case EventText:
+		return "text"
+	case EventCDSect:
+		return "cdata"
+	case EventEntityRef:
+		return "entity reference"
+	case EventIgnorableWhitespace:
+		return "ignorable whitespace"
+	case EventProcessingInstruction:
+		return "processing instruction"
+	case EventComment:
+		return "comment"
+	case EventDocDecl:
+		return "doctype declaration"
+	default:
+		return "unknown(" + strconv.Itoa(int(t)) + ")"
+	}
+}
+
+type Event struct {
+	Type EventType
+	// Name is set for start and end tags
+	Name  string
+	Attrs []Attr
+	// Text is set for the other events with content
+	Text string
+}
+
+func (e Event) Attr(name string) (Attr, bool) {
+	for _, attr := range e.Attrs {
+		if attr.Name == name {
+			return attr, true
+		}
+	}
+	return Attr{}, false
+}
+
+type AttrType uint8
+
+const (
+	AttrNull           AttrType = TypeNull
+	AttrString         AttrType = TypeString
+	AttrStringInterned AttrType = TypeStringInterned
+	AttrBytesHex       AttrType = TypeBytesHex
+	AttrBytesBase64    AttrType = TypeBytesBase64
+	AttrInt            AttrType = TypeInt
+	AttrIntHex         AttrType = TypeIntHex
+	AttrLong           AttrType = TypeLong
+	AttrLongHex        AttrType = TypeLongHex
+	AttrFloat          AttrType = TypeFloat
+	AttrDouble         AttrType = TypeDouble
+	AttrBoolean        AttrType = TypeBooleanTrue
+)
+
+func (t AttrType) String() string {
+	switch t {
+	case AttrNull:
+		return "null"
+	case AttrString:
+		return "string"
+	case AttrStringInterned:
+		return "interned string"
+	case AttrBytesHex:
+		return "hex bytes"
+	case AttrBytesBase64:
+		return "base64 bytes"
+	case AttrInt:
+		return "int"
+	case AttrIntHex:
+		return "hex int"
+	case AttrLong:
+		return "long"
+	case AttrLongHex:
+		return "hex long"
+	case AttrFloat:
+		return "float"
+	case AttrDouble:
+		return "double"
+	case AttrBoolean:
+		return "boolean"
+	default:
+		return "unknown(" + strconv.Itoa(int(t)) + ")"
+	}
+}
+
+// Attr is an attribute with its original ABX type.
+// Value is nil, string, []byte, int32, int64, float32, float64 or bool, by type.
+type Attr struct {
+	Name  string
+	Type  AttrType
+	Value any
+}
+
+// String returns the value in the text form of Reader.Token.
+func (a Attr) String() string {
+	switch value := a.Value.(type) {
+	case string:
+		return value
+	case []byte:
+		if a.Type == AttrBytesBase64 {
+			return base64.StdEncoding.EncodeToString(value)
+		}
+		return hex.EncodeToString(value)
+	case int32:
+		if a.Type == AttrIntHex {
+			return "0x" + strconv.FormatInt(int64(value), 16)
+		}
+		return strconv.FormatInt(int64(value), 10)
+	case int64:
+		if a.Type == AttrLongHex {
+			return "0x" + strconv.FormatInt(value, 16)
+		}
+		return strconv.FormatInt(value, 10)
+	case float32:
+		return strconv.FormatFloat(float64(value), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		return ""
+	}
+}
+
+// Int returns an int or hex int value, or parses a decimal string value.
+func (a Attr) Int() (int32, error) {
+	switch value := a.Value.(type) {
+	case int32:
+		return value, nil
+	case string:
+		intValue, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return 0, E.Cause(err, "attribute ", a.Name)
+		}
+		return int32(intValue), nil
+	default:
+		return 0, a.typeError("int")
+	}
+}
+
+// Long returns an int or long value, or parses a decimal string value.
+func (a Attr) Long() (int64, error) {
+	switch value := a.Value.(type) {
+	case int32:
+		return int64(value), nil
+	case int64:
+		return value, nil
+	case string:
+		longValue, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, E.Cause(err, "attribute ", a.Name)
+		}
+		return longValue, nil
+	default:
+		return 0, a.typeError("long")
+	}
+}
+
+// Float returns a float value, or parses a string value.
+func (a Attr) Float() (float32, error) {
+	switch value := a.Value.(type) {
+	case float32:
+		return value, nil
+	case string:
+		floatValue, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return 0, E.Cause(err, "attribute ", a.Name)
+		}
+		return float32(floatValue), nil
+	default:
+		return 0, a.typeError("float")
+	}
+}
+
+// Double returns a float or double value, or parses a string value.
+func (a Attr) Double() (float64, error) {
+	switch value := a.Value.(type) {
+	case float32:
+		return float64(value), nil
+	case float64:
+		return value, nil
+	case string:
+		doubleValue, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, E.Cause(err, "attribute ", a.Name)
+		}
+		return doubleValue, nil
+	default:
+		return 0, a.typeError("double")
+	}
+}
+
+// Boolean returns a boolean value, or parses a "true" or "false" string value.
+func (a Attr) Boolean() (bool, error) {
+	switch value := a.Value.(type) {
+	case bool:
+		return value, nil
+	case string:
+		switch value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return false, E.New("attribute ", a.Name, ": invalid boolean value: ", value)
+	default:
+		return false, a.typeError("boolean")
+	}
+}
+
+// Bytes returns a hex or base64 bytes value.
+func (a Attr) Bytes() ([]byte, error) {
+	value, isBytes := a.Value.([]byte)
+	if !isBytes {
+		return nil, a.typeError("bytes")
+	}
+	return value, nil
+}
+
+func (a Attr) typeError(expected string) error {
+	return E.New("attribute ", a.Name, ": expected ", expected, ", got ", a.Type)
+}
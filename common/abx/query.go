@@ -0,0 +1,167 @@
+package abx
+
+import (
+	"io"
+	"strings"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// Element is an element of a document parsed by Parse, with typed attributes.
+type Element struct {
+	Name     string
+	Attrs    []Attr
+	Children []*Element
+	// Text is the concatenated text and CDATA content directly inside the element
+	Text string
+}
+
+// Parse reads an Android Binary XML document and returns its root element.
+func Parse(content []byte) (*Element, error) {
+	reader, isABX := NewTypedReader(content)
+	if !isABX {
+		return nil, E.New("invalid ABX content")
+	}
+	var (
+		root  *Element
+		stack []*Element
+	)
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch event.Type {
+		case EventStartTag:
+			element := &Element{Name: event.Name, Attrs: event.Attrs}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, element)
+			} else if root == nil {
+				root = element
+			} else {
+				return nil, E.New("multiple root elements: ", root.Name, ", ", element.Name)
+			}
+			stack = append(stack, element)
+		case EventEndTag:
+			if len(stack) == 0 || stack[len(stack)-1].Name != event.Name {
+				return nil, E.New("unexpected end tag ", event.Name)
+			}
+			stack = stack[:len(stack)-1]
+		case EventText, EventCDSect:
+			if len(stack) > 0 {
+				stack[len(stack)-1].Text += event.Text
+			}
+		}
+	}
+	if root == nil {
+		return nil, E.New("missing root element")
+	}
+	if len(stack) > 0 {
+		return nil, E.New("unclosed element ", stack[len(stack)-1].Name)
+	}
+	return root, nil
+}
+
+// Find returns the descendants matching a slash-separated path of element names relative to the element,
+// in document order. A "*" matches any name.
+func (e *Element) Find(path string) []*Element {
+	elements := []*Element{e}
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		var matched []*Element
+		for _, element := range elements {
+			for _, child := range element.Children {
+				if name == "*" || child.Name == name {
+					matched = append(matched, child)
+				}
+			}
+		}
+		elements = matched
+	}
+	return elements
+}
+
+// First returns the first descendant matching a path, or nil.
+func (e *Element) First(path string) *Element {
+	elements := e.Find(path)
+	if len(elements) == 0 {
+		return nil
+	}
+	return elements[0]
+}
+
+func (e *Element) Attr(name string) (Attr, bool) {
+	for _, attr := range e.Attrs {
+		if attr.Name == name {
+			return attr, true
+		}
+	}
+	return Attr{}, false
+}
+
+// String returns the text form of an attribute, or an empty string if it does not exist.
+func (e *Element) String(name string) string {
+	attr, _ := e.Attr(name)
+	return attr.String()
+}
+
+func (e *Element) Int(name string) (int32, error) {
+	attr, err := e.requireAttr(name)
+	if err != nil {
+		return 0, err
+	}
+	return attr.Int()
+}
+
+func (e *Element) Long(name string) (int64, error) {
+	attr, err := e.requireAttr(name)
+	if err != nil {
+		return 0, err
+	}
+	return attr.Long()
+}
+
+func (e *Element) Float(name string) (float32, error) {
+	attr, err := e.requireAttr(name)
+	if err != nil {
+		return 0, err
+	}
+	return attr.Float()
+}
+
+func (e *Element) Double(name string) (float64, error) {
+	attr, err := e.requireAttr(name)
+	if err != nil {
+		return 0, err
+	}
+	return attr.Double()
+}
+
+func (e *Element) Boolean(name string) (bool, error) {
+	attr, err := e.requireAttr(name)
+	if err != nil {
+		return false, err
+	}
+	return attr.Boolean()
+}
+
+func (e *Element) Bytes(name string) ([]byte, error) {
+	attr, err := e.requireAttr(name)
+	if err != nil {
+		return nil, err
+	}
+	return attr.Bytes()
+}
+
+func (e *Element) requireAttr(name string) (Attr, error) {
+	attr, loaded := e.Attr(name)
+	if !loaded {
+		return Attr{}, E.New("element ", e.Name, ": missing attribute ", name)
+	}
+	return attr, nil
+}
@@ -0,0 +1,134 @@
+package abx_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+
+	"github.com/sagernet/sing/common/abx"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writePackages(t *testing.T) []byte {
+	var buffer bytes.Buffer
+	writer := abx.NewWriter(&buffer)
+	require.NoError(t, writer.EncodeToken(xml.StartElement{Name: xml.Name{Local: "packages"}}))
+	require.NoError(t, writer.EncodeToken(xml.StartElement{Name: xml.Name{Local: "version"}}))
+	require.NoError(t, writer.AttributeInt("sdkVersion", 34))
+	require.NoError(t, writer.EncodeToken(xml.EndElement{Name: xml.Name{Local: "version"}}))
+	for i, name := range []string{"com.example.a", "com.example.b"} {
+		require.NoError(t, writer.EncodeToken(xml.StartElement{Name: xml.Name{Local: "package"}}))
+		require.NoError(t, writer.AttributeInterned("name", name))
+		require.NoError(t, writer.AttributeInt("userId", int32(10086+i)))
+		require.NoError(t, writer.AttributeLongHex("ft", 0x18b0a8c3d40))
+		require.NoError(t, writer.AttributeBoolean("enabled", i == 0))
+		require.NoError(t, writer.AttributeFloat("scale", 0.5))
+		require.NoError(t, writer.Attribute("version", "42"))
+		require.NoError(t, writer.EncodeToken(xml.StartElement{Name: xml.Name{Local: "sigs"}}))
+		require.NoError(t, writer.EncodeToken(xml.StartElement{Name: xml.Name{Local: "cert"}}))
+		require.NoError(t, writer.AttributeBytesHex("key", []byte{0xca, 0xfe, byte(i)}))
+		require.NoError(t, writer.EncodeToken(xml.EndElement{Name: xml.Name{Local: "cert"}}))
+		require.NoError(t, writer.EncodeToken(xml.EndElement{Name: xml.Name{Local: "sigs"}}))
+		require.NoError(t, writer.EncodeToken(xml.CharData("text")))
+		require.NoError(t, writer.EncodeToken(xml.Directive("[CDATA[<data>]]")))
+		require.NoError(t, writer.EncodeToken(xml.EndElement{Name: xml.Name{Local: "package"}}))
+	}
+	require.NoError(t, writer.EncodeToken(xml.EndElement{Name: xml.Name{Local: "packages"}}))
+	require.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func TestReaderEvents(t *testing.T) {
+	t.Parallel()
+	content := writePackages(t)
+	reader, isABX := abx.NewTypedReader(content)
+	require.True(t, isABX)
+	var (
+		buffer bytes.Buffer
+		events []abx.Event
+	)
+	writer := abx.NewWriter(&buffer)
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		events = append(events, event)
+		require.NoError(t, writer.WriteEvent(event))
+	}
+	require.Equal(t, content, buffer.Bytes())
+	require.Equal(t, abx.EventStartDocument, events[0].Type)
+	require.Equal(t, abx.EventEndDocument, events[len(events)-1].Type)
+	require.Equal(t, abx.EventStartTag, events[4].Type)
+	require.Equal(t, "package", events[4].Name)
+	require.Equal(t, []abx.Attr{
+		{Name: "name", Type: abx.AttrStringInterned, Value: "com.example.a"},
+		{Name: "userId", Type: abx.AttrInt, Value: int32(10086)},
+		{Name: "ft", Type: abx.AttrLongHex, Value: int64(0x18b0a8c3d40)},
+		{Name: "enabled", Type: abx.AttrBoolean, Value: true},
+		{Name: "scale", Type: abx.AttrFloat, Value: float32(0.5)},
+		{Name: "version", Type: abx.AttrString, Value: "42"},
+	}, events[4].Attrs)
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	root, err := abx.Parse(writePackages(t))
+	require.NoError(t, err)
+	require.Equal(t, "packages", root.Name)
+
+	sdkVersion, err := root.First("version").Int("sdkVersion")
+	require.NoError(t, err)
+	require.Equal(t, int32(34), sdkVersion)
+
+	packages := root.Find("package")
+	require.Len(t, packages, 2)
+	require.Equal(t, "com.example.b", packages[1].String("name"))
+	require.Equal(t, "text<data>", packages[0].Text)
+	userId, err := packages[1].Int("userId")
+	require.NoError(t, err)
+	require.Equal(t, int32(10087), userId)
+	userIdLong, err := packages[1].Long("userId")
+	require.NoError(t, err)
+	require.Equal(t, int64(10087), userIdLong)
+	ft, err := packages[0].Long("ft")
+	require.NoError(t, err)
+	require.Equal(t, int64(0x18b0a8c3d40), ft)
+	require.Equal(t, "0x18b0a8c3d40", packages[0].String("ft"))
+	enabled, err := packages[1].Boolean("enabled")
+	require.NoError(t, err)
+	require.False(t, enabled)
+	scale, err := packages[0].Double("scale")
+	require.NoError(t, err)
+	require.Equal(t, 0.5, scale)
+	version, err := packages[0].Int("version")
+	require.NoError(t, err)
+	require.Equal(t, int32(42), version)
+
+	keys := root.Find("/package/sigs/cert")
+	require.Len(t, keys, 2)
+	key, err := keys[1].Bytes("key")
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xca, 0xfe, 1}, key)
+	require.Len(t, root.Find("*/*/cert"), 2)
+	require.Nil(t, root.First("shared-user"))
+
+	_, err = packages[0].Int("missing")
+	require.Error(t, err)
+	_, err = packages[0].Boolean("userId")
+	require.Error(t, err)
+	_, err = packages[0].Int("name")
+	require.Error(t, err)
+}
+
+func TestWriteEventInvalid(t *testing.T) {
+	t.Parallel()
+	writer := abx.NewWriter(io.Discard)
+	require.EqualError(t, writer.WriteEvent(abx.Event{Type: 0xff}), "unknown event type unknown(255)")
+	require.NoError(t, writer.WriteEvent(abx.Event{Type: abx.EventStartTag, Name: "package"}))
+	require.EqualError(t, writer.WriteAttr(abx.Attr{Name: "scale", Type: abx.AttrFloat, Value: 0.5}), "attribute scale: invalid value for type float: float64")
+	require.EqualError(t, writer.WriteAttr(abx.Attr{Name: "key", Type: abx.AttrBytesHex, Value: "cafe"}), "attribute key: invalid value for type hex bytes: string")
+}
@@ -2,12 +2,9 @@ package abx
 
 import (
 	"bytes"
-	"encoding/base64"
 	"encoding/binary"
-	"encoding/hex"
 	"encoding/xml"
 	"io"
-	"strconv"
 
 	. "github.com/sagernet/sing/common/abx/internal"
 	E "github.com/sagernet/sing/common/exceptions"
@@ -21,6 +18,14 @@ type Reader struct {
 }
 
 func NewReader(content []byte) (xml.TokenReader, bool) {
+	reader, isABX := NewTypedReader(content)
+	if !isABX {
+		return nil, false
+	}
+	return reader, true
+}
+
+func NewTypedReader(content []byte) (*Reader, bool) {
 	if len(content) < 4 || !bytes.Equal(content[:4], ProtocolMagicVersion0) {
 		return nil, false
 	}
@@ -28,184 +33,129 @@ func NewReader(content []byte) (xml.TokenReader, bool) {
 }
 
 func (r *Reader) Token() (token xml.Token, err error) {
-	event, err := r.reader.ReadByte()
+	event, err := r.Next()
 	if err != nil {
 		return
 	}
-	tokenType := event & 0x0f
-	eventType := event & 0xf0
-	switch tokenType {
-	case StartDocument:
-		return
-	case EndDocument:
+	switch event.Type {
+	case EventEndDocument:
 		return nil, io.EOF
-	case StartTag:
-		var name string
-		name, err = r.readInternedUTF()
-		if err != nil {
-			return
-		}
+	case EventStartTag:
 		var attrs []xml.Attr
-		attrs, err = r.readAttributes()
-		if err != nil {
-			return
-		}
-		return xml.StartElement{Name: xml.Name{Local: name}, Attr: attrs}, nil
-	case EndTag:
-		var name string
-		name, err = r.readInternedUTF()
-		if err != nil {
-			return
-		}
-		return xml.EndElement{Name: xml.Name{Local: name}}, nil
-	case TEXT:
-		var data string
-		data, err = r.readUTF()
-		if err != nil {
-			return
-		}
-		return xml.CharData(data), nil
-	case CDSECT:
-		var data string
-		data, err = r.readUTF()
-		if err != nil {
-			return
-		}
-		return xml.Directive("<![CDATA[" + data + "]]>"), nil
-	case ProcessingInstruction:
-		_, err = r.readUTF()
+		for _, attr := range event.Attrs {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: attr.Name}, Value: attr.String()})
+		}
+		return xml.StartElement{Name: xml.Name{Local: event.Name}, Attr: attrs}, nil
+	case EventEndTag:
+		return xml.EndElement{Name: xml.Name{Local: event.Name}}, nil
+	case EventText:
+		return xml.CharData(event.Text), nil
+	case EventCDSect:
+		return xml.Directive("[CDATA[" + event.Text + "]]"), nil
+	case EventComment:
+		return xml.Comment(event.Text), nil
+	}
+	return
+}
+
+// Next returns the next event with typed attributes.
+// After the EndDocument event, io.EOF is returned.
+func (r *Reader) Next() (event Event, err error) {
+	eventByte, err := r.reader.ReadByte()
+	if err != nil {
 		return
-	case COMMENT:
-		var data string
-		data, err = r.readUTF()
+	}
+	event.Type = EventType(eventByte & 0x0f)
+	switch event.Type {
+	case EventStartDocument, EventEndDocument:
+	case EventStartTag:
+		event.Name, err = r.readInternedUTF()
 		if err != nil {
 			return
 		}
-		return xml.Comment(data), nil
-	case DOCDECL:
-		_, err = r.readUTF()
-		return
-	case IgnorableWhitespace:
-		_, err = r.readUTF()
-		return
-	case EntityRef:
-		_, err = r.readUTF()
-		return
+		event.Attrs, err = r.readAttributes()
+	case EventEndTag:
+		event.Name, err = r.readInternedUTF()
+	case EventText, EventCDSect, EventProcessingInstruction, EventComment, EventDocDecl, EventIgnorableWhitespace, EventEntityRef:
+		event.Text, err = r.readUTF()
 	case ATTRIBUTE:
-		_, err = r.readAttribute()
-		return
+		err = E.New("unexpected attribute outside of a start tag")
+	default:
+		err = E.New("unknown token type ", eventByte&0x0f, " with type ", eventByte&0xf0)
 	}
-	return nil, E.New("unknown token type ", tokenType, " with type ", eventType)
+	return
 }
 
-func (r *Reader) readAttributes() ([]xml.Attr, error) {
-	var attrs []xml.Attr
+func (r *Reader) readAttributes() ([]Attr, error) {
+	var attrs []Attr
 	for {
 		attr, err := r.readAttribute()
 		if err == io.EOF {
 			break
+		} else if err != nil {
+			return nil, err
 		}
 		attrs = append(attrs, attr)
 	}
 	return attrs, nil
 }
 
-func (r *Reader) readAttribute() (xml.Attr, error) {
+func (r *Reader) readAttribute() (Attr, error) {
 	event, err := r.reader.ReadByte()
 	if err != nil {
-		return xml.Attr{}, nil
+		return Attr{}, err
 	}
 	tokenType := event & 0x0f
 	eventType := event & 0xf0
 	if tokenType != ATTRIBUTE {
 		err = r.reader.UnreadByte()
 		if err != nil {
-			return xml.Attr{}, nil
+			return Attr{}, err
 		}
-		return xml.Attr{}, io.EOF
+		return Attr{}, io.EOF
 	}
 	name, err := r.readInternedUTF()
 	if err != nil {
-		return xml.Attr{}, err
+		return Attr{}, err
 	}
-	var value string
+	attr := Attr{Name: name, Type: AttrType(eventType)}
 	switch eventType {
 	case TypeNull:
-		value = ""
 	case TypeBooleanTrue:
-		value = "true"
+		attr.Type = AttrBoolean
+		attr.Value = true
 	case TypeBooleanFalse:
-		value = "false"
+		attr.Type = AttrBoolean
+		attr.Value = false
 	case TypeString:
-		value, err = r.readUTF()
-		if err != nil {
-			return xml.Attr{}, err
-		}
+		attr.Value, err = r.readUTF()
 	case TypeStringInterned:
-		value, err = r.readInternedUTF()
-		if err != nil {
-			return xml.Attr{}, err
-		}
-	case TypeBytesHex:
-		var data []byte
-		data, err = r.readBytes()
-		if err != nil {
-			return xml.Attr{}, err
-		}
-		value = hex.EncodeToString(data)
-	case TypeBytesBase64:
-		var data []byte
-		data, err = r.readBytes()
-		if err != nil {
-			return xml.Attr{}, err
-		}
-		value = base64.StdEncoding.EncodeToString(data)
-	case TypeInt:
-		var data int32
-		err = binary.Read(r.reader, binary.BigEndian, &data)
-		if err != nil {
-			return xml.Attr{}, err
-		}
-		value = strconv.FormatInt(int64(data), 10)
-	case TypeIntHex:
+		attr.Value, err = r.readInternedUTF()
+	case TypeBytesHex, TypeBytesBase64:
+		attr.Value, err = r.readBytes()
+	case TypeInt, TypeIntHex:
 		var data int32
 		err = binary.Read(r.reader, binary.BigEndian, &data)
-		if err != nil {
-			return xml.Attr{}, err
-		}
-		value = "0x" + strconv.FormatInt(int64(data), 16)
-	case TypeLong:
-		var data int64
-		err = binary.Read(r.reader, binary.BigEndian, &data)
-		if err != nil {
-			return xml.Attr{}, err
-		}
-		value = strconv.FormatInt(data, 10)
-	case TypeLongHex:
+		attr.Value = data
+	case TypeLong, TypeLongHex:
 		var data int64
 		err = binary.Read(r.reader, binary.BigEndian, &data)
-		if err != nil {
-			return xml.Attr{}, err
-		}
-		value = "0x" + strconv.FormatInt(data, 16)
+		attr.Value = data
 	case TypeFloat:
 		var data float32
 		err = binary.Read(r.reader, binary.BigEndian, &data)
-		if err != nil {
-			return xml.Attr{}, err
-		}
-		value = strconv.FormatFloat(float64(data), 'g', -1, 32)
+		attr.Value = data
 	case TypeDouble:
 		var data float64
 		err = binary.Read(r.reader, binary.BigEndian, &data)
-		if err != nil {
-			return xml.Attr{}, err
-		}
-		value = strconv.FormatFloat(data, 'g', -1, 64)
+		attr.Value = data
 	default:
-		return xml.Attr{}, E.New("unexpected attribute type, ", eventType)
+		return Attr{}, E.New("unexpected attribute type, ", eventType)
+	}
+	if err != nil {
+		return Attr{}, err
 	}
-	return xml.Attr{Name: xml.Name{Local: name}, Value: value}, nil
+	return attr, nil
 }
 
 func (r *Reader) readUnsignedShort() (uint16, error) {
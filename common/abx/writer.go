@@ -0,0 +1,332 @@
+package abx
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	. "github.com/sagernet/sing/common/abx/internal"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// Writer writes xml tokens as Android Binary XML.
+// Typed attributes can be written with the Attribute methods right after a start element.
+type Writer struct {
+	writer     *bufio.Writer
+	stringRefs map[string]uint16
+	started    bool
+	inTag      bool
+	depth      int
+}
+
+func NewWriter(writer io.Writer) *Writer {
+	return &Writer{
+		writer:     bufio.NewWriter(writer),
+		stringRefs: make(map[string]uint16),
+	}
+}
+
+func (w *Writer) EncodeToken(token xml.Token) error {
+	err := w.start()
+	if err != nil {
+		return err
+	}
+	w.inTag = false
+	switch token := token.(type) {
+	case xml.StartElement:
+		w.writer.WriteByte(StartTag | TypeStringInterned)
+		err = w.writeInternedUTF(joinName(token.Name))
+		if err != nil {
+			return err
+		}
+		w.depth++
+		w.inTag = true
+		for _, attr := range token.Attr {
+			err = w.Attribute(joinName(attr.Name), attr.Value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case xml.EndElement:
+		if w.depth == 0 {
+			return E.New("unexpected end element ", joinName(token.Name))
+		}
+		w.depth--
+		w.writer.WriteByte(EndTag | TypeStringInterned)
+		return w.writeInternedUTF(joinName(token.Name))
+	case xml.CharData:
+		w.writer.WriteByte(TEXT | TypeString)
+		return w.writeUTF(string(token))
+	case xml.Comment:
+		w.writer.WriteByte(COMMENT | TypeString)
+		return w.writeUTF(string(token))
+	case xml.ProcInst:
+		if token.Target == "xml" {
+			return nil
+		}
+		w.writer.WriteByte(ProcessingInstruction | TypeString)
+		return w.writeUTF(token.Target + " " + string(token.Inst))
+	case xml.Directive:
+		directive := string(token)
+		if strings.HasPrefix(directive, "[CDATA[") && strings.HasSuffix(directive, "]]") {
+			w.writer.WriteByte(CDSECT | TypeString)
+			return w.writeUTF(directive[7 : len(directive)-2])
+		}
+		w.writer.WriteByte(DOCDECL | TypeString)
+		return w.writeUTF(directive)
+	default:
+		return E.New("unsupported token type: ", token)
+	}
+}
+
+// Close writes the end of the document and flushes the buffered data, without closing the underlying writer.
+func (w *Writer) Close() error {
+	err := w.start()
+	if err != nil {
+		return err
+	}
+	if w.depth > 0 {
+		return E.New("unclosed elements: ", w.depth)
+	}
+	w.inTag = false
+	w.writer.WriteByte(EndDocument | TypeNull)
+	return w.writer.Flush()
+}
+
+func (w *Writer) Flush() error {
+	return w.writer.Flush()
+}
+
+func (w *Writer) Attribute(name string, value string) error {
+	return w.writeAttribute(TypeString, name, func() error {
+		return w.writeUTF(value)
+	})
+}
+
+func (w *Writer) AttributeInterned(name string, value string) error {
+	return w.writeAttribute(TypeStringInterned, name, func() error {
+		return w.writeInternedUTF(value)
+	})
+}
+
+func (w *Writer) AttributeBytesHex(name string, value []byte) error {
+	return w.writeAttribute(TypeBytesHex, name, func() error {
+		return w.writeBytes(value)
+	})
+}
+
+func (w *Writer) AttributeBytesBase64(name string, value []byte) error {
+	return w.writeAttribute(TypeBytesBase64, name, func() error {
+		return w.writeBytes(value)
+	})
+}
+
+func (w *Writer) AttributeInt(name string, value int32) error {
+	return w.writeAttribute(TypeInt, name, func() error {
+		return binary.Write(w.writer, binary.BigEndian, value)
+	})
+}
+
+func (w *Writer) AttributeIntHex(name string, value int32) error {
+	return w.writeAttribute(TypeIntHex, name, func() error {
+		return binary.Write(w.writer, binary.BigEndian, value)
+	})
+}
+
+func (w *Writer) AttributeLong(name string, value int64) error {
+	return w.writeAttribute(TypeLong, name, func() error {
+		return binary.Write(w.writer, binary.BigEndian, value)
+	})
+}
+
+func (w *Writer) AttributeLongHex(name string, value int64) error {
+	return w.writeAttribute(TypeLongHex, name, func() error {
+		return binary.Write(w.writer, binary.BigEndian, value)
+	})
+}
+
+func (w *Writer) AttributeFloat(name string, value float32) error {
+	return w.writeAttribute(TypeFloat, name, func() error {
+		return binary.Write(w.writer, binary.BigEndian, math.Float32bits(value))
+	})
+}
+
+func (w *Writer) AttributeDouble(name string, value float64) error {
+	return w.writeAttribute(TypeDouble, name, func() error {
+		return binary.Write(w.writer, binary.BigEndian, math.Float64bits(value))
+	})
+}
+
+func (w *Writer) AttributeBoolean(name string, value bool) error {
+	if value {
+		return w.writeAttribute(TypeBooleanTrue, name, nil)
+	} else {
+		return w.writeAttribute(TypeBooleanFalse, name, nil)
+	}
+}
+
+// WriteEvent writes an event read by Reader.Next, keeping the types of attributes.
+func (w *Writer) WriteEvent(event Event) error {
+	switch event.Type {
+	case EventStartDocument:
+		return w.start()
+	case EventEndDocument:
+		return w.Close()
+	case EventStartTag:
+		err := w.EncodeToken(xml.StartElement{Name: xml.Name{Local: event.Name}})
+		if err != nil {
+			return err
+		}
+		for _, attr := range event.Attrs {
+			err = w.WriteAttr(attr)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case EventEndTag:
+		return w.EncodeToken(xml.EndElement{Name: xml.Name{Local: event.Name}})
+	case EventText, EventCDSect, EventEntityRef, EventIgnorableWhitespace, EventProcessingInstruction, EventComment, EventDocDecl:
+		err := w.start()
+		if err != nil {
+			return err
+		}
+		w.inTag = false
+		w.writer.WriteByte(byte(event.Type) | TypeString)
+		return w.writeUTF(event.Text)
+	default:
+		return E.New("unknown event type ", event.Type)
+	}
+}
+
+// WriteAttr writes an attribute read by Reader.Next, keeping its type.
+func (w *Writer) WriteAttr(attr Attr) error {
+	switch attr.Type {
+	case AttrNull:
+		return w.writeAttribute(TypeNull, attr.Name, nil)
+	case AttrString, AttrStringInterned:
+		value, isString := attr.Value.(string)
+		if !isString {
+			break
+		}
+		if attr.Type == AttrStringInterned {
+			return w.AttributeInterned(attr.Name, value)
+		}
+		return w.Attribute(attr.Name, value)
+	case AttrBytesHex, AttrBytesBase64:
+		value, isBytes := attr.Value.([]byte)
+		if !isBytes {
+			break
+		}
+		if attr.Type == AttrBytesBase64 {
+			return w.AttributeBytesBase64(attr.Name, value)
+		}
+		return w.AttributeBytesHex(attr.Name, value)
+	case AttrInt, AttrIntHex:
+		value, isInt := attr.Value.(int32)
+		if !isInt {
+			break
+		}
+		if attr.Type == AttrIntHex {
+			return w.AttributeIntHex(attr.Name, value)
+		}
+		return w.AttributeInt(attr.Name, value)
+	case AttrLong, AttrLongHex:
+		value, isLong := attr.Value.(int64)
+		if !isLong {
+			break
+		}
+		if attr.Type == AttrLongHex {
+			return w.AttributeLongHex(attr.Name, value)
+		}
+		return w.AttributeLong(attr.Name, value)
+	case AttrFloat:
+		if value, isFloat := attr.Value.(float32); isFloat {
+			return w.AttributeFloat(attr.Name, value)
+		}
+	case AttrDouble:
+		if value, isDouble := attr.Value.(float64); isDouble {
+			return w.AttributeDouble(attr.Name, value)
+		}
+	case AttrBoolean:
+		if value, isBool := attr.Value.(bool); isBool {
+			return w.AttributeBoolean(attr.Name, value)
+		}
+	default:
+		return E.New("attribute ", attr.Name, ": unknown type ", attr.Type)
+	}
+	return E.New("attribute ", attr.Name, ": invalid value for type ", attr.Type, ": ", fmt.Sprintf("%T", attr.Value))
+}
+
+func (w *Writer) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	_, err := w.writer.Write(ProtocolMagicVersion0)
+	if err != nil {
+		return err
+	}
+	return w.writer.WriteByte(StartDocument | TypeNull)
+}
+
+func (w *Writer) writeAttribute(attributeType byte, name string, writeValue func() error) error {
+	if !w.inTag {
+		return E.New("attribute ", name, " written outside of a start element")
+	}
+	w.writer.WriteByte(ATTRIBUTE | attributeType)
+	err := w.writeInternedUTF(name)
+	if err != nil {
+		return err
+	}
+	if writeValue == nil {
+		return nil
+	}
+	return writeValue()
+}
+
+func (w *Writer) writeInternedUTF(utf string) error {
+	if ref, loaded := w.stringRefs[utf]; loaded {
+		return binary.Write(w.writer, binary.BigEndian, ref)
+	}
+	err := binary.Write(w.writer, binary.BigEndian, uint16(MaxUnsignedShort))
+	if err != nil {
+		return err
+	}
+	err = w.writeUTF(utf)
+	if err != nil {
+		return err
+	}
+	if len(w.stringRefs) < MaxUnsignedShort {
+		w.stringRefs[utf] = uint16(len(w.stringRefs))
+	}
+	return nil
+}
+
+func (w *Writer) writeUTF(utf string) error {
+	return w.writeBytes([]byte(utf))
+}
+
+func (w *Writer) writeBytes(data []byte) error {
+	if len(data) > MaxUnsignedShort {
+		return E.New("data too long: ", len(data), " bytes, max ", MaxUnsignedShort)
+	}
+	err := binary.Write(w.writer, binary.BigEndian, uint16(len(data)))
+	if err != nil {
+		return err
+	}
+	_, err = w.writer.Write(data)
+	return err
+}
+
+func joinName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
@@ -1,6 +1,10 @@
 package auth
 
-import "github.com/sagernet/sing/common"
+import (
+	"net"
+
+	"github.com/sagernet/sing/common"
+)
 
 type User struct {
 	Username string
@@ -8,7 +12,8 @@ type User struct {
 }
 
 type Authenticator struct {
-	userMap map[string][]string
+	userMap           map[string][]string
+	certificateMapper *CertificateMapper
 }
 
 func NewAuthenticator(users []User) *Authenticator {
@@ -24,7 +29,41 @@ func NewAuthenticator(users []User) *Authenticator {
 	return au
 }
 
+// NewCertificateAuthenticator also accepts users identified by a verified TLS client certificate.
+// If users is not empty, mapped names must be one of them.
+func NewCertificateAuthenticator(users []User, mapper *CertificateMapper) *Authenticator {
+	if mapper == nil {
+		return NewAuthenticator(users)
+	}
+	au := &Authenticator{
+		userMap:           make(map[string][]string),
+		certificateMapper: mapper,
+	}
+	for _, user := range users {
+		au.userMap[user.Username] = append(au.userMap[user.Username], user.Password)
+	}
+	return au
+}
+
 func (au *Authenticator) Verify(username string, password string) bool {
 	passwordList, ok := au.userMap[username]
 	return ok && common.Contains(passwordList, password)
 }
+
+// VerifyConn returns the user identified by the client certificate of conn, if any.
+// It is safe to call on a nil Authenticator.
+func (au *Authenticator) VerifyConn(conn net.Conn) (string, bool) {
+	if au == nil || au.certificateMapper == nil {
+		return "", false
+	}
+	username, loaded := au.certificateMapper.MapConn(conn)
+	if !loaded {
+		return "", false
+	}
+	if len(au.userMap) > 0 {
+		if _, ok := au.userMap[username]; !ok {
+			return "", false
+		}
+	}
+	return username, true
+}
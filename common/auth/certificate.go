@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/url"
+	"regexp"
+
+	"github.com/sagernet/sing/common"
+)
+
+type CertificateField uint8
+
+const (
+	CertificateFieldCommonName CertificateField = iota
+	CertificateFieldSPIFFEID
+	CertificateFieldURI
+	CertificateFieldEmail
+	CertificateFieldDNSName
+)
+
+func (f CertificateField) String() string {
+	switch f {
+	case CertificateFieldCommonName:
+		return "common_name"
+	case CertificateFieldSPIFFEID:
+		return "spiffe_id"
+	case CertificateFieldURI:
+		return "uri"
+	case CertificateFieldEmail:
+		return "email"
+	case CertificateFieldDNSName:
+		return "dns_name"
+	default:
+		return "unknown"
+	}
+}
+
+// CertificateRule maps one field of a verified client certificate to a user name.
+// If Match is set, only matching values are accepted and Username may reference its
+// submatches ($1, ${name}); an empty Username uses the value itself.
+type CertificateRule struct {
+	Field    CertificateField
+	Match    *regexp.Regexp
+	Username string
+}
+
+type CertificateMapper struct {
+	rules []CertificateRule
+}
+
+func NewCertificateMapper(rules []CertificateRule) *CertificateMapper {
+	if len(rules) == 0 {
+		return nil
+	}
+	return &CertificateMapper{rules}
+}
+
+// Map returns the user name derived from the first rule that matches certificate.
+func (m *CertificateMapper) Map(certificate *x509.Certificate) (string, bool) {
+	for _, rule := range m.rules {
+		for _, value := range certificateValues(certificate, rule.Field) {
+			if value == "" {
+				continue
+			}
+			if rule.Match == nil {
+				if rule.Username != "" {
+					return rule.Username, true
+				}
+				return value, true
+			}
+			submatches := rule.Match.FindStringSubmatchIndex(value)
+			if submatches == nil {
+				continue
+			}
+			if rule.Username == "" {
+				return value, true
+			}
+			return string(rule.Match.ExpandString(nil, rule.Username, value, submatches)), true
+		}
+	}
+	return "", false
+}
+
+// MapConn maps the verified peer certificate of a TLS connection found below conn.
+// Connections without a verified client certificate are not mapped.
+func (m *CertificateMapper) MapConn(conn net.Conn) (string, bool) {
+	state, loaded := ConnectionState(conn)
+	if !loaded || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return "", false
+	}
+	return m.Map(state.PeerCertificates[0])
+}
+
+type connectionStateConn interface {
+	ConnectionState() tls.ConnectionState
+}
+
+func ConnectionState(conn net.Conn) (tls.ConnectionState, bool) {
+	stateConn, loaded := common.Cast[connectionStateConn](conn)
+	if !loaded {
+		return tls.ConnectionState{}, false
+	}
+	state := stateConn.ConnectionState()
+	return state, state.HandshakeComplete
+}
+
+func certificateValues(certificate *x509.Certificate, field CertificateField) []string {
+	switch field {
+	case CertificateFieldCommonName:
+		return []string{certificate.Subject.CommonName}
+	case CertificateFieldSPIFFEID:
+		// an X.509-SVID carries exactly one URI SAN
+		if len(certificate.URIs) == 1 && certificate.URIs[0].Scheme == "spiffe" {
+			return []string{certificate.URIs[0].String()}
+		}
+		return nil
+	case CertificateFieldURI:
+		return common.Map(certificate.URIs, func(it *url.URL) string {
+			return it.String()
+		})
+	case CertificateFieldEmail:
+		return certificate.EmailAddresses
+	case CertificateFieldDNSName:
+		return certificate.DNSNames
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificateMapper(t *testing.T) {
+	t.Parallel()
+	spiffeID, _ := url.Parse("spiffe://example.org/ns/prod/sa/proxy")
+	certificate := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "alice"},
+		URIs:           []*url.URL{spiffeID},
+		EmailAddresses: []string{"alice@example.com"},
+	}
+	mapper := NewCertificateMapper([]CertificateRule{
+		{Field: CertificateFieldSPIFFEID, Match: regexp.MustCompile(`^spiffe://example\.org/ns/(\w+)/sa/(\w+)$`), Username: "$2@$1"},
+		{Field: CertificateFieldCommonName},
+	})
+	username, loaded := mapper.Map(certificate)
+	require.True(t, loaded)
+	require.Equal(t, "proxy@prod", username)
+
+	certificate.URIs = nil
+	username, loaded = mapper.Map(certificate)
+	require.True(t, loaded)
+	require.Equal(t, "alice", username)
+
+	mapper = NewCertificateMapper([]CertificateRule{
+		{Field: CertificateFieldEmail, Match: regexp.MustCompile(`@example\.net$`)},
+	})
+	_, loaded = mapper.Map(certificate)
+	require.False(t, loaded)
+	require.Nil(t, NewCertificateMapper(nil))
+}
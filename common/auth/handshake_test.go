@@ -0,0 +1,219 @@
+package auth_test
+
+import (
+	std_bufio "bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sagernet/sing/common/auth"
+	"github.com/sagernet/sing/common/idna"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	sHTTP "github.com/sagernet/sing/protocol/http"
+	"github.com/sagernet/sing/protocol/socks"
+	"github.com/sagernet/sing/protocol/socks/socks4"
+	"github.com/sagernet/sing/protocol/socks/socks5"
+
+	"github.com/stretchr/testify/require"
+)
+
+type userHandler struct {
+	users chan string
+}
+
+func (h *userHandler) NewConnection(ctx context.Context, conn net.Conn, metadata M.Metadata) error {
+	user, _ := auth.UserFromContext[string](ctx)
+	h.users <- user
+	return nil
+}
+
+func (h *userHandler) NewPacketConnection(ctx context.Context, conn N.PacketConn, metadata M.Metadata) error {
+	return nil
+}
+
+func newCertificate(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = template, key
+	}
+	certificateDER, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(certificateDER)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{certificateDER}, PrivateKey: key, Leaf: certificate}, certificate
+}
+
+// tlsPipe returns both ends of a TLS connection over loopback, the client presenting a certificate for clientName if it is not empty.
+func tlsPipe(t *testing.T, clientName string) (*tls.Conn, *tls.Conn) {
+	caCertificate, ca := newCertificate(t, "ca", nil, nil)
+	serverCertificate, _ := newCertificate(t, "server", ca, caCertificate.PrivateKey.(*ecdsa.PrivateKey))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	clientConfig := &tls.Config{InsecureSkipVerify: true}
+	if clientName != "" {
+		clientCertificate, _ := newCertificate(t, clientName, ca, caCertificate.PrivateKey.(*ecdsa.PrivateKey))
+		clientConfig.Certificates = []tls.Certificate{clientCertificate}
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	server, err := listener.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return tls.Client(client, clientConfig), tls.Server(server, &tls.Config{
+		Certificates: []tls.Certificate{serverCertificate},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+}
+
+func newTestAuthenticator() *auth.Authenticator {
+	return auth.NewCertificateAuthenticator([]auth.User{
+		{Username: "alice", Password: "password"},
+		{Username: "bob", Password: "password"},
+	}, auth.NewCertificateMapper([]auth.CertificateRule{
+		{Field: auth.CertificateFieldCommonName},
+	}))
+}
+
+func serveSocks(t *testing.T, server net.Conn, authenticator *auth.Authenticator) (*userHandler, chan error) {
+	handler := &userHandler{users: make(chan string, 1)}
+	done := make(chan error, 1)
+	go func() {
+		done <- socks.HandleConnection(context.Background(), server, authenticator, handler, M.Metadata{})
+	}()
+	return handler, done
+}
+
+func TestSocks4CertificateAuth(t *testing.T) {
+	t.Parallel()
+	destination := M.ParseSocksaddr("1.1.1.1:443")
+	client, server := tlsPipe(t, "alice")
+	handler, done := serveSocks(t, server, newTestAuthenticator())
+	_, err := socks.ClientHandshake4(client, socks4.CommandConnect, destination, "bob")
+	require.NoError(t, err)
+	require.Equal(t, "alice", <-handler.users)
+	require.NoError(t, <-done)
+
+	client, server = tlsPipe(t, "")
+	_, done = serveSocks(t, server, newTestAuthenticator())
+	_, err = socks.ClientHandshake4(client, socks4.CommandConnect, destination, "mallory")
+	require.Error(t, err)
+	require.Error(t, <-done)
+}
+
+func TestSocks5CertificateAuth(t *testing.T) {
+	t.Parallel()
+	destination := M.ParseSocksaddr("1.1.1.1:443")
+	client, server := tlsPipe(t, "alice")
+	handler, done := serveSocks(t, server, newTestAuthenticator())
+	_, err := socks.ClientHandshake5(client, socks5.CommandConnect, destination, "", "")
+	require.NoError(t, err)
+	require.Equal(t, "alice", <-handler.users)
+	require.NoError(t, <-done)
+
+	// the certificate user takes precedence over invalid credentials
+	client, server = tlsPipe(t, "alice")
+	handler, done = serveSocks(t, server, newTestAuthenticator())
+	_, err = socks.ClientHandshake5(client, socks5.CommandConnect, destination, "bob", "wrong")
+	require.NoError(t, err)
+	require.Equal(t, "alice", <-handler.users)
+	require.NoError(t, <-done)
+
+	// certificates of unknown users fall back to username and password
+	client, server = tlsPipe(t, "mallory")
+	handler, done = serveSocks(t, server, newTestAuthenticator())
+	_, err = socks.ClientHandshake5(client, socks5.CommandConnect, destination, "bob", "password")
+	require.NoError(t, err)
+	require.Equal(t, "bob", <-handler.users)
+	require.NoError(t, <-done)
+
+	client, server = tlsPipe(t, "mallory")
+	_, done = serveSocks(t, server, newTestAuthenticator())
+	_, err = socks.ClientHandshake5(client, socks5.CommandConnect, destination, "bob", "wrong")
+	require.Error(t, err)
+	require.Error(t, <-done)
+}
+
+func TestHTTPCertificateAuth(t *testing.T) {
+	t.Parallel()
+	connect := func(clientName string) (int, *userHandler, chan error) {
+		client, server := tlsPipe(t, clientName)
+		handler := &userHandler{users: make(chan string, 1)}
+		done := make(chan error, 1)
+		go func() {
+			done <- sHTTP.HandleConnection(context.Background(), server, std_bufio.NewReader(server), newTestAuthenticator(), handler, M.Metadata{})
+			server.Close()
+		}()
+		request, err := http.NewRequest(http.MethodConnect, "http://1.1.1.1:443", nil)
+		require.NoError(t, err)
+		require.NoError(t, request.Write(client))
+		response, err := http.ReadResponse(std_bufio.NewReader(client), request)
+		require.NoError(t, err)
+		return response.StatusCode, handler, done
+	}
+	statusCode, handler, done := connect("alice")
+	require.Equal(t, http.StatusOK, statusCode)
+	require.Equal(t, "alice", <-handler.users)
+	require.NoError(t, <-done)
+
+	statusCode, _, done = connect("mallory")
+	require.Equal(t, http.StatusProxyAuthRequired, statusCode)
+	require.Error(t, <-done)
+}
+
+func TestHandshakeInvalidDomain(t *testing.T) {
+	t.Parallel()
+	destination := M.Socksaddr{Fqdn: "xn--example.com", Port: 443}
+	client, server := tlsPipe(t, "")
+	_, done := serveSocks(t, server, nil)
+	_, err := socks.ClientHandshake4(client, socks4.CommandConnect, destination, "")
+	require.Error(t, err)
+	require.ErrorIs(t, <-done, idna.ErrInvalidDomain)
+
+	client, server = tlsPipe(t, "")
+	_, done = serveSocks(t, server, nil)
+	_, err = socks.ClientHandshake5(client, socks5.CommandConnect, destination, "", "")
+	require.Error(t, err)
+	require.ErrorIs(t, <-done, idna.ErrInvalidDomain)
+
+	client, server = tlsPipe(t, "")
+	done = make(chan error, 1)
+	go func() {
+		done <- sHTTP.HandleConnection(context.Background(), server, std_bufio.NewReader(server), nil, &userHandler{}, M.Metadata{})
+		server.Close()
+	}()
+	request, err := http.NewRequest(http.MethodConnect, "http://xn--example.com:443", nil)
+	require.NoError(t, err)
+	require.NoError(t, request.Write(client))
+	response, err := http.ReadResponse(std_bufio.NewReader(client), request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	require.ErrorIs(t, <-done, idna.ErrInvalidDomain)
+}
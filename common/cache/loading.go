@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing/common"
+)
+
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// the following are only counted by LoadingCache
+	Loads        uint64
+	LoadErrors   uint64
+	NegativeHits uint64
+	// StaleHits counts stale values returned while they are reloaded in the background
+	StaleHits uint64
+}
+
+type Loader[K comparable, V any] func(ctx context.Context, key K) (V, error)
+
+// LoadingCache is a LruCache that loads missing values with a loader.
+// Concurrent loads of the same key are deduplicated, and loader errors are cached for the WithNegativeAge duration.
+// With WithStale, expired values are returned while they are reloaded in the background.
+type LoadingCache[K comparable, V any] struct {
+	*LruCache[K, V]
+	loader   Loader[K, V]
+	negative *LruCache[K, error]
+	access   sync.Mutex
+	calls    map[K]*loadCall[V]
+	stats    Stats
+}
+
+type loadCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+func NewLoading[K comparable, V any](loader Loader[K, V], options ...Option[K, V]) *LoadingCache[K, V] {
+	c := &LoadingCache[K, V]{
+		LruCache: New[K, V](options...),
+		loader:   loader,
+		calls:    make(map[K]*loadCall[V]),
+	}
+	if c.negativeAge > 0 {
+		negativeOptions := []Option[K, error]{WithAge[K, error](c.negativeAge), WithDisabledCleaner[K, error]()}
+		if c.maxSize > 0 {
+			negativeOptions = append(negativeOptions, WithSize[K, error](c.maxSize))
+		}
+		c.negative = New[K, error](negativeOptions...)
+	}
+	return c
+}
+
+// Get returns the cached value of a key, or loads it.
+// The loader runs with the values of the context of the first caller, but is not canceled with it.
+// Other callers of the same key share its result, and each caller stops waiting when its own context is done.
+func (c *LoadingCache[K, V]) Get(ctx context.Context, key K) (V, error) {
+	if c.negative != nil {
+		if err, loaded := c.negative.Load(key); loaded {
+			c.access.Lock()
+			c.stats.NegativeHits++
+			c.access.Unlock()
+			return common.DefaultValue[V](), err
+		}
+	}
+	value, expires, loaded := c.LoadWithExpire(key)
+	if loaded {
+		if c.staleReturn && expires.Unix() != 0 && !expires.After(time.Now()) {
+			c.access.Lock()
+			c.stats.StaleHits++
+			c.access.Unlock()
+			go c.load(context.Background(), key)
+		}
+		return value, nil
+	}
+	return c.Refresh(ctx, key)
+}
+
+// Refresh loads the value of a key, replacing the cached value.
+func (c *LoadingCache[K, V]) Refresh(ctx context.Context, key K) (V, error) {
+	call := c.load(ctx, key)
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		return common.DefaultValue[V](), ctx.Err()
+	}
+}
+
+func (c *LoadingCache[K, V]) load(ctx context.Context, key K) *loadCall[V] {
+	c.access.Lock()
+	call, loading := c.calls[key]
+	if loading {
+		c.access.Unlock()
+		return call
+	}
+	call = &loadCall[V]{done: make(chan struct{})}
+	c.calls[key] = call
+	c.access.Unlock()
+	go func() {
+		defer close(call.done)
+		call.value, call.err = c.loader(valueContext{ctx}, key)
+		if call.err == nil {
+			c.Store(key, call.value)
+			if c.negative != nil {
+				c.negative.Delete(key)
+			}
+		} else if c.negative != nil && !c.Exist(key) {
+			// keep serving stale values when reloading them fails
+			c.negative.Store(key, call.err)
+		}
+		c.access.Lock()
+		delete(c.calls, key)
+		c.stats.Loads++
+		if call.err != nil {
+			c.stats.LoadErrors++
+		}
+		c.access.Unlock()
+	}()
+	return call
+}
+
+// Delete removes the value and the cached error of a key.
+func (c *LoadingCache[K, V]) Delete(key K) {
+	c.LruCache.Delete(key)
+	if c.negative != nil {
+		c.negative.Delete(key)
+	}
+}
+
+func (c *LoadingCache[K, V]) Clear() {
+	c.LruCache.Clear()
+	if c.negative != nil {
+		c.negative.Clear()
+	}
+}
+
+func (c *LoadingCache[K, V]) Stats() Stats {
+	stats := c.LruCache.Stats()
+	c.access.Lock()
+	defer c.access.Unlock()
+	stats.Loads = c.stats.Loads
+	stats.LoadErrors = c.stats.LoadErrors
+	stats.NegativeHits = c.stats.NegativeHits
+	stats.StaleHits = c.stats.StaleHits
+	return stats
+}
+
+// valueContext keeps the values of a context without its deadline and cancellation.
+type valueContext struct {
+	context.Context
+}
+
+func (valueContext) Deadline() (deadline time.Time, ok bool) {
+	return
+}
+
+func (valueContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (valueContext) Err() error {
+	return nil
+}
@@ -0,0 +1,143 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sagernet/sing/common/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadingSingleflight(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	c := cache.NewLoading[string, int](func(ctx context.Context, key string) (int, error) {
+		calls.Add(1)
+		<-release
+		return len(key), nil
+	}, cache.WithDisabledCleaner[string, int]())
+	var group sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			value, err := c.Get(context.Background(), "key")
+			require.NoError(t, err)
+			require.Equal(t, 3, value)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	group.Wait()
+	require.Equal(t, int32(1), calls.Load())
+	value, err := c.Get(context.Background(), "key")
+	require.NoError(t, err)
+	require.Equal(t, 3, value)
+	require.Equal(t, int32(1), calls.Load())
+	stats := c.Stats()
+	require.Equal(t, uint64(1), stats.Loads)
+	require.Equal(t, uint64(1), stats.Hits)
+}
+
+type testContextKey struct{}
+
+func TestLoadingCanceledCaller(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	c := cache.NewLoading[string, string](func(ctx context.Context, key string) (string, error) {
+		<-release
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return ctx.Value(testContextKey{}).(string), nil
+	}, cache.WithDisabledCleaner[string, string]())
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), testContextKey{}, "value"))
+	done := make(chan error)
+	go func() {
+		_, err := c.Get(ctx, "key")
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+	close(release)
+	value, err := c.Get(context.Background(), "key")
+	require.NoError(t, err)
+	require.Equal(t, "value", value)
+}
+
+func TestLoadingNegative(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int32
+	loadErr := errors.New("not found")
+	c := cache.NewLoading[string, int](func(ctx context.Context, key string) (int, error) {
+		calls.Add(1)
+		return 0, loadErr
+	}, cache.WithNegativeAge[string, int](60), cache.WithDisabledCleaner[string, int]())
+	for i := 0; i < 3; i++ {
+		_, err := c.Get(context.Background(), "key")
+		require.ErrorIs(t, err, loadErr)
+	}
+	require.Equal(t, int32(1), calls.Load())
+	c.Delete("key")
+	_, err := c.Get(context.Background(), "key")
+	require.ErrorIs(t, err, loadErr)
+	require.Equal(t, int32(2), calls.Load())
+	stats := c.Stats()
+	require.Equal(t, uint64(2), stats.Loads)
+	require.Equal(t, uint64(2), stats.LoadErrors)
+	require.Equal(t, uint64(2), stats.NegativeHits)
+}
+
+func TestLoadingStale(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int32
+	c := cache.NewLoading[string, int32](func(ctx context.Context, key string) (int32, error) {
+		return calls.Add(1), nil
+	}, cache.WithAge[string, int32](60), cache.WithStale[string, int32](true), cache.WithDisabledCleaner[string, int32]())
+	c.StoreWithExpire("key", 0, time.Now().Add(-time.Second))
+	value, err := c.Get(context.Background(), "key")
+	require.NoError(t, err)
+	require.Equal(t, int32(0), value)
+	require.Eventually(t, func() bool {
+		value, _ = c.Load("key")
+		return value == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, uint64(1), c.Stats().StaleHits)
+}
+
+func TestWeight(t *testing.T) {
+	t.Parallel()
+	var evicted []string
+	c := cache.New[string, string](
+		cache.WithMaxWeight[string, string](10, func(key string, value string) int64 {
+			return int64(len(value))
+		}),
+		cache.WithEvict[string, string](func(key string, value string) {
+			evicted = append(evicted, key)
+		}),
+		cache.WithDisabledCleaner[string, string](),
+	)
+	c.Store("a", "1234")
+	c.Store("b", "1234")
+	c.Store("c", "12")
+	require.Empty(t, evicted)
+	_, loaded := c.Load("a")
+	require.True(t, loaded)
+	c.Store("d", "123")
+	require.Equal(t, []string{"b"}, evicted)
+	c.Store("a", "123456789")
+	require.Equal(t, []string{"b", "c", "d"}, evicted)
+	_, loaded = c.Load("b")
+	require.False(t, loaded)
+	stats := c.Stats()
+	require.Equal(t, uint64(1), stats.Hits)
+	require.Equal(t, uint64(1), stats.Misses)
+	require.Equal(t, uint64(3), stats.Evictions)
+}
@@ -40,6 +40,21 @@ func WithSize[K comparable, V any](maxSize int) Option[K, V] {
 	}
 }
 
+// WithMaxWeight bounds the total weight of entries instead of their count, evicting least-recent entries when exceeded.
+func WithMaxWeight[K comparable, V any](maxWeight int64, weigher func(key K, value V) int64) Option[K, V] {
+	return func(l *LruCache[K, V]) {
+		l.maxWeight = maxWeight
+		l.weigher = weigher
+	}
+}
+
+// WithNegativeAge sets how long LoadingCache caches loader errors, in seconds.
+func WithNegativeAge[K comparable, V any](negativeAge int64) Option[K, V] {
+	return func(l *LruCache[K, V]) {
+		l.negativeAge = negativeAge
+	}
+}
+
 func WithStale[K comparable, V any](stale bool) Option[K, V] {
 	return func(l *LruCache[K, V]) {
 		l.staleReturn = stale
@@ -55,6 +70,11 @@ func WithDisabledCleaner[K comparable, V any]() Option[K, V] {
 type LruCache[K comparable, V any] struct {
 	maxAge              int64
 	maxSize             int
+	maxWeight           int64
+	weigher             func(key K, value V) int64
+	weight              int64
+	negativeAge         int64
+	stats               Stats
 	mu                  sync.Mutex
 	cache               map[K]*list.Element[*entry[K, V]]
 	lru                 list.List[*entry[K, V]] // Front is least-recent
@@ -98,7 +118,7 @@ func (c *LruCache[K, V]) expiryWorker() {
 func (c *LruCache[K, V]) deleteExpired() {
 	for _, v := range c.cache {
 		if v.Value.expires != 0 && v.Value.expires <= time.Now().Unix() {
-			c.deleteElement(v)
+			c.evictElement(v)
 		}
 	}
 }
@@ -120,10 +140,11 @@ func (c *LruCache[K, V]) LoadOrStore(key K, constructor func() V) (V, bool) {
 	le, ok := c.cache[key]
 	if ok {
 		if c.maxAge > 0 && le.Value.expires <= time.Now().Unix() {
-			c.deleteElement(le)
+			c.evictElement(le)
 			goto create
 		}
 
+		c.stats.Hits++
 		c.lru.MoveToBack(le)
 		entry := le.Value
 		if c.maxAge > 0 && c.updateAgeOnGet {
@@ -133,17 +154,25 @@ func (c *LruCache[K, V]) LoadOrStore(key K, constructor func() V) (V, bool) {
 	}
 
 create:
+	c.stats.Misses++
 	value := constructor()
+	var expires int64
+	if c.maxAge > 0 {
+		expires = time.Now().Unix() + c.maxAge
+	}
 	if le, ok := c.cache[key]; ok {
 		c.lru.MoveToBack(le)
 		e := le.Value
-		e.value = value
-		e.expires = time.Now().Unix() + c.maxAge
+		c.setValue(e, value)
+		e.expires = expires
 	} else {
-		e := &entry[K, V]{key: key, value: value, expires: time.Now().Unix() + c.maxAge}
+		e := &entry[K, V]{key: key, expires: expires}
+		c.setValue(e, value)
 		c.cache[key] = c.lru.PushBack(e)
+		c.maybeDeleteOversize()
 	}
 
+	c.maybeDeleteOverweight()
 	c.maybeDeleteOldest()
 	return value, false
 }
@@ -159,10 +188,11 @@ func (c *LruCache[K, V]) LoadOrStoreWithAge(key K, maxAge int64, constructor fun
 	le, ok := c.cache[key]
 	if ok {
 		if c.maxAge > 0 && le.Value.expires <= time.Now().Unix() {
-			c.deleteElement(le)
+			c.evictElement(le)
 			goto create
 		}
 
+		c.stats.Hits++
 		c.lru.MoveToBack(le)
 		entry := le.Value
 		if c.maxAge > 0 && c.updateAgeOnGet {
@@ -172,17 +202,25 @@ func (c *LruCache[K, V]) LoadOrStoreWithAge(key K, maxAge int64, constructor fun
 	}
 
 create:
+	c.stats.Misses++
 	value := constructor()
+	var expires int64
+	if maxAge > 0 {
+		expires = time.Now().Unix() + maxAge
+	}
 	if le, ok := c.cache[key]; ok {
 		c.lru.MoveToBack(le)
 		e := le.Value
-		e.value = value
-		e.expires = time.Now().Unix() + maxAge
+		c.setValue(e, value)
+		e.expires = expires
 	} else {
-		e := &entry[K, V]{key: key, value: value, expires: time.Now().Unix() + c.maxAge}
+		e := &entry[K, V]{key: key, expires: expires}
+		c.setValue(e, value)
 		c.cache[key] = c.lru.PushBack(e)
+		c.maybeDeleteOversize()
 	}
 
+	c.maybeDeleteOverweight()
 	c.maybeDeleteOldest()
 	return value, false
 }
@@ -219,19 +257,16 @@ func (c *LruCache[K, V]) StoreWithExpire(key K, value V, expires time.Time) {
 	if le, ok := c.cache[key]; ok {
 		c.lru.MoveToBack(le)
 		e := le.Value
-		e.value = value
+		c.setValue(e, value)
 		e.expires = expires.Unix()
 	} else {
-		e := &entry[K, V]{key: key, value: value, expires: expires.Unix()}
+		e := &entry[K, V]{key: key, expires: expires.Unix()}
+		c.setValue(e, value)
 		c.cache[key] = c.lru.PushBack(e)
-
-		if c.maxSize > 0 {
-			if n := c.lru.Len(); n > c.maxSize {
-				c.deleteElement(c.lru.Front())
-			}
-		}
+		c.maybeDeleteOversize()
 	}
 
+	c.maybeDeleteOverweight()
 	c.maybeDeleteOldest()
 }
 
@@ -244,10 +279,12 @@ func (c *LruCache[K, V]) CloneTo(n *LruCache[K, V]) {
 
 	n.lru = list.List[*entry[K, V]]{}
 	n.cache = make(map[K]*list.Element[*entry[K, V]])
+	n.weight = 0
 
 	for e := c.lru.Front(); e != nil; e = e.Next() {
 		elm := e.Value
 		n.cache[elm.key] = n.lru.PushBack(elm)
+		n.weight += elm.weight
 	}
 }
 
@@ -265,16 +302,19 @@ func (c *LruCache[K, V]) get(key K) *entry[K, V] {
 
 	le, ok := c.cache[key]
 	if !ok {
+		c.stats.Misses++
 		return nil
 	}
 
 	if !c.staleReturn && le.Value.expires != 0 && le.Value.expires <= time.Now().Unix() {
-		c.deleteElement(le)
+		c.stats.Misses++
+		c.evictElement(le)
 		c.maybeDeleteOldest()
 
 		return nil
 	}
 
+	c.stats.Hits++
 	c.lru.MoveToBack(le)
 	entry := le.Value
 	if c.maxAge > 0 && c.updateAgeOnGet {
@@ -297,7 +337,7 @@ func (c *LruCache[K, V]) Delete(key K) {
 func (c *LruCache[K, V]) Clear() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	for element := c.lru.Front(); element != nil; element = element.Next() {
+	for element := c.lru.Front(); element != nil; element = c.lru.Front() {
 		c.deleteElement(element)
 	}
 }
@@ -306,15 +346,46 @@ func (c *LruCache[K, V]) maybeDeleteOldest() {
 	if !c.staleReturn && c.maxAge > 0 {
 		now := time.Now().Unix()
 		for le := c.lru.Front(); le != nil && le.Value.expires <= now; le = c.lru.Front() {
-			c.deleteElement(le)
+			c.evictElement(le)
+		}
+	}
+}
+
+func (c *LruCache[K, V]) maybeDeleteOversize() {
+	if c.maxSize > 0 {
+		if n := c.lru.Len(); n > c.maxSize {
+			c.evictElement(c.lru.Front())
+		}
+	}
+}
+
+func (c *LruCache[K, V]) maybeDeleteOverweight() {
+	if c.maxWeight > 0 {
+		for le := c.lru.Front(); le != nil && c.weight > c.maxWeight; le = c.lru.Front() {
+			c.evictElement(le)
 		}
 	}
 }
 
+func (c *LruCache[K, V]) setValue(e *entry[K, V], value V) {
+	e.value = value
+	if c.weigher != nil {
+		c.weight -= e.weight
+		e.weight = c.weigher(e.key, value)
+		c.weight += e.weight
+	}
+}
+
+func (c *LruCache[K, V]) evictElement(le *list.Element[*entry[K, V]]) {
+	c.stats.Evictions++
+	c.deleteElement(le)
+}
+
 func (c *LruCache[K, V]) deleteElement(le *list.Element[*entry[K, V]]) {
 	c.lru.Remove(le)
 	e := le.Value
 	delete(c.cache, e.key)
+	c.weight -= e.weight
 	c.onDelete()
 	if c.onEvict != nil {
 		c.onEvict(e.key, e.value)
@@ -333,8 +404,16 @@ func (c *LruCache[K, V]) onDelete() {
 	}
 }
 
+// Stats returns the statistics of the cache since it was created.
+func (c *LruCache[K, V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
 type entry[K comparable, V any] struct {
 	key     K
 	value   V
 	expires int64
+	weight  int64
 }
@@ -0,0 +1,38 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sagernet/sing/common/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOrStoreExpires(t *testing.T) {
+	t.Parallel()
+	constructor := func() int {
+		return 1
+	}
+
+	// entries of caches without max age never expire, as with Store
+	c := cache.New[string, int](cache.WithDisabledCleaner[string, int]())
+	c.LoadOrStore("a", constructor)
+	c.LoadOrStoreWithAge("b", 0, constructor)
+	for _, key := range []string{"a", "b"} {
+		_, expires, loaded := c.LoadWithExpire(key)
+		require.True(t, loaded, key)
+		require.Zero(t, expires.Unix(), key)
+	}
+
+	c = cache.New[string, int](cache.WithAge[string, int](60), cache.WithDisabledCleaner[string, int]())
+	now := time.Now().Unix()
+	c.LoadOrStore("a", constructor)
+	c.LoadOrStoreWithAge("b", 0, constructor)
+	c.LoadOrStoreWithAge("c", 600, constructor)
+	for key, age := range map[string]int64{"a": 60, "b": 60, "c": 600} {
+		_, expires, loaded := c.LoadWithExpire(key)
+		require.True(t, loaded, key)
+		require.InDelta(t, now+age, expires.Unix(), 1, key)
+	}
+}
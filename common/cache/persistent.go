@@ -0,0 +1,368 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/binary"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/varbin"
+	"github.com/sagernet/sing/service/filemanager"
+)
+
+const (
+	persistentVersion            = 1
+	persistentMaxRecordSize      = 64 << 20
+	defaultCompactThreshold      = 1024
+	persistentJournalSuffix      = ".journal"
+	persistentSnapshotTempSuffix = ".tmp"
+)
+
+type PersistentOptions struct {
+	// Path of the snapshot file, the journal is written next to it with a ".journal" suffix.
+	Path string
+	// CompactThreshold is the number of journal records that triggers writing a new snapshot, 1024 by default.
+	CompactThreshold int
+}
+
+// PersistentCache is a LruCache whose entries are kept in a snapshot file and an append-only journal.
+// The files are loaded by Start or on first access. If loading fails, Load, LoadWithExpire and Range see
+// an empty cache, and the error is returned by Start and by every method writing to the cache.
+// Each journal record is checksummed, so a record torn by a crash is dropped on the next load,
+// and snapshots are replaced atomically.
+// Recency and ages refreshed by reads are only persisted by compactions.
+// Keys and values must be encodable by varbin, which excludes platform-sized integers.
+type PersistentCache[K comparable, V any] struct {
+	ctx            context.Context
+	options        PersistentOptions
+	cache          *LruCache[K, V]
+	access         sync.Mutex
+	started        bool
+	startErr       error
+	journal        *os.File
+	journalRecords int
+}
+
+type persistentRecord[K comparable, V any] struct {
+	Delete  bool
+	Key     K
+	Value   V
+	Expires int64
+}
+
+func NewPersistent[K comparable, V any](ctx context.Context, options PersistentOptions, cacheOptions ...Option[K, V]) *PersistentCache[K, V] {
+	if options.CompactThreshold == 0 {
+		options.CompactThreshold = defaultCompactThreshold
+	}
+	return &PersistentCache[K, V]{
+		ctx:     ctx,
+		options: options,
+		cache:   New[K, V](cacheOptions...),
+	}
+}
+
+// Start loads the files, returning the error of the first attempt on later calls.
+func (c *PersistentCache[K, V]) Start() error {
+	c.access.Lock()
+	defer c.access.Unlock()
+	return c.start()
+}
+
+func (c *PersistentCache[K, V]) Load(key K) (V, bool) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.start()
+	return c.cache.Load(key)
+}
+
+func (c *PersistentCache[K, V]) LoadWithExpire(key K) (V, time.Time, bool) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.start()
+	return c.cache.LoadWithExpire(key)
+}
+
+func (c *PersistentCache[K, V]) Range(block func(key K, value V)) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.start()
+	c.cache.Range(block)
+}
+
+func (c *PersistentCache[K, V]) Stats() Stats {
+	return c.cache.Stats()
+}
+
+func (c *PersistentCache[K, V]) Store(key K, value V) error {
+	var expires int64
+	if c.cache.maxAge > 0 {
+		expires = time.Now().Unix() + c.cache.maxAge
+	}
+	return c.StoreWithExpire(key, value, time.Unix(expires, 0))
+}
+
+func (c *PersistentCache[K, V]) StoreWithExpire(key K, value V, expires time.Time) error {
+	c.access.Lock()
+	defer c.access.Unlock()
+	err := c.start()
+	if err != nil {
+		return err
+	}
+	c.cache.StoreWithExpire(key, value, expires)
+	return c.writeJournal(persistentRecord[K, V]{Key: key, Value: value, Expires: expires.Unix()})
+}
+
+func (c *PersistentCache[K, V]) LoadOrStore(key K, constructor func() V) (V, bool, error) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	err := c.start()
+	if err != nil {
+		return common.DefaultValue[V](), false, err
+	}
+	value, loaded := c.cache.LoadOrStore(key, constructor)
+	if loaded {
+		return value, true, nil
+	}
+	var expires int64
+	if c.cache.maxAge > 0 {
+		c.cache.mu.Lock()
+		if element, ok := c.cache.cache[key]; ok {
+			expires = element.Value.expires
+		}
+		c.cache.mu.Unlock()
+	}
+	return value, false, c.writeJournal(persistentRecord[K, V]{Key: key, Value: value, Expires: expires})
+}
+
+func (c *PersistentCache[K, V]) Delete(key K) error {
+	c.access.Lock()
+	defer c.access.Unlock()
+	err := c.start()
+	if err != nil {
+		return err
+	}
+	c.cache.Delete(key)
+	return c.writeJournal(persistentRecord[K, V]{Delete: true, Key: key})
+}
+
+func (c *PersistentCache[K, V]) Clear() error {
+	c.access.Lock()
+	defer c.access.Unlock()
+	err := c.start()
+	if err != nil {
+		return err
+	}
+	c.cache.Clear()
+	return c.compact()
+}
+
+// Compact writes the entries to a new snapshot and truncates the journal.
+func (c *PersistentCache[K, V]) Compact() error {
+	c.access.Lock()
+	defer c.access.Unlock()
+	err := c.start()
+	if err != nil {
+		return err
+	}
+	return c.compact()
+}
+
+// Close compacts the cache and closes the journal.
+func (c *PersistentCache[K, V]) Close() error {
+	c.access.Lock()
+	defer c.access.Unlock()
+	if !c.started || c.journal == nil {
+		return nil
+	}
+	err := c.compact()
+	closeErr := c.journal.Close()
+	c.journal = nil
+	c.started = false
+	return E.Errors(err, closeErr)
+}
+
+func (c *PersistentCache[K, V]) start() error {
+	if c.started {
+		return c.startErr
+	}
+	c.started = true
+	c.startErr = c.load()
+	if c.startErr != nil {
+		c.cache.Clear()
+	}
+	return c.startErr
+}
+
+func (c *PersistentCache[K, V]) load() error {
+	// a broken snapshot is discarded, as the cache can be rebuilt
+	c.readFile(c.options.Path)
+	journalRecords, journalSize := c.readFile(c.options.Path + persistentJournalSuffix)
+	journal, err := filemanager.OpenFile(c.ctx, c.options.Path+persistentJournalSuffix, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return E.Cause(err, "open cache journal")
+	}
+	// drop a torn tail before appending
+	err = journal.Truncate(journalSize)
+	if err == nil && journalSize == 0 {
+		_, err = journal.Write([]byte{persistentVersion})
+	}
+	if err == nil {
+		_, err = journal.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		journal.Close()
+		return E.Cause(err, "open cache journal")
+	}
+	c.journal = journal
+	c.journalRecords = journalRecords
+	if c.journalRecords >= c.options.CompactThreshold {
+		return c.compact()
+	}
+	return nil
+}
+
+// readFile applies the records of a file, returning the count and the size of the valid records.
+func (c *PersistentCache[K, V]) readFile(path string) (int, int64) {
+	file, err := filemanager.OpenFile(c.ctx, path, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, 0
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	version, err := reader.ReadByte()
+	if err != nil || version != persistentVersion {
+		return 0, 0
+	}
+	var (
+		records int
+		size    int64 = 1
+		now           = time.Now().Unix()
+	)
+	for {
+		record, recordSize, err := readRecord[K, V](reader)
+		if err != nil {
+			return records, size
+		}
+		records++
+		size += recordSize
+		if record.Delete {
+			c.cache.Delete(record.Key)
+		} else if record.Expires == 0 || record.Expires > now || c.cache.staleReturn {
+			c.cache.StoreWithExpire(record.Key, record.Value, time.Unix(record.Expires, 0))
+		} else {
+			c.cache.Delete(record.Key)
+		}
+	}
+}
+
+func (c *PersistentCache[K, V]) writeJournal(record persistentRecord[K, V]) error {
+	content, err := encodeRecord(record)
+	if err != nil {
+		return err
+	}
+	_, err = c.journal.Write(content)
+	if err != nil {
+		return E.Cause(err, "write cache journal")
+	}
+	c.journalRecords++
+	if c.journalRecords >= c.options.CompactThreshold {
+		return c.compact()
+	}
+	return nil
+}
+
+func (c *PersistentCache[K, V]) compact() error {
+	tempPath := c.options.Path + persistentSnapshotTempSuffix
+	file, err := filemanager.OpenFile(c.ctx, tempPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return E.Cause(err, "create cache snapshot")
+	}
+	err = c.writeSnapshot(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = filemanager.Rename(c.ctx, tempPath, c.options.Path)
+	}
+	if err != nil {
+		filemanager.Remove(c.ctx, tempPath)
+		return E.Cause(err, "write cache snapshot")
+	}
+	// replaying a journal that survived a crash here is harmless, as its records are already in the snapshot
+	err = c.journal.Truncate(1)
+	if err == nil {
+		_, err = c.journal.Seek(1, io.SeekStart)
+	}
+	if err != nil {
+		return E.Cause(err, "truncate cache journal")
+	}
+	c.journalRecords = 0
+	return nil
+}
+
+func (c *PersistentCache[K, V]) writeSnapshot(file *os.File) error {
+	writer := bufio.NewWriter(file)
+	writer.WriteByte(persistentVersion)
+	c.cache.mu.Lock()
+	now := time.Now().Unix()
+	for element := c.cache.lru.Front(); element != nil; element = element.Next() {
+		entry := element.Value
+		if entry.expires != 0 && entry.expires <= now && !c.cache.staleReturn {
+			continue
+		}
+		content, err := encodeRecord(persistentRecord[K, V]{Key: entry.key, Value: entry.value, Expires: entry.expires})
+		if err != nil {
+			c.cache.mu.Unlock()
+			return err
+		}
+		writer.Write(content)
+	}
+	c.cache.mu.Unlock()
+	return writer.Flush()
+}
+
+// encodeRecord frames a record as its length, its varbin encoding and a CRC32 checksum.
+func encodeRecord[K comparable, V any](record persistentRecord[K, V]) ([]byte, error) {
+	var payload bytes.Buffer
+	err := varbin.Write(&payload, binary.BigEndian, &record)
+	if err != nil {
+		return nil, E.Cause(err, "encode cache record")
+	}
+	content := binary.AppendUvarint(nil, uint64(payload.Len()))
+	content = append(content, payload.Bytes()...)
+	return binary.BigEndian.AppendUint32(content, crc32.ChecksumIEEE(payload.Bytes())), nil
+}
+
+func readRecord[K comparable, V any](reader *bufio.Reader) (record persistentRecord[K, V], size int64, err error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return
+	}
+	if length > persistentMaxRecordSize {
+		err = E.New("cache record too large: ", length)
+		return
+	}
+	content := make([]byte, length+4)
+	_, err = io.ReadFull(reader, content)
+	if err != nil {
+		return
+	}
+	payload := content[:length]
+	if binary.BigEndian.Uint32(content[length:]) != crc32.ChecksumIEEE(payload) {
+		err = E.New("cache record checksum mismatch")
+		return
+	}
+	err = varbin.Read(bytes.NewReader(payload), binary.BigEndian, &record)
+	size = int64(len(binary.AppendUvarint(nil, length))) + int64(len(content))
+	return
+}
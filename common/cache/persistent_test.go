@@ -0,0 +1,173 @@
+package cache_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sagernet/sing/common/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+type persistentValue struct {
+	Name      string
+	Addresses []string
+}
+
+func newPersistent(path string, threshold int) *cache.PersistentCache[string, persistentValue] {
+	return cache.NewPersistent[string, persistentValue](context.Background(), cache.PersistentOptions{
+		Path:             path,
+		CompactThreshold: threshold,
+	}, cache.WithAge[string, persistentValue](60), cache.WithDisabledCleaner[string, persistentValue]())
+}
+
+func TestPersistent(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c := newPersistent(path, 0)
+	require.NoError(t, c.Store("a", persistentValue{"a", []string{"1.1.1.1"}}))
+	require.NoError(t, c.Store("b", persistentValue{"b", nil}))
+	require.NoError(t, c.StoreWithExpire("expired", persistentValue{Name: "expired"}, time.Now().Add(-time.Second)))
+	require.NoError(t, c.Delete("b"))
+	value, loaded, err := c.LoadOrStore("c", func() persistentValue {
+		return persistentValue{Name: "c"}
+	})
+	require.NoError(t, err)
+	require.False(t, loaded)
+	require.Equal(t, "c", value.Name)
+
+	// without closing, as after a crash
+	reopened := newPersistent(path, 0)
+	value, loaded = reopened.Load("a")
+	require.True(t, loaded)
+	require.Equal(t, persistentValue{"a", []string{"1.1.1.1"}}, value)
+	_, _, loaded = reopened.LoadWithExpire("c")
+	require.True(t, loaded)
+	_, loaded = reopened.Load("b")
+	require.False(t, loaded)
+	_, loaded = reopened.Load("expired")
+	require.False(t, loaded)
+	require.NoError(t, reopened.Close())
+
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+	journal, err := os.Stat(path + ".journal")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), journal.Size())
+	reopened = newPersistent(path, 0)
+	_, loaded = reopened.Load("a")
+	require.True(t, loaded)
+	require.NoError(t, reopened.Clear())
+	require.NoError(t, reopened.Close())
+	reopened = newPersistent(path, 0)
+	_, loaded = reopened.Load("a")
+	require.False(t, loaded)
+}
+
+func TestPersistentTornJournal(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c := newPersistent(path, 0)
+	require.NoError(t, c.Store("a", persistentValue{Name: "a"}))
+	require.NoError(t, c.Store("b", persistentValue{Name: "b"}))
+	journal, err := os.ReadFile(path + ".journal")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path+".journal", journal[:len(journal)-3], 0o644))
+
+	reopened := newPersistent(path, 0)
+	_, loaded := reopened.Load("a")
+	require.True(t, loaded)
+	_, loaded = reopened.Load("b")
+	require.False(t, loaded)
+	require.NoError(t, reopened.Store("c", persistentValue{Name: "c"}))
+
+	reopened = newPersistent(path, 0)
+	_, loaded = reopened.Load("a")
+	require.True(t, loaded)
+	_, loaded = reopened.Load("c")
+	require.True(t, loaded)
+}
+
+func TestPersistentCompact(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c := cache.NewPersistent[string, int64](context.Background(), cache.PersistentOptions{
+		Path:             path,
+		CompactThreshold: 10,
+	}, cache.WithSize[string, int64](5), cache.WithDisabledCleaner[string, int64]())
+	for i := 0; i < 100; i++ {
+		require.NoError(t, c.Store(string(rune('a'+i%26)), int64(i)))
+	}
+	journal, err := os.Stat(path + ".journal")
+	require.NoError(t, err)
+	require.Less(t, journal.Size(), int64(10*16))
+
+	reopened := cache.NewPersistent[string, int64](context.Background(), cache.PersistentOptions{Path: path}, cache.WithDisabledCleaner[string, int64]())
+	var keys []string
+	reopened.Range(func(key string, value int64) {
+		keys = append(keys, key)
+	})
+	require.Equal(t, []string{"r", "s", "t", "u", "v"}, keys)
+	value, loaded := reopened.Load("v")
+	require.True(t, loaded)
+	require.Equal(t, int64(99), value)
+}
+
+func TestPersistentLoadOrStoreWithoutAge(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c := cache.NewPersistent[string, int64](context.Background(), cache.PersistentOptions{Path: path}, cache.WithDisabledCleaner[string, int64]())
+	value, loaded, err := c.LoadOrStore("a", func() int64 {
+		return 1
+	})
+	require.NoError(t, err)
+	require.False(t, loaded)
+	require.Equal(t, int64(1), value)
+	_, expires, loaded := c.LoadWithExpire("a")
+	require.True(t, loaded)
+	require.Zero(t, expires.Unix())
+	time.Sleep(1100 * time.Millisecond)
+	require.NoError(t, c.Close())
+
+	reopened := cache.NewPersistent[string, int64](context.Background(), cache.PersistentOptions{Path: path}, cache.WithDisabledCleaner[string, int64]())
+	value, loaded = reopened.Load("a")
+	require.True(t, loaded)
+	require.Equal(t, int64(1), value)
+}
+
+func TestPersistentStartError(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "missing", "cache.db")
+	c := cache.NewPersistent[string, int64](context.Background(), cache.PersistentOptions{Path: path}, cache.WithDisabledCleaner[string, int64]())
+	_, loaded := c.Load("a")
+	require.False(t, loaded)
+	err := c.Start()
+	require.ErrorContains(t, err, "open cache journal")
+	require.Equal(t, err, c.Store("a", 1))
+	_, loaded = c.Load("a")
+	require.False(t, loaded)
+}
+
+func TestPersistentLoadOrStoreSize(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c := cache.NewPersistent[string, int64](context.Background(), cache.PersistentOptions{Path: path}, cache.WithSize[string, int64](2), cache.WithDisabledCleaner[string, int64]())
+	for i, key := range []string{"a", "b", "c"} {
+		_, loaded, err := c.LoadOrStore(key, func() int64 {
+			return int64(i)
+		})
+		require.NoError(t, err)
+		require.False(t, loaded)
+	}
+	require.NoError(t, c.Close())
+
+	reopened := cache.NewPersistent[string, int64](context.Background(), cache.PersistentOptions{Path: path}, cache.WithDisabledCleaner[string, int64]())
+	var keys []string
+	reopened.Range(func(key string, value int64) {
+		keys = append(keys, key)
+	})
+	require.Equal(t, []string{"b", "c"}, keys)
+}
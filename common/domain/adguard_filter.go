@@ -0,0 +1,441 @@
+package domain
+
+import (
+	std_bufio "bufio"
+	"io"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+	F "github.com/sagernet/sing/common/format"
+)
+
+// AdGuardRule is a parsed AdGuard DNS filtering rule.
+type AdGuardRule struct {
+	Line int
+	Text string
+	// Pattern is the host pattern in the syntax accepted by NewAdGuardMatcher, empty for regular expression rules.
+	Pattern   string
+	Regexp    *regexp.Regexp
+	Exception bool
+	Important bool
+	DenyAllow []string
+	Client    []AdGuardClient
+	DNSType   []AdGuardDNSType
+	// HasDNSRewrite is set for $dnsrewrite rules; DNSRewrite is the raw modifier value, which may be empty.
+	HasDNSRewrite bool
+	DNSRewrite    string
+}
+
+type AdGuardClient struct {
+	Negate bool
+	Prefix netip.Prefix
+	Name   string
+}
+
+type AdGuardDNSType struct {
+	Negate bool
+	Type   uint16
+}
+
+// SyntaxError reports a rule list line that could not be parsed.
+type SyntaxError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *SyntaxError) Error() string {
+	return F.ToString("line ", e.Line, ": ", e.Err.Error(), ": ", e.Text)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+var ErrUnsupportedAdGuardRule = E.New("unsupported rule")
+
+var adGuardDNSTypes = map[string]uint16{
+	"A":      1,
+	"NS":     2,
+	"CNAME":  5,
+	"SOA":    6,
+	"PTR":    12,
+	"HINFO":  13,
+	"MX":     15,
+	"TXT":    16,
+	"AAAA":   28,
+	"SRV":    33,
+	"NAPTR":  35,
+	"DS":     43,
+	"RRSIG":  46,
+	"DNSKEY": 48,
+	"SVCB":   64,
+	"HTTPS":  65,
+	"ANY":    255,
+	"CAA":    257,
+}
+
+// ParseAdGuardRules parses an AdGuard DNS filter list. Comments and blank lines are skipped;
+// every line that cannot be honoured is reported as a *SyntaxError instead of being ignored.
+func ParseAdGuardRules(reader io.Reader) ([]AdGuardRule, []error, error) {
+	var (
+		rules  []AdGuardRule
+		errors []error
+	)
+	scanner := std_bufio.NewScanner(reader)
+	scanner.Buffer(nil, 1024*1024)
+	var lineNumber int
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '!' || line[0] == '#' || line[0] == '[' {
+			continue
+		}
+		rule, err := ParseAdGuardRule(line)
+		if err != nil {
+			errors = append(errors, &SyntaxError{lineNumber, line, err})
+			continue
+		}
+		rule.Line = lineNumber
+		rules = append(rules, rule)
+	}
+	return rules, errors, scanner.Err()
+}
+
+func ParseAdGuardRule(line string) (AdGuardRule, error) {
+	rule := AdGuardRule{Text: line}
+	if strings.Contains(line, "##") || strings.Contains(line, "#@#") || strings.Contains(line, "#$#") || strings.Contains(line, "#%#") {
+		return rule, E.Cause(ErrUnsupportedAdGuardRule, "cosmetic rule")
+	}
+	if strings.HasPrefix(line, "@@") {
+		rule.Exception = true
+		line = line[2:]
+	}
+	var modifiers string
+	if strings.HasPrefix(line, "/") {
+		end := strings.LastIndexByte(line, '/')
+		if end == 0 {
+			return rule, E.New("unterminated regular expression")
+		}
+		if dollar := strings.LastIndexByte(line, '$'); dollar > end {
+			modifiers = line[dollar+1:]
+		} else if end != len(line)-1 {
+			return rule, E.New("unexpected content after regular expression")
+		}
+		pattern, err := regexp.Compile(line[1:end])
+		if err != nil {
+			return rule, err
+		}
+		rule.Regexp = pattern
+	} else {
+		pattern := line
+		if dollar := strings.IndexByte(line, '$'); dollar != -1 {
+			pattern = line[:dollar]
+			modifiers = line[dollar+1:]
+		}
+		pattern = strings.ToLower(pattern)
+		if err := validateAdGuardPattern(pattern); err != nil {
+			return rule, err
+		}
+		rule.Pattern = pattern
+	}
+	if modifiers != "" {
+		for _, modifier := range splitAdGuardModifiers(modifiers) {
+			err := rule.parseModifier(modifier)
+			if err != nil {
+				return rule, err
+			}
+		}
+	}
+	return rule, nil
+}
+
+func validateAdGuardPattern(pattern string) error {
+	host := strings.TrimPrefix(strings.TrimPrefix(pattern, "|"), "|")
+	host = strings.TrimSuffix(host, "^")
+	if host == "" || host == "*" {
+		return E.New("empty host pattern")
+	}
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '.', c == '-', c == '_', c == '*':
+		case c >= 0x80:
+		case c == '/' || c == ':' || c == '?':
+			return E.Cause(ErrUnsupportedAdGuardRule, "URL rule")
+		default:
+			return E.New("invalid character ", F.ToString(string(rune(c))), " in host pattern")
+		}
+	}
+	return nil
+}
+
+func splitAdGuardModifiers(modifiers string) []string {
+	var (
+		result  []string
+		current strings.Builder
+		escaped bool
+		quoted  bool
+	)
+	for _, c := range modifiers {
+		switch {
+		case escaped:
+			current.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '\'':
+			quoted = !quoted
+			current.WriteRune(c)
+		case c == ',' && !quoted:
+			result = append(result, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(c)
+		}
+	}
+	return append(result, current.String())
+}
+
+func (r *AdGuardRule) parseModifier(modifier string) error {
+	name, value, hasValue := strings.Cut(modifier, "=")
+	switch name {
+	case "important":
+		if hasValue {
+			return E.New("unexpected value for $important")
+		}
+		r.Important = true
+	case "denyallow":
+		if !hasValue {
+			return E.New("missing value for $denyallow")
+		}
+		for _, domain := range strings.Split(value, "|") {
+			if domain == "" || strings.HasPrefix(domain, "~") || strings.Contains(domain, "*") {
+				return E.New("invalid $denyallow domain: ", domain)
+			}
+			r.DenyAllow = append(r.DenyAllow, normalizeDomain(domain))
+		}
+	case "client":
+		if !hasValue {
+			return E.New("missing value for $client")
+		}
+		for _, client := range strings.Split(value, "|") {
+			var item AdGuardClient
+			if strings.HasPrefix(client, "~") {
+				item.Negate = true
+				client = client[1:]
+			}
+			client = strings.Trim(client, "'\"")
+			if client == "" {
+				return E.New("empty $client value")
+			}
+			if prefix, err := netip.ParsePrefix(client); err == nil {
+				item.Prefix = prefix.Masked()
+			} else if addr, err := netip.ParseAddr(client); err == nil {
+				item.Prefix = netip.PrefixFrom(addr, addr.BitLen())
+			} else {
+				item.Name = client
+			}
+			r.Client = append(r.Client, item)
+		}
+	case "dnstype":
+		if !hasValue {
+			return E.New("missing value for $dnstype")
+		}
+		for _, typeName := range strings.Split(value, "|") {
+			var item AdGuardDNSType
+			if strings.HasPrefix(typeName, "~") {
+				item.Negate = true
+				typeName = typeName[1:]
+			}
+			dnsType, loaded := adGuardDNSTypes[strings.ToUpper(typeName)]
+			if !loaded {
+				return E.New("unknown $dnstype: ", typeName)
+			}
+			item.Type = dnsType
+			r.DNSType = append(r.DNSType, item)
+		}
+	case "dnsrewrite":
+		r.HasDNSRewrite = true
+		r.DNSRewrite = value
+	default:
+		return E.Cause(ErrUnsupportedAdGuardRule, "modifier $", name)
+	}
+	return nil
+}
+
+type AdGuardRequest struct {
+	Domain     string
+	ClientAddr netip.Addr
+	ClientName string
+	// DNSType is the query type; 0 matches every $dnstype rule.
+	DNSType uint16
+}
+
+// applies reports whether the modifiers of the rule allow it to be applied to request.
+func (r *AdGuardRule) applies(request AdGuardRequest) bool {
+	for _, domain := range r.DenyAllow {
+		if request.Domain == domain || strings.HasSuffix(request.Domain, "."+domain) {
+			return false
+		}
+	}
+	if len(r.Client) > 0 {
+		var (
+			hasPositive bool
+			matched     bool
+		)
+		for _, client := range r.Client {
+			clientMatched := client.Name != "" && client.Name == request.ClientName ||
+				client.Prefix.IsValid() && request.ClientAddr.IsValid() && client.Prefix.Contains(request.ClientAddr.Unmap())
+			if client.Negate {
+				if clientMatched {
+					return false
+				}
+			} else {
+				hasPositive = true
+				matched = matched || clientMatched
+			}
+		}
+		if hasPositive && !matched {
+			return false
+		}
+	}
+	if len(r.DNSType) > 0 && request.DNSType != 0 {
+		var (
+			hasPositive bool
+			matched     bool
+		)
+		for _, dnsType := range r.DNSType {
+			if dnsType.Negate {
+				if dnsType.Type == request.DNSType {
+					return false
+				}
+			} else {
+				hasPositive = true
+				matched = matched || dnsType.Type == request.DNSType
+			}
+		}
+		if hasPositive && !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *AdGuardRule) priority() int {
+	var priority int
+	if r.Important {
+		priority += 2
+	}
+	if r.Exception {
+		priority++
+	}
+	return priority
+}
+
+type AdGuardResult struct {
+	// Rule is the rule that decided the result.
+	Rule *AdGuardRule
+	// DNSRewrites holds the applicable $dnsrewrite rules, in list order.
+	DNSRewrites []*AdGuardRule
+}
+
+// Blocked reports whether the request should be blocked by a basic rule.
+func (r AdGuardResult) Blocked() bool {
+	return r.Rule != nil && !r.Rule.Exception && !r.Rule.HasDNSRewrite
+}
+
+// AdGuardFilter matches requests against AdGuard DNS rules, honouring exceptions, $important and
+// request modifiers.
+type AdGuardFilter struct {
+	matcher     *AdGuardMatcher
+	leafRanks   []int32
+	leafRules   [][]int
+	regexpRules []int
+	rules       []AdGuardRule
+}
+
+func NewAdGuardFilter(rules []AdGuardRule) *AdGuardFilter {
+	filter := &AdGuardFilter{rules: rules}
+	keyRules := make(map[string][]int)
+	for index, rule := range rules {
+		if rule.Regexp != nil {
+			filter.regexpRules = append(filter.regexpRules, index)
+			continue
+		}
+		key := adGuardRuleKey(rule.Pattern)
+		keyRules[key] = append(keyRules[key], index)
+	}
+	keys := make([]string, 0, len(keyRules))
+	for key := range keyRules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var leafKeys []int
+	filter.matcher = &AdGuardMatcher{buildSuccinctSet(keys, &leafKeys)}
+	filter.leafRules = make([][]int, len(leafKeys))
+	for leaf, keyIndex := range leafKeys {
+		filter.leafRules[leaf] = keyRules[keys[keyIndex]]
+	}
+	filter.leafRanks = indexRank64(filter.matcher.set.leaves, true)
+	return filter
+}
+
+// Match returns the result for request, or false if no rule applies.
+func (f *AdGuardFilter) Match(request AdGuardRequest) (AdGuardResult, bool) {
+	request.Domain = normalizeDomain(strings.TrimSuffix(request.Domain, "."))
+	var matched []int
+	if len(f.leafRules) > 0 {
+		f.matcher.matchLeaves(request.Domain, func(nodeId int) {
+			leaf, _ := rank64(f.matcher.set.leaves, f.leafRanks, int32(nodeId))
+			matched = append(matched, f.leafRules[leaf]...)
+		})
+	}
+	for _, index := range f.regexpRules {
+		if f.rules[index].Regexp.MatchString(request.Domain) {
+			matched = append(matched, index)
+		}
+	}
+	matched = common.Uniq(matched)
+	sort.Ints(matched)
+	var (
+		result        AdGuardResult
+		rewrites      []*AdGuardRule
+		disableAll    bool
+		disabledValue = make(map[string]bool)
+	)
+	for _, index := range matched {
+		rule := &f.rules[index]
+		if !rule.applies(request) {
+			continue
+		}
+		if rule.HasDNSRewrite {
+			if rule.Exception {
+				if rule.DNSRewrite == "" {
+					disableAll = true
+				} else {
+					disabledValue[rule.DNSRewrite] = true
+				}
+			} else {
+				rewrites = append(rewrites, rule)
+			}
+			continue
+		}
+		if result.Rule == nil || rule.priority() > result.Rule.priority() {
+			result.Rule = rule
+		}
+	}
+	if !disableAll {
+		result.DNSRewrites = common.Filter(rewrites, func(it *AdGuardRule) bool {
+			return !disabledValue[it.DNSRewrite]
+		})
+	}
+	if len(result.DNSRewrites) > 0 && (result.Rule == nil || !result.Rule.Important) {
+		result.Rule = result.DNSRewrites[0]
+	}
+	return result, result.Rule != nil
+}
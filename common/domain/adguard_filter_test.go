@@ -0,0 +1,83 @@
+package domain_test
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/sagernet/sing/common/domain"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdGuardFilter(t *testing.T) {
+	t.Parallel()
+	rules, lineErrors, err := domain.ParseAdGuardRules(strings.NewReader(`! comment
+||example.org^
+@@||safe.example.org^
+||ads.example.org^$important
+@@||ads.example.org^
+||tracker.com^$denyallow=cdn.tracker.com
+||lan.test^$client=192.168.1.0/24|~192.168.1.1
+||v6only.test^$dnstype=AAAA
+||rewrite.test^$dnsrewrite=1.2.3.4
+/^ad[0-9]+\.net$/
+example.net/path
+||cosmetic.test##.banner
+||bad.test^$third-party
+`))
+	require.NoError(t, err)
+	require.Len(t, rules, 9)
+	require.Len(t, lineErrors, 3)
+	var syntaxError *domain.SyntaxError
+	require.True(t, errors.As(lineErrors[0], &syntaxError))
+	require.Equal(t, 11, syntaxError.Line)
+	require.ErrorIs(t, lineErrors[2], domain.ErrUnsupportedAdGuardRule)
+
+	filter := domain.NewAdGuardFilter(rules)
+	match := func(request domain.AdGuardRequest) (domain.AdGuardResult, bool) {
+		return filter.Match(request)
+	}
+	result, matched := match(domain.AdGuardRequest{Domain: "www.example.org"})
+	require.True(t, matched)
+	require.True(t, result.Blocked())
+	require.Equal(t, 2, result.Rule.Line)
+
+	result, matched = match(domain.AdGuardRequest{Domain: "safe.example.org"})
+	require.True(t, matched)
+	require.False(t, result.Blocked())
+	require.True(t, result.Rule.Exception)
+
+	result, _ = match(domain.AdGuardRequest{Domain: "ads.example.org"})
+	require.True(t, result.Blocked())
+	require.True(t, result.Rule.Important)
+
+	result, _ = match(domain.AdGuardRequest{Domain: "tracker.com"})
+	require.True(t, result.Blocked())
+	_, matched = match(domain.AdGuardRequest{Domain: "cdn.tracker.com"})
+	require.False(t, matched)
+
+	result, _ = match(domain.AdGuardRequest{Domain: "lan.test", ClientAddr: netip.MustParseAddr("192.168.1.2")})
+	require.True(t, result.Blocked())
+	_, matched = match(domain.AdGuardRequest{Domain: "lan.test", ClientAddr: netip.MustParseAddr("192.168.1.1")})
+	require.False(t, matched)
+	_, matched = match(domain.AdGuardRequest{Domain: "lan.test", ClientAddr: netip.MustParseAddr("10.0.0.1")})
+	require.False(t, matched)
+
+	_, matched = match(domain.AdGuardRequest{Domain: "v6only.test", DNSType: 1})
+	require.False(t, matched)
+	result, _ = match(domain.AdGuardRequest{Domain: "v6only.test", DNSType: 28})
+	require.True(t, result.Blocked())
+
+	result, matched = match(domain.AdGuardRequest{Domain: "rewrite.test"})
+	require.True(t, matched)
+	require.False(t, result.Blocked())
+	require.Len(t, result.DNSRewrites, 1)
+	require.Equal(t, "1.2.3.4", result.DNSRewrites[0].DNSRewrite)
+
+	result, _ = match(domain.AdGuardRequest{Domain: "ad42.net"})
+	require.True(t, result.Blocked())
+	_, matched = match(domain.AdGuardRequest{Domain: "ad.net"})
+	require.False(t, matched)
+}
@@ -65,3 +65,27 @@ func TestAdGuardMatcher(t *testing.T) {
 	sort.Strings(dLines)
 	require.Equal(t, ruleLines, dLines)
 }
+
+func TestParseAdGuardMatcher(t *testing.T) {
+	t.Parallel()
+	matcher, errors := domain.ParseAdGuardMatcher([]string{
+		"||example.org^",
+		"@@||www.example.org^",
+		"||example.com^$important",
+		"/example\\.net/",
+		"||example.edu/path",
+		"||example.gov^",
+	})
+	require.True(t, matcher.Match("www.example.org"))
+	require.True(t, matcher.Match("example.gov"))
+	require.False(t, matcher.Match("example.com"))
+	require.Len(t, errors, 4)
+	var lines []int
+	for _, err := range errors {
+		var syntaxError *domain.SyntaxError
+		require.ErrorAs(t, err, &syntaxError)
+		require.ErrorIs(t, err, domain.ErrUnsupportedAdGuardRule)
+		lines = append(lines, syntaxError.Line)
+	}
+	require.Equal(t, []int{2, 3, 4, 5}, lines)
+}
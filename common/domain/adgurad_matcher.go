@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
 	"github.com/sagernet/sing/common/varbin"
 )
 
@@ -18,41 +19,100 @@ type AdGuardMatcher struct {
 	set *succinctSet
 }
 
+// NewAdGuardMatcher builds a matcher from host patterns using "||", "|", "^" and "*".
+// Lines are not validated, use ParseAdGuardMatcher for untrusted rules.
 func NewAdGuardMatcher(ruleLines []string) *AdGuardMatcher {
 	ruleList := make([]string, 0, len(ruleLines))
 	for _, ruleLine := range ruleLines {
-		var (
-			isSuffix bool // ||
-			hasStart bool // |
-			hasEnd   bool // ^
-		)
-		if strings.HasPrefix(ruleLine, "||") {
-			ruleLine = ruleLine[2:]
-			isSuffix = true
-		} else if strings.HasPrefix(ruleLine, "|") {
-			ruleLine = ruleLine[1:]
-			hasStart = true
+		ruleList = append(ruleList, adGuardRuleKey(ruleLine))
+	}
+	ruleList = common.Uniq(ruleList)
+	sort.Strings(ruleList)
+	return &AdGuardMatcher{newSuccinctSet(ruleList)}
+}
+
+// ParseAdGuardMatcher is NewAdGuardMatcher that reports every line it cannot honour as a *SyntaxError
+// instead of matching it incorrectly. Exceptions, modifiers and regular expressions require NewAdGuardFilter.
+func ParseAdGuardMatcher(ruleLines []string) (*AdGuardMatcher, []error) {
+	var (
+		validLines []string
+		errors     []error
+	)
+	for i, ruleLine := range ruleLines {
+		rule, err := ParseAdGuardRule(ruleLine)
+		if err == nil {
+			if rule.Exception {
+				err = E.Cause(ErrUnsupportedAdGuardRule, "exception rule")
+			} else if rule.Regexp != nil {
+				err = E.Cause(ErrUnsupportedAdGuardRule, "regular expression rule")
+			} else if strings.Contains(ruleLine, "$") {
+				err = E.Cause(ErrUnsupportedAdGuardRule, "modifiers")
+			}
 		}
-		if strings.HasSuffix(ruleLine, "^") {
+		if err != nil {
+			errors = append(errors, &SyntaxError{i + 1, ruleLine, err})
+			continue
+		}
+		validLines = append(validLines, ruleLine)
+	}
+	return NewAdGuardMatcher(validLines), errors
+}
+
+func adGuardRuleKey(ruleLine string) string {
+	var (
+		isSuffix bool // ||
+		hasStart bool // |
+		hasEnd   bool // ^
+	)
+	if strings.HasPrefix(ruleLine, "||") {
+		ruleLine = ruleLine[2:]
+		isSuffix = true
+	} else if strings.HasPrefix(ruleLine, "|") {
+		ruleLine = ruleLine[1:]
+		hasStart = true
+	}
+	if strings.HasSuffix(ruleLine, "^") {
+		ruleLine = ruleLine[:len(ruleLine)-1]
+		hasEnd = true
+	}
+	ruleLine = normalizePatternLabels(ruleLine)
+	if isSuffix {
+		ruleLine = string(rootLabel) + ruleLine
+	} else if !hasStart {
+		ruleLine = string(prefixLabel) + ruleLine
+	}
+	if !hasEnd {
+		if strings.HasSuffix(ruleLine, ".") {
 			ruleLine = ruleLine[:len(ruleLine)-1]
-			hasEnd = true
 		}
-		if isSuffix {
-			ruleLine = string(rootLabel) + ruleLine
-		} else if !hasStart {
-			ruleLine = string(prefixLabel) + ruleLine
+		ruleLine += string(suffixLabel)
+	}
+	return reverseDomain(ruleLine)
+}
+
+// normalizePatternLabels applies IDNA processing to the non-ASCII labels of a host pattern without wildcards.
+func normalizePatternLabels(pattern string) string {
+	if isASCII(pattern) {
+		return strings.ToLower(pattern)
+	}
+	labels := strings.Split(pattern, ".")
+	for i, label := range labels {
+		if label != "" && !strings.Contains(label, "*") {
+			labels[i] = normalizeDomain(label)
+		} else {
+			labels[i] = strings.ToLower(label)
 		}
-		if !hasEnd {
-			if strings.HasSuffix(ruleLine, ".") {
-				ruleLine = ruleLine[:len(ruleLine)-1]
-			}
-			ruleLine += string(suffixLabel)
+	}
+	return strings.Join(labels, ".")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
 		}
-		ruleList = append(ruleList, reverseDomain(ruleLine))
 	}
-	ruleList = common.Uniq(ruleList)
-	sort.Strings(ruleList)
-	return &AdGuardMatcher{newSuccinctSet(ruleList)}
+	return true
 }
 
 func ReadAdGuardMatcher(reader varbin.Reader) (*AdGuardMatcher, error) {
@@ -68,7 +128,7 @@ func (m *AdGuardMatcher) Write(writer varbin.Writer) error {
 }
 
 func (m *AdGuardMatcher) Match(domain string) bool {
-	key := reverseDomain(domain)
+	key := reverseDomain(normalizeDomain(domain))
 	if m.has([]byte(key), 0, 0) {
 		return true
 	}
@@ -137,6 +197,73 @@ func (m *AdGuardMatcher) has(key []byte, nodeId, bmIdx int) bool {
 	}
 }
 
+// matchLeaves calls visit with the leaf node of every rule matching domain.
+func (m *AdGuardMatcher) matchLeaves(domain string, visit func(leaf int)) {
+	key := reverseDomain(domain)
+	m.collect([]byte(key), 0, 0, visit)
+	for {
+		m.collect([]byte(string(suffixLabel)+key), 0, 0, visit)
+		idx := strings.IndexByte(key, '.')
+		if idx == -1 {
+			return
+		}
+		key = key[idx+1:]
+	}
+}
+
+// collect is has without the early return.
+func (m *AdGuardMatcher) collect(key []byte, nodeId, bmIdx int, visit func(leaf int)) {
+	for i := 0; i < len(key); i++ {
+		currentChar := key[i]
+		for ; ; bmIdx++ {
+			if getBit(m.set.labelBitmap, bmIdx) != 0 {
+				return
+			}
+			nextLabel := m.set.labels[bmIdx-nodeId]
+			if nextLabel == prefixLabel {
+				visit(countZeros(m.set.labelBitmap, m.set.ranks, bmIdx+1))
+				continue
+			}
+			if nextLabel == rootLabel {
+				nextNodeId := countZeros(m.set.labelBitmap, m.set.ranks, bmIdx+1)
+				if currentChar == '.' && getBit(m.set.leaves, nextNodeId) != 0 {
+					visit(nextNodeId)
+				}
+				continue
+			}
+			if nextLabel == currentChar {
+				break
+			}
+			if nextLabel == anyLabel {
+				idx := bytes.IndexRune(key[i:], '.')
+				nextNodeId := countZeros(m.set.labelBitmap, m.set.ranks, bmIdx+1)
+				if idx == -1 {
+					if getBit(m.set.leaves, nextNodeId) != 0 {
+						visit(nextNodeId)
+					}
+					idx = 0
+				}
+				nextBmIdx := selectIthOne(m.set.labelBitmap, m.set.ranks, m.set.selects, nextNodeId-1) + 1
+				m.collect(key[i+idx:], nextNodeId, nextBmIdx, visit)
+			}
+		}
+		nodeId = countZeros(m.set.labelBitmap, m.set.ranks, bmIdx+1)
+		bmIdx = selectIthOne(m.set.labelBitmap, m.set.ranks, m.set.selects, nodeId-1) + 1
+	}
+	if getBit(m.set.leaves, nodeId) != 0 {
+		visit(nodeId)
+	}
+	for ; ; bmIdx++ {
+		if getBit(m.set.labelBitmap, bmIdx) != 0 {
+			return
+		}
+		nextLabel := m.set.labels[bmIdx-nodeId]
+		if nextLabel == prefixLabel || nextLabel == rootLabel {
+			visit(countZeros(m.set.labelBitmap, m.set.ranks, bmIdx+1))
+		}
+	}
+}
+
 func (m *AdGuardMatcher) Dump() (ruleLines []string) {
 	for _, key := range m.set.keys() {
 		key = reverseDomain(key)
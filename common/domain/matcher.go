@@ -2,8 +2,10 @@ package domain
 
 import (
 	"sort"
+	"strings"
 	"unicode/utf8"
 
+	"github.com/sagernet/sing/common/idna"
 	"github.com/sagernet/sing/common/varbin"
 )
 
@@ -20,6 +22,11 @@ func NewMatcher(domains []string, domainSuffix []string, generateLegacy bool) *M
 	domainList := make([]string, 0, len(domains)+2*len(domainSuffix))
 	seen := make(map[string]bool, len(domainList))
 	for _, domain := range domainSuffix {
+		if domain[0] == '.' {
+			domain = "." + normalizeDomain(domain[1:])
+		} else {
+			domain = normalizeDomain(domain)
+		}
 		if seen[domain] {
 			continue
 		}
@@ -38,6 +45,7 @@ func NewMatcher(domains []string, domainSuffix []string, generateLegacy bool) *M
 		}
 	}
 	for _, domain := range domains {
+		domain = normalizeDomain(domain)
 		if seen[domain] {
 			continue
 		}
@@ -61,7 +69,7 @@ func (m *Matcher) Write(writer varbin.Writer) error {
 }
 
 func (m *Matcher) Match(domain string) bool {
-	return m.has(reverseDomain(domain))
+	return m.has(reverseDomain(normalizeDomain(domain)))
 }
 
 func (m *Matcher) has(key string) bool {
@@ -105,9 +113,13 @@ func (m *Matcher) has(key string) bool {
 }
 
 func (m *Matcher) Dump() (domainList []string, prefixList []string) {
+	return dumpKeys(m.set.keys())
+}
+
+func dumpKeys(keys []string) (domainList []string, prefixList []string) {
 	domainMap := make(map[string]bool)
 	prefixMap := make(map[string]bool)
-	for _, key := range m.set.keys() {
+	for _, key := range keys {
 		key = reverseDomain(key)
 		if key[0] == prefixLabel {
 			prefixMap[key[1:]] = true
@@ -135,6 +147,15 @@ func (m *Matcher) Dump() (domainList []string, prefixList []string) {
 	return domainList, prefixList
 }
 
+// normalizeDomain applies IDNA processing to domain, falling back to lower case for names it rejects.
+func normalizeDomain(domain string) string {
+	normalized, err := idna.ToASCII(domain)
+	if err != nil {
+		return strings.ToLower(domain)
+	}
+	return normalized
+}
+
 func reverseDomain(domain string) string {
 	l := len(domain)
 	b := make([]byte, l)
@@ -0,0 +1,144 @@
+package domain
+
+import (
+	"encoding/binary"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/varbin"
+)
+
+const matcherDeltaVersion = 1
+
+// MatcherDelta is the difference between two versions of a Matcher.
+type MatcherDelta struct {
+	added   []string
+	removed []string
+}
+
+type matcherDeltaData struct {
+	Version uint8
+	Added   []string
+	Removed []string
+}
+
+// MergeMatchers returns a matcher matching every domain matched by any of matchers.
+func MergeMatchers(matchers ...*Matcher) *Matcher {
+	var keys []string
+	for _, matcher := range matchers {
+		keys = mergeKeys(keys, matcher.set.keys())
+	}
+	return &Matcher{newSuccinctSet(keys)}
+}
+
+// DiffMatchers returns the delta that turns from into to.
+func DiffMatchers(from *Matcher, to *Matcher) *MatcherDelta {
+	fromKeys := from.set.keys()
+	toKeys := to.set.keys()
+	return &MatcherDelta{
+		added:   differenceKeys(toKeys, fromKeys),
+		removed: differenceKeys(fromKeys, toKeys),
+	}
+}
+
+// Apply returns a new matcher with delta applied. It fails if the matcher is not the version the
+// delta was computed from, i.e. a removed rule is missing or an added rule is already present.
+func (m *Matcher) Apply(delta *MatcherDelta) (*Matcher, error) {
+	keys := m.set.keys()
+	remaining := differenceKeys(keys, delta.removed)
+	if len(remaining) != len(keys)-len(delta.removed) {
+		return nil, E.New("delta does not apply: missing removed rules")
+	}
+	newKeys := mergeKeys(remaining, delta.added)
+	if len(newKeys) != len(remaining)+len(delta.added) {
+		return nil, E.New("delta does not apply: added rules already exist")
+	}
+	return &Matcher{newSuccinctSet(newKeys)}, nil
+}
+
+func ReadMatcherDelta(reader varbin.Reader) (*MatcherDelta, error) {
+	data, err := varbin.ReadValue[matcherDeltaData](reader, binary.BigEndian)
+	if err != nil {
+		return nil, err
+	}
+	if data.Version != matcherDeltaVersion {
+		return nil, E.New("unsupported matcher delta version: ", data.Version)
+	}
+	if !sortedKeys(data.Added) || !sortedKeys(data.Removed) {
+		return nil, E.New("bad matcher delta: rules not sorted")
+	}
+	return &MatcherDelta{
+		added:   data.Added,
+		removed: data.Removed,
+	}, nil
+}
+
+func (d *MatcherDelta) Write(writer varbin.Writer) error {
+	return varbin.Write(writer, binary.BigEndian, matcherDeltaData{
+		Version: matcherDeltaVersion,
+		Added:   d.added,
+		Removed: d.removed,
+	})
+}
+
+func (d *MatcherDelta) IsEmpty() bool {
+	return len(d.added) == 0 && len(d.removed) == 0
+}
+
+// Added returns the added rules in the form of Matcher.Dump.
+func (d *MatcherDelta) Added() (domainList []string, prefixList []string) {
+	return dumpKeys(d.added)
+}
+
+// Removed returns the removed rules in the form of Matcher.Dump.
+func (d *MatcherDelta) Removed() (domainList []string, prefixList []string) {
+	return dumpKeys(d.removed)
+}
+
+// mergeKeys returns the sorted union of the sorted key lists a and b.
+func mergeKeys(a []string, b []string) []string {
+	result := make([]string, 0, len(a)+len(b))
+	var i, j int
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		case a[i] > b[j]:
+			result = append(result, b[j])
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	return append(result, b[j:]...)
+}
+
+// differenceKeys returns the keys of the sorted list a that are not in the sorted list b.
+func differenceKeys(a []string, b []string) []string {
+	var (
+		result []string
+		j      int
+	)
+	for _, key := range a {
+		for j < len(b) && b[j] < key {
+			j++
+		}
+		if j < len(b) && b[j] == key {
+			continue
+		}
+		result = append(result, key)
+	}
+	return result
+}
+
+func sortedKeys(keys []string) bool {
+	for i := 1; i < len(keys); i++ {
+		if keys[i-1] >= keys[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package domain_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sagernet/sing/common/domain"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatcherDelta(t *testing.T) {
+	t.Parallel()
+	oldMatcher := domain.NewMatcher([]string{"example.com", "example.org"}, []string{"sagernet.org", ".com.cn"}, false)
+	newMatcher := domain.NewMatcher([]string{"example.com", "example.net"}, []string{"sagernet.org", ".org.cn"}, false)
+	delta := domain.DiffMatchers(oldMatcher, newMatcher)
+	addedDomain, addedSuffix := delta.Added()
+	require.Equal(t, []string{"example.net"}, addedDomain)
+	require.Equal(t, []string{".org.cn"}, addedSuffix)
+	removedDomain, removedSuffix := delta.Removed()
+	require.Equal(t, []string{"example.org"}, removedDomain)
+	require.Equal(t, []string{".com.cn"}, removedSuffix)
+
+	var buffer bytes.Buffer
+	require.NoError(t, delta.Write(&buffer))
+	delta, err := domain.ReadMatcherDelta(&buffer)
+	require.NoError(t, err)
+	buffer.Reset()
+	require.NoError(t, oldMatcher.Write(&buffer))
+	readMatcher, err := domain.ReadMatcher(&buffer)
+	require.NoError(t, err)
+	appliedMatcher, err := readMatcher.Apply(delta)
+	require.NoError(t, err)
+	require.True(t, domain.DiffMatchers(appliedMatcher, newMatcher).IsEmpty())
+	require.True(t, appliedMatcher.Match("a.b.org.cn"))
+	require.False(t, appliedMatcher.Match("example.org"))
+
+	_, err = appliedMatcher.Apply(delta)
+	require.Error(t, err)
+
+	emptyMatcher, err := appliedMatcher.Apply(domain.DiffMatchers(appliedMatcher, domain.NewMatcher(nil, nil, false)))
+	require.NoError(t, err)
+	require.False(t, emptyMatcher.Match("example.com"))
+
+	merged := domain.MergeMatchers(oldMatcher, newMatcher)
+	domainList, suffixList := merged.Dump()
+	require.Equal(t, []string{"example.com", "example.net", "example.org"}, domainList)
+	require.Equal(t, []string{".com.cn", ".org.cn", "sagernet.org"}, suffixList)
+}
@@ -51,6 +51,20 @@ func TestMatcherLegacy(t *testing.T) {
 	require.Equal(t, testDomainSuffix, dDomainSuffix)
 }
 
+func TestMatcherIDNA(t *testing.T) {
+	t.Parallel()
+	matcher := domain.NewMatcher([]string{"Bücher.example"}, []string{"例え.テスト"}, false)
+	require.True(t, matcher.Match("xn--bcher-kva.example"))
+	require.True(t, matcher.Match("BÜCHER.example"))
+	require.True(t, matcher.Match("www.xn--r8jz45g.xn--zckzah"))
+	require.True(t, matcher.Match("www.例え.テスト"))
+	dDomain, dDomainSuffix := matcher.Dump()
+	require.Equal(t, []string{"xn--bcher-kva.example"}, dDomain)
+	require.Equal(t, []string{"xn--r8jz45g.xn--zckzah"}, dDomainSuffix)
+	adGuardMatcher := domain.NewAdGuardMatcher([]string{"||Bücher.example^"})
+	require.True(t, adGuardMatcher.Match("www.xn--bcher-kva.example"))
+}
+
 type simpleRuleSet struct {
 	Rules []struct {
 		Domain       []string `json:"domain"`
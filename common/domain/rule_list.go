@@ -0,0 +1,310 @@
+package domain
+
+import (
+	std_bufio "bufio"
+	"io"
+	"net/netip"
+	"strings"
+
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/idna"
+)
+
+// RuleList holds rules in the form accepted by NewMatcher and returned by Matcher.Dump:
+// a suffix "example.com" matches the domain and its subdomains, ".example.com" only its subdomains.
+type RuleList struct {
+	Domain       []string
+	DomainSuffix []string
+}
+
+func (l *RuleList) Matcher() *Matcher {
+	return NewMatcher(l.Domain, l.DomainSuffix, false)
+}
+
+// AdGuardRules converts the list to rules for NewAdGuardMatcher.
+func (l *RuleList) AdGuardRules() []string {
+	ruleLines := make([]string, 0, len(l.Domain)+len(l.DomainSuffix))
+	for _, domain := range l.Domain {
+		ruleLines = append(ruleLines, "|"+domain+"^")
+	}
+	for _, suffix := range l.DomainSuffix {
+		if suffix[0] == '.' {
+			ruleLines = append(ruleLines, "*"+suffix+"^")
+		} else {
+			ruleLines = append(ruleLines, "||"+suffix+"^")
+		}
+	}
+	return ruleLines
+}
+
+func (l *RuleList) addDomain(domain string) {
+	l.Domain = append(l.Domain, domain)
+}
+
+func (l *RuleList) addSuffix(suffix string) {
+	l.DomainSuffix = append(l.DomainSuffix, suffix)
+}
+
+var hostsIgnoredNames = []string{
+	"localhost",
+	"localhost.localdomain",
+	"local",
+	"broadcasthost",
+	"ip6-localhost",
+	"ip6-loopback",
+	"ip6-localnet",
+	"ip6-mcastprefix",
+	"ip6-allnodes",
+	"ip6-allrouters",
+	"ip6-allhosts",
+}
+
+// ParseHosts parses a hosts file. Every host name becomes an exact domain rule; the local names
+// found in a default /etc/hosts are skipped.
+func ParseHosts(reader io.Reader) (*RuleList, []error, error) {
+	var ruleList RuleList
+	lineErrors, err := scanRuleLines(reader, cutSpacedComment, func(line string) error {
+		fields := strings.Fields(line)
+		if _, err := netip.ParseAddr(fields[0]); err != nil {
+			return err
+		}
+		if len(fields) < 2 {
+			return E.New("missing host name")
+		}
+		for _, name := range fields[1:] {
+			if common.Contains(hostsIgnoredNames, strings.ToLower(name)) {
+				continue
+			}
+			domain, err := normalizeListDomain(name)
+			if err != nil {
+				return err
+			}
+			ruleList.addDomain(domain)
+		}
+		return nil
+	})
+	return &ruleList, lineErrors, err
+}
+
+var dnsmasqDirectives = []string{"server", "address", "local", "ipset", "nftset"}
+
+// ParseDnsmasq parses dnsmasq configuration lines with a domain list, such as "server=/example.com/1.1.1.1"
+// or "address=/example.com/". dnsmasq domains match their subdomains too; "*.example.com" matches subdomains only.
+// The "#" wildcard matching all domains cannot be expressed as a rule list and is reported as an error.
+func ParseDnsmasq(reader io.Reader) (*RuleList, []error, error) {
+	var ruleList RuleList
+	lineErrors, err := scanRuleLines(reader, cutSpacedComment, func(line string) error {
+		directive, value, found := strings.Cut(line, "=")
+		directive = strings.TrimPrefix(directive, "--")
+		if !found || !common.Contains(dnsmasqDirectives, directive) {
+			return E.New("unsupported directive: ", directive)
+		}
+		if !strings.HasPrefix(value, "/") {
+			return E.New("missing domain list")
+		}
+		end := strings.LastIndexByte(value, '/')
+		if end == 0 {
+			return E.New("unterminated domain list")
+		}
+		for _, name := range strings.Split(value[1:end], "/") {
+			if name == "" {
+				continue
+			}
+			if name == "#" {
+				return E.New("wildcard domain is not supported")
+			}
+			subdomainOnly := strings.HasPrefix(name, "*.")
+			if subdomainOnly {
+				name = name[2:]
+			}
+			domain, err := normalizeListDomain(name)
+			if err != nil {
+				return err
+			}
+			if subdomainOnly {
+				ruleList.addSuffix("." + domain)
+			} else {
+				ruleList.addSuffix(domain)
+			}
+		}
+		return nil
+	})
+	return &ruleList, lineErrors, err
+}
+
+// ParseClash parses Clash DOMAIN and DOMAIN-SUFFIX rules, either one per line or as a YAML payload list.
+// The policy field of full rules is ignored.
+func ParseClash(reader io.Reader) (*RuleList, []error, error) {
+	var ruleList RuleList
+	lineErrors, err := scanRuleLines(reader, cutComment, func(line string) error {
+		if line == "payload:" {
+			return nil
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "-"))
+		line = strings.Trim(line, "'\"")
+		fields := strings.Split(line, ",")
+		if len(fields) < 2 {
+			return E.New("missing value")
+		}
+		domain, err := normalizeListDomain(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return err
+		}
+		switch ruleType := strings.TrimSpace(fields[0]); ruleType {
+		case "DOMAIN":
+			ruleList.addDomain(domain)
+		case "DOMAIN-SUFFIX":
+			ruleList.addSuffix(domain)
+		default:
+			return E.New("unsupported rule type: ", ruleType)
+		}
+		return nil
+	})
+	return &ruleList, lineErrors, err
+}
+
+// ParsePlain parses a list with one domain per line: "example.com" matches the domain only,
+// "+.example.com" the domain and its subdomains, and ".example.com" or "*.example.com" its subdomains only.
+func ParsePlain(reader io.Reader) (*RuleList, []error, error) {
+	var ruleList RuleList
+	lineErrors, err := scanRuleLines(reader, cutComment, func(line string) error {
+		var (
+			name   = line
+			suffix bool
+			prefix string
+		)
+		switch {
+		case strings.HasPrefix(name, "+."):
+			name = name[2:]
+			suffix = true
+		case strings.HasPrefix(name, "*."):
+			name = name[2:]
+			suffix = true
+			prefix = "."
+		case strings.HasPrefix(name, "."):
+			name = name[1:]
+			suffix = true
+			prefix = "."
+		}
+		domain, err := normalizeListDomain(name)
+		if err != nil {
+			return err
+		}
+		if suffix {
+			ruleList.addSuffix(prefix + domain)
+		} else {
+			ruleList.addDomain(domain)
+		}
+		return nil
+	})
+	return &ruleList, lineErrors, err
+}
+
+// WriteHosts writes domainList as hosts entries pointing to address. Hosts files cannot express suffix rules.
+func WriteHosts(writer io.Writer, address netip.Addr, domainList []string, suffixList []string) error {
+	if len(suffixList) > 0 {
+		return E.New("hosts format does not support domain suffix rules")
+	}
+	return writeRuleLines(writer, domainList, func(domain string) string {
+		return address.String() + " " + domain
+	})
+}
+
+// WriteDnsmasq writes suffix rules as dnsmasq directives, e.g. "server" with value "1.1.1.1".
+// dnsmasq cannot express exact domain rules.
+func WriteDnsmasq(writer io.Writer, directive string, value string, domainList []string, suffixList []string) error {
+	if len(domainList) > 0 {
+		return E.New("dnsmasq format does not support exact domain rules")
+	}
+	return writeRuleLines(writer, suffixList, func(suffix string) string {
+		if suffix[0] == '.' {
+			suffix = "*" + suffix
+		}
+		return directive + "=/" + suffix + "/" + value
+	})
+}
+
+// WriteClash writes DOMAIN and DOMAIN-SUFFIX rules. Clash cannot express subdomain-only rules.
+func WriteClash(writer io.Writer, domainList []string, suffixList []string) error {
+	for _, suffix := range suffixList {
+		if suffix[0] == '.' {
+			return E.New("clash format does not support subdomain-only rule: ", suffix)
+		}
+	}
+	err := writeRuleLines(writer, domainList, func(domain string) string {
+		return "DOMAIN," + domain
+	})
+	if err != nil {
+		return err
+	}
+	return writeRuleLines(writer, suffixList, func(suffix string) string {
+		return "DOMAIN-SUFFIX," + suffix
+	})
+}
+
+func WritePlain(writer io.Writer, domainList []string, suffixList []string) error {
+	err := writeRuleLines(writer, domainList, func(domain string) string {
+		return domain
+	})
+	if err != nil {
+		return err
+	}
+	return writeRuleLines(writer, suffixList, func(suffix string) string {
+		if suffix[0] == '.' {
+			return suffix
+		}
+		return "+." + suffix
+	})
+}
+
+func scanRuleLines(reader io.Reader, stripComment func(line string) string, parseLine func(line string) error) ([]error, error) {
+	var lineErrors []error
+	scanner := std_bufio.NewScanner(reader)
+	scanner.Buffer(nil, 1024*1024)
+	var lineNumber int
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		err := parseLine(line)
+		if err != nil {
+			lineErrors = append(lineErrors, &SyntaxError{lineNumber, line, err})
+		}
+	}
+	return lineErrors, scanner.Err()
+}
+
+func cutComment(line string) string {
+	if index := strings.IndexByte(line, '#'); index != -1 {
+		return line[:index]
+	}
+	return line
+}
+
+// cutSpacedComment only removes comments at the start of the line or after whitespace,
+// as "#" is a value in dnsmasq directives such as "address=/#/0.0.0.0".
+func cutSpacedComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		if line[i] == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func writeRuleLines(writer io.Writer, rules []string, format func(rule string) string) error {
+	for _, rule := range rules {
+		_, err := io.WriteString(writer, format(rule)+"\n")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func normalizeListDomain(name string) (string, error) {
+	return idna.ToASCII(strings.TrimSuffix(name, "."))
+}
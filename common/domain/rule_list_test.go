@@ -0,0 +1,114 @@
+package domain_test
+
+import (
+	"bytes"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/sagernet/sing/common/domain"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleListRoundTrip(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range []struct {
+		name   string
+		input  string
+		parse  func(io.Reader) (*domain.RuleList, []error, error)
+		write  func(io.Writer, []string, []string) error
+		errors int
+	}{
+		{
+			name: "hosts",
+			input: `127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.net # blocked
+::1 ip6-localhost
+bad entry.example.com
+`,
+			parse: domain.ParseHosts,
+			write: func(writer io.Writer, domainList []string, suffixList []string) error {
+				return domain.WriteHosts(writer, netip.IPv4Unspecified(), domainList, suffixList)
+			},
+			errors: 1,
+		},
+		{
+			name: "dnsmasq",
+			input: `# china list
+server=/example.cn/example.com.cn/114.114.114.114
+address=/*.ads.example.org/
+conf-dir=/etc/dnsmasq.d
+`,
+			parse: domain.ParseDnsmasq,
+			write: func(writer io.Writer, domainList []string, suffixList []string) error {
+				return domain.WriteDnsmasq(writer, "server", "114.114.114.114", domainList, suffixList)
+			},
+			errors: 1,
+		},
+		{
+			name: "clash",
+			input: `payload:
+  - DOMAIN-SUFFIX,example.com
+  - 'DOMAIN,www.example.org'
+DOMAIN-SUFFIX,example.net,Proxy
+DOMAIN-KEYWORD,example
+`,
+			parse:  domain.ParseClash,
+			write:  domain.WriteClash,
+			errors: 1,
+		},
+		{
+			name: "plain",
+			input: `example.com
++.example.org
+.example.net
+*.example.edu
+exa mple.gov
+`,
+			parse:  domain.ParsePlain,
+			write:  domain.WritePlain,
+			errors: 1,
+		},
+	} {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			ruleList, lineErrors, err := testCase.parse(strings.NewReader(testCase.input))
+			require.NoError(t, err)
+			require.Len(t, lineErrors, testCase.errors)
+			domainList, suffixList := ruleList.Matcher().Dump()
+			var buffer bytes.Buffer
+			require.NoError(t, testCase.write(&buffer, domainList, suffixList))
+			ruleList, lineErrors, err = testCase.parse(&buffer)
+			require.NoError(t, err)
+			require.Empty(t, lineErrors)
+			newDomainList, newSuffixList := ruleList.Matcher().Dump()
+			require.Equal(t, domainList, newDomainList)
+			require.Equal(t, suffixList, newSuffixList)
+			adGuardMatcher := domain.NewAdGuardMatcher(ruleList.AdGuardRules())
+			matcher := ruleList.Matcher()
+			for _, name := range append(append(domainList, suffixList...), "sub.example.com", "sub.example.net", "other.test") {
+				name = strings.TrimPrefix(name, ".")
+				require.Equal(t, matcher.Match(name), adGuardMatcher.Match(name), name)
+				require.Equal(t, matcher.Match("a."+name), adGuardMatcher.Match("a."+name), "a."+name)
+			}
+		})
+	}
+}
+
+func TestParseDnsmasqHashValue(t *testing.T) {
+	t.Parallel()
+	ruleList, lineErrors, err := domain.ParseDnsmasq(strings.NewReader(`address=/#/0.0.0.0
+server=/example.com/# # use the default upstream
+#server=/example.org/1.1.1.1
+`))
+	require.NoError(t, err)
+	require.Len(t, lineErrors, 1)
+	var syntaxError *domain.SyntaxError
+	require.ErrorAs(t, lineErrors[0], &syntaxError)
+	require.Equal(t, 1, syntaxError.Line)
+	require.ErrorContains(t, syntaxError, "wildcard domain is not supported")
+	require.Equal(t, []string{"example.com"}, ruleList.DomainSuffix)
+}
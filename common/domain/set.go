@@ -16,14 +16,23 @@ type succinctSet struct {
 }
 
 func newSuccinctSet(keys []string) *succinctSet {
+	return buildSuccinctSet(keys, nil)
+}
+
+// buildSuccinctSet builds the set from sorted keys. If leafKeys is not nil, the index of the key
+// ending at each leaf is appended to it in leaf order.
+func buildSuccinctSet(keys []string, leafKeys *[]int) *succinctSet {
 	ss := &succinctSet{}
 	lIdx := 0
 	type qElt struct{ s, e, col int }
 	queue := []qElt{{0, len(keys), 0}}
 	for i := 0; i < len(queue); i++ {
 		elt := queue[i]
-		if elt.col == len(keys[elt.s]) {
+		if elt.s < elt.e && elt.col == len(keys[elt.s]) {
 			// a leaf node
+			if leafKeys != nil {
+				*leafKeys = append(*leafKeys, elt.s)
+			}
 			elt.s++
 			setBit(&ss.leaves, i, 1)
 		}
@@ -39,6 +48,10 @@ func newSuccinctSet(keys []string) *succinctSet {
 		setBit(&ss.labelBitmap, lIdx, 1)
 		lIdx++
 	}
+	if len(ss.leaves) == 0 {
+		// an empty set
+		ss.leaves = []uint64{0}
+	}
 	ss.init()
 	return ss
 }
@@ -0,0 +1,248 @@
+package domain
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/varbin"
+)
+
+const taggedMatcherVersion = 1
+
+// TaggedRule is a domain rule with a payload. Suffix rules follow the domainSuffix
+// semantics of NewMatcher: "example.com" matches the domain and its subdomains,
+// ".example.com" matches subdomains only.
+type TaggedRule struct {
+	Domain string
+	Suffix bool
+	Tag    string
+}
+
+type TaggedMatch struct {
+	TaggedRule
+	// Index is the position of the rule in the list passed to NewTaggedMatcher.
+	Index int
+}
+
+// TaggedMatcher is a Matcher that reports which rule matched.
+type TaggedMatcher struct {
+	set       *succinctSet
+	leafRanks []int32
+	indexes   []uint32
+	tagIndex  []uint32
+	tags      []string
+}
+
+type taggedMatcherData struct {
+	Version     uint8
+	Leaves      []uint64
+	LabelBitmap []uint64
+	Labels      []byte
+	Indexes     []uint32
+	TagIndex    []uint32
+	Tags        []string
+}
+
+// NewTaggedMatcher builds a matcher from rules. If a rule appears more than once, the first one wins.
+func NewTaggedMatcher(rules []TaggedRule) *TaggedMatcher {
+	type keyedRule struct {
+		key   string
+		index int
+	}
+	keyedRules := make([]keyedRule, 0, len(rules))
+	seen := make(map[string]bool, len(rules))
+	for index, rule := range rules {
+		key := taggedRuleKey(rule)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		keyedRules = append(keyedRules, keyedRule{key, index})
+	}
+	sort.Slice(keyedRules, func(i, j int) bool {
+		return keyedRules[i].key < keyedRules[j].key
+	})
+	keys := make([]string, len(keyedRules))
+	for i, rule := range keyedRules {
+		keys[i] = rule.key
+	}
+	var leafKeys []int
+	set := buildSuccinctSet(keys, &leafKeys)
+	matcher := &TaggedMatcher{
+		set:      set,
+		indexes:  make([]uint32, len(leafKeys)),
+		tagIndex: make([]uint32, len(leafKeys)),
+	}
+	tagMap := make(map[string]uint32)
+	for leaf, keyIndex := range leafKeys {
+		index := keyedRules[keyIndex].index
+		tag := rules[index].Tag
+		tagId, loaded := tagMap[tag]
+		if !loaded {
+			tagId = uint32(len(matcher.tags))
+			tagMap[tag] = tagId
+			matcher.tags = append(matcher.tags, tag)
+		}
+		matcher.indexes[leaf] = uint32(index)
+		matcher.tagIndex[leaf] = tagId
+	}
+	matcher.leafRanks = indexRank64(set.leaves, true)
+	return matcher
+}
+
+func ReadTaggedMatcher(reader varbin.Reader) (*TaggedMatcher, error) {
+	data, err := varbin.ReadValue[taggedMatcherData](reader, binary.BigEndian)
+	if err != nil {
+		return nil, err
+	}
+	if data.Version != taggedMatcherVersion {
+		return nil, E.New("unsupported tagged matcher version: ", data.Version)
+	}
+	if len(data.Indexes) != len(data.TagIndex) {
+		return nil, E.New("bad tagged matcher payload")
+	}
+	for _, tagId := range data.TagIndex {
+		if int(tagId) >= len(data.Tags) {
+			return nil, E.New("bad tagged matcher tag index: ", tagId)
+		}
+	}
+	set := &succinctSet{
+		leaves:      data.Leaves,
+		labelBitmap: data.LabelBitmap,
+		labels:      data.Labels,
+	}
+	set.init()
+	return &TaggedMatcher{
+		set:       set,
+		leafRanks: indexRank64(set.leaves, true),
+		indexes:   data.Indexes,
+		tagIndex:  data.TagIndex,
+		tags:      data.Tags,
+	}, nil
+}
+
+func (m *TaggedMatcher) Write(writer varbin.Writer) error {
+	return varbin.Write(writer, binary.BigEndian, taggedMatcherData{
+		Version:     taggedMatcherVersion,
+		Leaves:      m.set.leaves,
+		LabelBitmap: m.set.labelBitmap,
+		Labels:      m.set.labels,
+		Indexes:     m.indexes,
+		TagIndex:    m.tagIndex,
+		Tags:        m.tags,
+	})
+}
+
+func (m *TaggedMatcher) Match(domain string) bool {
+	_, matched := m.Lookup(domain)
+	return matched
+}
+
+// Lookup returns the most specific rule matching domain: an exact domain rule first, then the longest suffix.
+func (m *TaggedMatcher) Lookup(domain string) (TaggedMatch, bool) {
+	key := reverseDomain(normalizeDomain(domain))
+	var (
+		nodeId, bmIdx int
+		match         TaggedMatch
+		matched       bool
+	)
+	found := func(leaf int, matchedKey string, suffix bool) {
+		match = m.leafMatch(leaf, reverseDomain(matchedKey), suffix)
+		matched = true
+	}
+	for i := 0; i < len(key); i++ {
+		currentChar := key[i]
+		for ; ; bmIdx++ {
+			if getBit(m.set.labelBitmap, bmIdx) != 0 {
+				return match, matched
+			}
+			nextLabel := m.set.labels[bmIdx-nodeId]
+			if nextLabel == prefixLabel {
+				found(countZeros(m.set.labelBitmap, m.set.ranks, bmIdx+1), key[:i], true)
+				continue
+			}
+			if nextLabel == rootLabel {
+				nextNodeId := countZeros(m.set.labelBitmap, m.set.ranks, bmIdx+1)
+				if currentChar == '.' && getBit(m.set.leaves, nextNodeId) != 0 {
+					found(nextNodeId, key[:i], true)
+				}
+				continue
+			}
+			if nextLabel == currentChar {
+				break
+			}
+		}
+		nodeId = countZeros(m.set.labelBitmap, m.set.ranks, bmIdx+1)
+		bmIdx = selectIthOne(m.set.labelBitmap, m.set.ranks, m.set.selects, nodeId-1) + 1
+	}
+	if getBit(m.set.leaves, nodeId) != 0 {
+		found(nodeId, key, false)
+		return match, matched
+	}
+	for ; ; bmIdx++ {
+		if getBit(m.set.labelBitmap, bmIdx) != 0 {
+			return match, matched
+		}
+		nextLabel := m.set.labels[bmIdx-nodeId]
+		if nextLabel == prefixLabel || nextLabel == rootLabel {
+			found(countZeros(m.set.labelBitmap, m.set.ranks, bmIdx+1), key, true)
+			return match, matched
+		}
+	}
+}
+
+func (m *TaggedMatcher) leafMatch(nodeId int, domain string, suffix bool) TaggedMatch {
+	leaf, _ := rank64(m.set.leaves, m.leafRanks, int32(nodeId))
+	return TaggedMatch{
+		TaggedRule: TaggedRule{
+			Domain: domain,
+			Suffix: suffix,
+			Tag:    m.tags[m.tagIndex[leaf]],
+		},
+		Index: int(m.indexes[leaf]),
+	}
+}
+
+// Dump returns the rules of the matcher, ordered by their original index.
+func (m *TaggedMatcher) Dump() []TaggedRule {
+	keys := m.set.keys()
+	sort.Strings(keys)
+	var leafKeys []int
+	buildSuccinctSet(keys, &leafKeys)
+	rules := make([]TaggedMatch, len(leafKeys))
+	for leaf, keyIndex := range leafKeys {
+		key := reverseDomain(keys[keyIndex])
+		rule := TaggedRule{Tag: m.tags[m.tagIndex[leaf]]}
+		switch key[0] {
+		case prefixLabel:
+			rule.Domain = key[1:]
+			rule.Suffix = true
+		case rootLabel:
+			rule.Domain = key[1:]
+			rule.Suffix = true
+		default:
+			rule.Domain = key
+		}
+		rules[leaf] = TaggedMatch{rule, int(m.indexes[leaf])}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Index < rules[j].Index
+	})
+	ruleList := make([]TaggedRule, len(rules))
+	for i, rule := range rules {
+		ruleList[i] = rule.TaggedRule
+	}
+	return ruleList
+}
+
+func taggedRuleKey(rule TaggedRule) string {
+	if !rule.Suffix {
+		return reverseDomain(normalizeDomain(rule.Domain))
+	}
+	if strings.HasPrefix(rule.Domain, ".") {
+		return reverseDomain(string(prefixLabel) + "." + normalizeDomain(rule.Domain[1:]))
+	}
+	return reverseDomain(string(rootLabel) + normalizeDomain(rule.Domain))
+}
@@ -0,0 +1,59 @@
+package domain_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sagernet/sing/common/domain"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaggedMatcher(t *testing.T) {
+	t.Parallel()
+	rules := []domain.TaggedRule{
+		{Domain: "example.com", Tag: "exact"},
+		{Domain: "sagernet.org", Suffix: true, Tag: "sagernet"},
+		{Domain: "cdn.sagernet.org", Suffix: true, Tag: "cdn"},
+		{Domain: ".com.cn", Suffix: true, Tag: "cn"},
+		{Domain: "example.com", Tag: "duplicate"},
+	}
+	matcher := domain.NewTaggedMatcher(rules)
+	testTaggedMatcher(t, matcher)
+	require.Equal(t, rules[:4], matcher.Dump())
+
+	var buffer bytes.Buffer
+	require.NoError(t, matcher.Write(&buffer))
+	matcher, err := domain.ReadTaggedMatcher(&buffer)
+	require.NoError(t, err)
+	testTaggedMatcher(t, matcher)
+	require.Equal(t, rules[:4], matcher.Dump())
+}
+
+func testTaggedMatcher(t *testing.T, matcher *domain.TaggedMatcher) {
+	for _, testCase := range []struct {
+		domain string
+		tag    string
+		index  int
+		rule   string
+	}{
+		{"example.com", "exact", 0, "example.com"},
+		{"sagernet.org", "sagernet", 1, "sagernet.org"},
+		{"www.sagernet.org", "sagernet", 1, "sagernet.org"},
+		{"cdn.sagernet.org", "cdn", 2, "cdn.sagernet.org"},
+		{"a.cdn.sagernet.org", "cdn", 2, "cdn.sagernet.org"},
+		{"acdn.sagernet.org", "sagernet", 1, "sagernet.org"},
+		{"example.com.cn", "cn", 3, ".com.cn"},
+	} {
+		match, loaded := matcher.Lookup(testCase.domain)
+		require.True(t, loaded, testCase.domain)
+		require.Equal(t, testCase.tag, match.Tag, testCase.domain)
+		require.Equal(t, testCase.index, match.Index, testCase.domain)
+		require.Equal(t, testCase.rule, match.Domain, testCase.domain)
+	}
+	for _, domain := range []string{"www.example.com", "com.cn", "example.org", "sagernet.org.cn"} {
+		_, loaded := matcher.Lookup(domain)
+		require.False(t, loaded, domain)
+		require.False(t, matcher.Match(domain), domain)
+	}
+}
@@ -0,0 +1,132 @@
+// Package idna implements UTS #46 processing of internationalized domain names for lookup,
+// based on golang.org/x/net/idna.
+//
+// Unlike idna.Lookup, underscores and hyphens in the third and fourth position are allowed,
+// as they are common in service names and CDN host names.
+package idna
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	E "github.com/sagernet/sing/common/exceptions"
+
+	"golang.org/x/net/idna"
+)
+
+const acePrefix = "xn--"
+
+var ErrInvalidDomain = E.New("invalid domain name")
+
+var profile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.CheckJoiners(true),
+	idna.StrictDomainName(false),
+	idna.CheckHyphens(false),
+	idna.VerifyDNSLength(true),
+)
+
+// Error describes why a domain name was rejected. It unwraps to ErrInvalidDomain.
+type Error struct {
+	Domain string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return "invalid domain name " + strconv.Quote(e.Domain) + ": " + e.Reason
+}
+
+func (e *Error) Unwrap() error {
+	return ErrInvalidDomain
+}
+
+// ToASCII maps and validates domain and returns its lower-case ASCII form, with non-ASCII labels
+// in punycode. A trailing dot is preserved.
+func ToASCII(domain string) (string, error) {
+	if isNormalASCII(domain) {
+		return domain, nil
+	}
+	if !utf8.ValidString(domain) {
+		return "", &Error{domain, "invalid UTF-8"}
+	}
+	name, rooted := strings.CutSuffix(domain, ".")
+	if name == "" {
+		return "", &Error{domain, "empty label"}
+	}
+	result, err := profile.ToASCII(name)
+	if err != nil {
+		return "", &Error{domain, err.Error()}
+	}
+	for _, label := range strings.Split(result, ".") {
+		err = checkLabel(label)
+		if err != nil {
+			return "", &Error{domain, err.Error()}
+		}
+	}
+	if rooted {
+		result += "."
+	}
+	return result, nil
+}
+
+// ToUnicode validates domain like ToASCII and returns it with punycode labels decoded.
+func ToUnicode(domain string) (string, error) {
+	asciiDomain, err := ToASCII(domain)
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(asciiDomain, acePrefix) {
+		return asciiDomain, nil
+	}
+	name, rooted := strings.CutSuffix(asciiDomain, ".")
+	// validated by ToASCII
+	result, _ := profile.ToUnicode(name)
+	if rooted {
+		result += "."
+	}
+	return result, nil
+}
+
+// isNormalASCII reports whether domain is already in the form returned by ToASCII
+// and contains no punycode label to verify.
+func isNormalASCII(domain string) bool {
+	if domain == "" || len(domain) > 254 {
+		return false
+	}
+	labelStart := 0
+	for i := 0; i <= len(domain); i++ {
+		if i < len(domain) && domain[i] != '.' {
+			c := domain[i]
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+			continue
+		}
+		label := domain[labelStart:i]
+		if label == "" {
+			if i != len(domain) || i == 0 {
+				return false
+			}
+		} else if len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' || strings.HasPrefix(label, acePrefix) {
+			return false
+		}
+		labelStart = i + 1
+	}
+	return len(strings.TrimSuffix(domain, ".")) <= 253
+}
+
+// checkLabel applies the STD3 and hyphen rules relaxed by the profile to an ASCII label.
+func checkLabel(label string) error {
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return E.New("label ", label, ": disallowed character ", strconv.QuoteRune(rune(c)))
+		}
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return E.New("label ", label, ": leading or trailing hyphen")
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package idna
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestToASCII(t *testing.T) {
+	t.Parallel()
+	for input, expected := range map[string]string{
+		"example.com":             "example.com",
+		"_dmarc.example.com.":     "_dmarc.example.com.",
+		"WWW.Example.COM":         "www.example.com",
+		"münchen.de":              "xn--mnchen-3ya.de",
+		"MÜNCHEN.DE":              "xn--mnchen-3ya.de",
+		"xn--mnchen-3ya.de":       "xn--mnchen-3ya.de",
+		"例え.テスト":                  "xn--r8jz45g.xn--zckzah",
+		"ｅｘａｍｐｌｅ。ｃｏｍ":             "example.com",
+		"r3---sn-abc.example.com": "r3---sn-abc.example.com",
+		"fa\u00adß.de":            "xn--fa-hia.de",
+		"mu\u0308nchen.de":        "xn--mnchen-3ya.de",
+		"ﬁle.com":                 "file.com",
+		// symbols are disallowed by IDNA 2008, but allowed by the UTS #46 lookup profile that browsers use
+		"emoji☺.com": "xn--emoji-2k1c.com",
+	} {
+		result, err := ToASCII(input)
+		require.NoError(t, err, input)
+		require.Equal(t, expected, result, input)
+	}
+	for _, input := range []string{
+		"",
+		".",
+		"a..b",
+		"exa mple.com",
+		"-example.com",
+		"example-.com",
+		"xn--example.com",
+		"xn--a.com",
+		"\u0301a.com",
+		"a\u200db.com",
+		"\xff.com",
+	} {
+		_, err := ToASCII(input)
+		require.ErrorIs(t, err, ErrInvalidDomain, input)
+	}
+	result, err := ToUnicode("XN--MNCHEN-3YA.de")
+	require.NoError(t, err)
+	require.Equal(t, "münchen.de", result)
+}
@@ -0,0 +1,325 @@
+package ipcidr
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"net/netip"
+	"sort"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/varbin"
+)
+
+const setVersion = 1
+
+// Set is an immutable set of IP addresses. IPv4-mapped IPv6 addresses are treated as IPv4.
+type Set struct {
+	ipv4 []addrRange
+	ipv6 []addrRange
+}
+
+type setData struct {
+	Version uint8
+	// IPv4 holds start and end of each range.
+	IPv4 []uint32
+	// IPv6 holds start and end of each range as high and low halves.
+	IPv6 []uint64
+}
+
+func New(prefixes []netip.Prefix) *Set {
+	var ipv4, ipv6 []addrRange
+	for _, prefix := range prefixes {
+		if !prefix.IsValid() {
+			continue
+		}
+		prefix = prefix.Masked()
+		addr := prefix.Addr()
+		bitLen := prefix.Bits()
+		// shorter prefixes cover more than the IPv4-mapped range
+		if addr.Is4In6() && bitLen >= 96 {
+			addr = addr.Unmap()
+			bitLen -= 96
+		}
+		if addr.Is4() {
+			ipv4 = append(ipv4, prefixRange(addr, bitLen, 32))
+		} else {
+			ipv6 = append(ipv6, prefixRange(addr, bitLen, 128))
+		}
+	}
+	return &Set{mergeRanges(ipv4), mergeRanges(ipv6)}
+}
+
+func Read(reader varbin.Reader) (*Set, error) {
+	data, err := varbin.ReadValue[setData](reader, binary.BigEndian)
+	if err != nil {
+		return nil, err
+	}
+	if data.Version != setVersion {
+		return nil, E.New("unsupported IP set version: ", data.Version)
+	}
+	if len(data.IPv4)%2 != 0 || len(data.IPv6)%4 != 0 {
+		return nil, E.New("bad IP set payload")
+	}
+	set := &Set{
+		ipv4: make([]addrRange, 0, len(data.IPv4)/2),
+		ipv6: make([]addrRange, 0, len(data.IPv6)/4),
+	}
+	for i := 0; i < len(data.IPv4); i += 2 {
+		set.ipv4 = append(set.ipv4, addrRange{uint128{0, uint64(data.IPv4[i])}, uint128{0, uint64(data.IPv4[i+1])}})
+	}
+	for i := 0; i < len(data.IPv6); i += 4 {
+		set.ipv6 = append(set.ipv6, addrRange{uint128{data.IPv6[i], data.IPv6[i+1]}, uint128{data.IPv6[i+2], data.IPv6[i+3]}})
+	}
+	if !normalized(set.ipv4) || !normalized(set.ipv6) {
+		return nil, E.New("bad IP set payload: ranges not sorted")
+	}
+	return set, nil
+}
+
+func (s *Set) Write(writer varbin.Writer) error {
+	data := setData{
+		Version: setVersion,
+		IPv4:    make([]uint32, 0, len(s.ipv4)*2),
+		IPv6:    make([]uint64, 0, len(s.ipv6)*4),
+	}
+	for _, r := range s.ipv4 {
+		data.IPv4 = append(data.IPv4, uint32(r.start.lo), uint32(r.end.lo))
+	}
+	for _, r := range s.ipv6 {
+		data.IPv6 = append(data.IPv6, r.start.hi, r.start.lo, r.end.hi, r.end.lo)
+	}
+	return varbin.Write(writer, binary.BigEndian, data)
+}
+
+func (s *Set) IsEmpty() bool {
+	return len(s.ipv4) == 0 && len(s.ipv6) == 0
+}
+
+func (s *Set) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return containsRange(s.ipv4, addrRange{addrValue(addr), addrValue(addr)})
+	} else if addr.Is6() {
+		return containsRange(s.ipv6, addrRange{addrValue(addr), addrValue(addr)})
+	}
+	return false
+}
+
+// ContainsPrefix reports whether every address of prefix is in the set.
+func (s *Set) ContainsPrefix(prefix netip.Prefix) bool {
+	if !prefix.IsValid() {
+		return false
+	}
+	other := New([]netip.Prefix{prefix})
+	if len(other.ipv4) > 0 {
+		return containsRange(s.ipv4, other.ipv4[0])
+	}
+	return containsRange(s.ipv6, other.ipv6[0])
+}
+
+func (s *Set) Union(other *Set) *Set {
+	return &Set{
+		mergeRanges(append(append([]addrRange(nil), s.ipv4...), other.ipv4...)),
+		mergeRanges(append(append([]addrRange(nil), s.ipv6...), other.ipv6...)),
+	}
+}
+
+func (s *Set) Intersect(other *Set) *Set {
+	return &Set{intersectRanges(s.ipv4, other.ipv4), intersectRanges(s.ipv6, other.ipv6)}
+}
+
+// Exclude returns the addresses of s that are not in other.
+func (s *Set) Exclude(other *Set) *Set {
+	return &Set{excludeRanges(s.ipv4, other.ipv4), excludeRanges(s.ipv6, other.ipv6)}
+}
+
+// Prefixes returns the minimal list of prefixes covering the set, IPv4 first.
+func (s *Set) Prefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, r := range s.ipv4 {
+		prefixes = appendRangePrefixes(prefixes, r, 32)
+	}
+	for _, r := range s.ipv6 {
+		prefixes = appendRangePrefixes(prefixes, r, 128)
+	}
+	return prefixes
+}
+
+type uint128 struct {
+	hi, lo uint64
+}
+
+func (u uint128) less(other uint128) bool {
+	return u.hi < other.hi || u.hi == other.hi && u.lo < other.lo
+}
+
+func (u uint128) addOne() uint128 {
+	lo, carry := bits.Add64(u.lo, 1, 0)
+	return uint128{u.hi + carry, lo}
+}
+
+func (u uint128) subOne() uint128 {
+	lo, borrow := bits.Sub64(u.lo, 1, 0)
+	return uint128{u.hi - borrow, lo}
+}
+
+func (u uint128) or(other uint128) uint128 {
+	return uint128{u.hi | other.hi, u.lo | other.lo}
+}
+
+// hostMask returns a value with the low n bits set.
+func hostMask(n int) uint128 {
+	switch {
+	case n <= 0:
+		return uint128{}
+	case n < 64:
+		return uint128{0, 1<<n - 1}
+	case n < 128:
+		return uint128{1<<(n-64) - 1, ^uint64(0)}
+	default:
+		return uint128{^uint64(0), ^uint64(0)}
+	}
+}
+
+func (u uint128) trailingZeros() int {
+	if u.lo != 0 {
+		return bits.TrailingZeros64(u.lo)
+	}
+	return 64 + bits.TrailingZeros64(u.hi)
+}
+
+type addrRange struct {
+	start, end uint128
+}
+
+func addrValue(addr netip.Addr) uint128 {
+	if addr.Is4() {
+		b := addr.As4()
+		return uint128{0, uint64(binary.BigEndian.Uint32(b[:]))}
+	}
+	b := addr.As16()
+	return uint128{binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])}
+}
+
+func valueAddr(value uint128, bitLen int) netip.Addr {
+	if bitLen == 32 {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(value.lo))
+		return netip.AddrFrom4(b)
+	}
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], value.hi)
+	binary.BigEndian.PutUint64(b[8:], value.lo)
+	return netip.AddrFrom16(b)
+}
+
+func prefixRange(addr netip.Addr, prefixBits int, bitLen int) addrRange {
+	start := addrValue(netip.PrefixFrom(addr, prefixBits).Masked().Addr())
+	return addrRange{start, start.or(hostMask(bitLen - prefixBits))}
+}
+
+// mergeRanges sorts ranges and merges overlapping and adjacent ones.
+func mergeRanges(ranges []addrRange) []addrRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start.less(ranges[j].start)
+	})
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if last.end.less(r.start) && last.end.addOne().less(r.start) {
+			merged = append(merged, r)
+		} else if last.end.less(r.end) {
+			last.end = r.end
+		}
+	}
+	return merged
+}
+
+func normalized(ranges []addrRange) bool {
+	for i, r := range ranges {
+		if r.end.less(r.start) {
+			return false
+		}
+		if i > 0 && (!ranges[i-1].end.less(r.start) || !ranges[i-1].end.addOne().less(r.start)) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsRange(ranges []addrRange, target addrRange) bool {
+	index := sort.Search(len(ranges), func(i int) bool {
+		return !ranges[i].end.less(target.end)
+	})
+	return index < len(ranges) && !target.start.less(ranges[index].start)
+}
+
+func intersectRanges(a []addrRange, b []addrRange) []addrRange {
+	var result []addrRange
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		start := a[i].start
+		if start.less(b[j].start) {
+			start = b[j].start
+		}
+		end := a[i].end
+		if b[j].end.less(end) {
+			end = b[j].end
+		}
+		if !end.less(start) {
+			result = append(result, addrRange{start, end})
+		}
+		if a[i].end.less(b[j].end) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+func excludeRanges(ranges []addrRange, excluded []addrRange) []addrRange {
+	var result []addrRange
+	j := 0
+	for _, r := range ranges {
+		start := r.start
+		for ; j < len(excluded) && excluded[j].end.less(start); j++ {
+		}
+		covered := false
+		for k := j; k < len(excluded) && !r.end.less(excluded[k].start); k++ {
+			if start.less(excluded[k].start) {
+				result = append(result, addrRange{start, excluded[k].start.subOne()})
+			}
+			if !excluded[k].end.less(r.end) {
+				covered = true
+				break
+			}
+			start = excluded[k].end.addOne()
+		}
+		if !covered {
+			result = append(result, addrRange{start, r.end})
+		}
+	}
+	return result
+}
+
+func appendRangePrefixes(prefixes []netip.Prefix, r addrRange, bitLen int) []netip.Prefix {
+	start := r.start
+	for {
+		hostBits := start.trailingZeros()
+		if hostBits > bitLen {
+			hostBits = bitLen
+		}
+		for hostBits > 0 && r.end.less(start.or(hostMask(hostBits))) {
+			hostBits--
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(valueAddr(start, bitLen), bitLen-hostBits))
+		last := start.or(hostMask(hostBits))
+		if !last.less(r.end) {
+			return prefixes
+		}
+		start = last.addOne()
+	}
+}
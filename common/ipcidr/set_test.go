@@ -0,0 +1,62 @@
+package ipcidr_test
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+
+	"github.com/sagernet/sing/common/ipcidr"
+
+	"github.com/stretchr/testify/require"
+)
+
+func parsePrefixes(prefixes ...string) []netip.Prefix {
+	result := make([]netip.Prefix, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		result = append(result, netip.MustParsePrefix(prefix))
+	}
+	return result
+}
+
+func TestSet(t *testing.T) {
+	t.Parallel()
+	set := ipcidr.New(parsePrefixes("10.0.0.0/9", "10.128.0.0/9", "192.168.1.0/24", "192.168.1.128/25", "255.255.255.255/32", "2001:db8::/32", "::ffff:1.1.1.0/120"))
+	require.Equal(t, parsePrefixes("1.1.1.0/24", "10.0.0.0/8", "192.168.1.0/24", "255.255.255.255/32", "2001:db8::/32"), set.Prefixes())
+	require.True(t, set.Contains(netip.MustParseAddr("10.200.0.1")))
+	require.True(t, set.Contains(netip.MustParseAddr("::ffff:10.0.0.1")))
+	require.True(t, set.Contains(netip.MustParseAddr("255.255.255.255")))
+	require.True(t, set.Contains(netip.MustParseAddr("2001:db8:ffff::1")))
+	require.False(t, set.Contains(netip.MustParseAddr("11.0.0.1")))
+	require.False(t, set.Contains(netip.MustParseAddr("2001:db9::1")))
+	require.True(t, set.ContainsPrefix(netip.MustParsePrefix("10.1.0.0/16")))
+	require.False(t, set.ContainsPrefix(netip.MustParsePrefix("192.168.0.0/16")))
+
+	var buffer bytes.Buffer
+	require.NoError(t, set.Write(&buffer))
+	readSet, err := ipcidr.Read(&buffer)
+	require.NoError(t, err)
+	require.Equal(t, set.Prefixes(), readSet.Prefixes())
+
+	other := ipcidr.New(parsePrefixes("10.0.0.0/16", "172.16.0.0/12", "2001:db8:1::/48"))
+	require.Equal(t, parsePrefixes("1.1.1.0/24", "10.0.0.0/8", "172.16.0.0/12", "192.168.1.0/24", "255.255.255.255/32", "2001:db8::/32"), set.Union(other).Prefixes())
+	require.Equal(t, parsePrefixes("10.0.0.0/16", "2001:db8:1::/48"), set.Intersect(other).Prefixes())
+	excluded := set.Exclude(ipcidr.New(parsePrefixes("10.0.0.0/9", "10.255.255.255/32")))
+	require.Equal(t, parsePrefixes(
+		"1.1.1.0/24",
+		"10.128.0.0/10", "10.192.0.0/11", "10.224.0.0/12", "10.240.0.0/13", "10.248.0.0/14", "10.252.0.0/15", "10.254.0.0/16",
+		"10.255.0.0/17", "10.255.128.0/18", "10.255.192.0/19", "10.255.224.0/20", "10.255.240.0/21", "10.255.248.0/22",
+		"10.255.252.0/23", "10.255.254.0/24", "10.255.255.0/25", "10.255.255.128/26", "10.255.255.192/27", "10.255.255.224/28",
+		"10.255.255.240/29", "10.255.255.248/30", "10.255.255.252/31", "10.255.255.254/32",
+		"192.168.1.0/24", "255.255.255.255/32", "2001:db8::/32",
+	), excluded.Prefixes())
+	require.True(t, set.Exclude(ipcidr.New(parsePrefixes("0.0.0.0/0", "::/0"))).IsEmpty())
+}
+
+func TestSetMappedPrefix(t *testing.T) {
+	t.Parallel()
+	set := ipcidr.New(parsePrefixes("::ffff:0:0/80", "::ffff:10.0.0.0/104"))
+	require.Equal(t, parsePrefixes("10.0.0.0/8", "::/80"), set.Prefixes())
+	require.True(t, set.Contains(netip.MustParseAddr("10.1.2.3")))
+	require.False(t, set.Contains(netip.MustParseAddr("1.2.3.4")))
+	require.True(t, set.Contains(netip.MustParseAddr("::1")))
+}
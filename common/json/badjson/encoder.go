@@ -0,0 +1,272 @@
+package badjson
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/common/x/collections"
+)
+
+// Encoder writes values returned by Decode to a writer piece by piece, without building the whole document in memory.
+type Encoder struct {
+	writer    *bufio.Writer
+	canonical bool
+}
+
+func NewEncoder(writer io.Writer) *Encoder {
+	return &Encoder{writer: bufio.NewWriter(writer)}
+}
+
+// SetCanonical makes the encoder write the JSON Canonicalization Scheme (RFC 8785) form of values.
+func (e *Encoder) SetCanonical(canonical bool) {
+	e.canonical = canonical
+}
+
+func (e *Encoder) Encode(value any) error {
+	err := (&valueWriter{writer: e.writer, canonical: e.canonical}).value(value)
+	if err != nil {
+		return err
+	}
+	return e.writer.Flush()
+}
+
+// Canonicalize returns the JSON Canonicalization Scheme (RFC 8785) form of JSON content.
+func Canonicalize(content []byte) ([]byte, error) {
+	value, err := decodeValue(content)
+	if err != nil {
+		return nil, err
+	}
+	return MarshalCanonical(value)
+}
+
+// MarshalCanonical returns the JSON Canonicalization Scheme (RFC 8785) form of a value.
+// Values other than those returned by Decode are marshalled first.
+func MarshalCanonical(value any) ([]byte, error) {
+	var builder strings.Builder
+	err := (&valueWriter{writer: &builder, canonical: true}).value(value)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(builder.String()), nil
+}
+
+type stringWriter interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+}
+
+type valueWriter struct {
+	writer    stringWriter
+	canonical bool
+}
+
+func (w *valueWriter) value(value any) error {
+	switch typedValue := value.(type) {
+	case *JSONObject:
+		return w.object(typedValue)
+	case JSONArray:
+		w.writer.WriteByte('[')
+		for i, item := range typedValue {
+			if i > 0 {
+				w.writer.WriteByte(',')
+			}
+			err := w.value(item)
+			if err != nil {
+				return err
+			}
+		}
+		w.writer.WriteByte(']')
+		return nil
+	}
+	if !w.canonical {
+		content, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, err = w.writer.Write(content)
+		return err
+	}
+	switch typedValue := value.(type) {
+	case nil:
+		w.writer.WriteString("null")
+	case bool:
+		w.writer.WriteString(strconv.FormatBool(typedValue))
+	case string:
+		w.string(typedValue)
+	case json.Number:
+		number, err := strconv.ParseFloat(string(typedValue), 64)
+		if err != nil {
+			return E.Cause(err, "invalid number ", typedValue)
+		}
+		return w.number(number)
+	case float64:
+		return w.number(typedValue)
+	default:
+		content, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		decoded, err := decodeValue(content)
+		if err != nil {
+			return err
+		}
+		return w.value(decoded)
+	}
+	return nil
+}
+
+func (w *valueWriter) object(object *JSONObject) error {
+	entries := object.Entries()
+	if w.canonical {
+		// keys are sorted by their UTF-16 code units
+		keys := make([][]uint16, len(entries))
+		for i, entry := range entries {
+			keys[i] = utf16.Encode([]rune(entry.Key))
+		}
+		sort.Sort(&utf16Entries{keys, entries})
+	}
+	w.writer.WriteByte('{')
+	for i, entry := range entries {
+		if i > 0 {
+			w.writer.WriteByte(',')
+		}
+		if w.canonical {
+			w.string(entry.Key)
+		} else {
+			keyContent, err := json.Marshal(entry.Key)
+			if err != nil {
+				return err
+			}
+			w.writer.Write(keyContent)
+		}
+		w.writer.WriteByte(':')
+		err := w.value(entry.Value)
+		if err != nil {
+			return err
+		}
+	}
+	w.writer.WriteByte('}')
+	return nil
+}
+
+// string writes a string with the minimal escaping of RFC 8785.
+func (w *valueWriter) string(value string) {
+	const hexDigits = "0123456789abcdef"
+	w.writer.WriteByte('"')
+	for i := 0; i < len(value); {
+		c := value[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(value[i:])
+			if r == utf8.RuneError && size == 1 {
+				w.writer.WriteString(`�`)
+			} else {
+				w.writer.WriteString(value[i : i+size])
+			}
+			i += size
+			continue
+		}
+		switch c {
+		case '"', '\\':
+			w.writer.WriteByte('\\')
+			w.writer.WriteByte(c)
+		case '\b':
+			w.writer.WriteString(`\b`)
+		case '\f':
+			w.writer.WriteString(`\f`)
+		case '\n':
+			w.writer.WriteString(`\n`)
+		case '\r':
+			w.writer.WriteString(`\r`)
+		case '\t':
+			w.writer.WriteString(`\t`)
+		default:
+			if c < 0x20 {
+				w.writer.WriteString(`\u00`)
+				w.writer.WriteByte(hexDigits[c>>4])
+				w.writer.WriteByte(hexDigits[c&0xF])
+			} else {
+				w.writer.WriteByte(c)
+			}
+		}
+		i++
+	}
+	w.writer.WriteByte('"')
+}
+
+// number writes a number as serialized by ECMAScript Number.prototype.toString, as required by RFC 8785.
+func (w *valueWriter) number(value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return E.New("unsupported number ", strconv.FormatFloat(value, 'g', -1, 64))
+	}
+	if value == 0 {
+		w.writer.WriteByte('0')
+		return nil
+	}
+	if value < 0 {
+		w.writer.WriteByte('-')
+		value = -value
+	}
+	// shortest digits that round-trip, and the position of the decimal point
+	mantissa, exponent, _ := strings.Cut(strconv.FormatFloat(value, 'e', -1, 64), "e")
+	digits := strings.Replace(mantissa, ".", "", 1)
+	point, _ := strconv.Atoi(exponent)
+	point++
+	switch {
+	case len(digits) <= point && point <= 21:
+		w.writer.WriteString(digits)
+		w.writer.WriteString(strings.Repeat("0", point-len(digits)))
+	case 0 < point && point <= 21:
+		w.writer.WriteString(digits[:point])
+		w.writer.WriteByte('.')
+		w.writer.WriteString(digits[point:])
+	case -6 < point && point <= 0:
+		w.writer.WriteString("0.")
+		w.writer.WriteString(strings.Repeat("0", -point))
+		w.writer.WriteString(digits)
+	default:
+		w.writer.WriteString(digits[:1])
+		if len(digits) > 1 {
+			w.writer.WriteByte('.')
+			w.writer.WriteString(digits[1:])
+		}
+		w.writer.WriteByte('e')
+		if point-1 > 0 {
+			w.writer.WriteByte('+')
+		}
+		w.writer.WriteString(strconv.Itoa(point - 1))
+	}
+	return nil
+}
+
+type utf16Entries struct {
+	keys    [][]uint16
+	entries []collections.MapEntry[string, any]
+}
+
+func (e *utf16Entries) Len() int {
+	return len(e.keys)
+}
+
+func (e *utf16Entries) Less(i, j int) bool {
+	a, b := e.keys[i], e.keys[j]
+	for k := 0; k < len(a) && k < len(b); k++ {
+		if a[k] != b[k] {
+			return a[k] < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+func (e *utf16Entries) Swap(i, j int) {
+	e.keys[i], e.keys[j] = e.keys[j], e.keys[i]
+	e.entries[i], e.entries[j] = e.entries[j], e.entries[i]
+}
@@ -0,0 +1,86 @@
+package badjson_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/sagernet/sing/common/json/badjson"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalize(t *testing.T) {
+	t.Parallel()
+	content, err := badjson.Canonicalize([]byte(`{
+  "numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+  "string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+  "literals": [null, true, false]
+}`))
+	require.NoError(t, err)
+	require.Equal(t, `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`, string(content))
+}
+
+func TestCanonicalizeKeyOrder(t *testing.T) {
+	t.Parallel()
+	content, err := badjson.Canonicalize([]byte(`{"\u20ac":"Euro Sign","\r":"Carriage Return","\ufb33":"Hebrew Letter Dalet With Dagesh","1":"One","\ud83d\ude00":"Emoji: Grinning Face","\u0080":"Control","\u00f6":"Latin Small Letter O With Diaeresis"}`))
+	require.NoError(t, err)
+	require.Equal(t, "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\",\"\U0001f600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}", string(content))
+}
+
+func TestCanonicalNumbers(t *testing.T) {
+	t.Parallel()
+	for input, expected := range map[string]string{
+		"0":                      "0",
+		"-0":                     "0",
+		"1":                      "1",
+		"-1.5":                   "-1.5",
+		"100":                    "100",
+		"1e21":                   "1e+21",
+		"1e20":                   "100000000000000000000",
+		"123456789012345680000":  "123456789012345680000",
+		"0.000001":               "0.000001",
+		"0.0000001":              "1e-7",
+		"9007199254740993":       "9007199254740992",
+		"1.7976931348623157e308": "1.7976931348623157e+308",
+		"5e-324":                 "5e-324",
+	} {
+		content, err := badjson.Canonicalize([]byte(input))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(content), input)
+	}
+}
+
+func TestMarshalCanonical(t *testing.T) {
+	t.Parallel()
+	content, err := badjson.MarshalCanonical(struct {
+		B string  `json:"b"`
+		A float64 `json:"a"`
+	}{B: "<&>", A: 1e-7})
+	require.NoError(t, err)
+	require.Equal(t, `{"a":1e-7,"b":"<&>"}`, string(content))
+}
+
+func TestEncoder(t *testing.T) {
+	t.Parallel()
+	value := decode(t, `{"z":[1,{"b":"","a":[]}],"y":{},"x":null}`)
+	expected, err := badjson.Encode(value)
+	require.NoError(t, err)
+	var buffer bytes.Buffer
+	require.NoError(t, badjson.NewEncoder(&buffer).Encode(value))
+	require.Equal(t, string(expected), buffer.String())
+	require.Equal(t, `{"z":[1,{"b":"","a":[]}],"y":{},"x":null}`, buffer.String())
+	buffer.Reset()
+	encoder := badjson.NewEncoder(&buffer)
+	encoder.SetCanonical(true)
+	require.NoError(t, encoder.Encode(value))
+	require.Equal(t, `{"x":null,"y":{},"z":[1,{"a":[],"b":""}]}`, buffer.String())
+}
+
+func TestEncoderUnsupportedNumber(t *testing.T) {
+	t.Parallel()
+	encoder := badjson.NewEncoder(&bytes.Buffer{})
+	encoder.SetCanonical(true)
+	require.EqualError(t, encoder.Encode(badjson.JSONArray{math.Inf(1)}), "unsupported number +Inf")
+	require.EqualError(t, encoder.Encode(badjson.JSONArray{math.NaN()}), "unsupported number NaN")
+}
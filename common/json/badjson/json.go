@@ -12,6 +12,23 @@ func Decode(content []byte) (any, error) {
 	return decodeJSON(decoder)
 }
 
+// decodeValue decodes content like Decode, keeping numbers as json.Number.
+func decodeValue(content []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	return decodeJSON(decoder)
+}
+
+// Encode returns the JSON of a value returned by Decode, including empty values that JSONObject.MarshalJSON omits.
+func Encode(value any) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := (&valueWriter{writer: &buffer}).value(value)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
 func decodeJSON(decoder *json.Decoder) (any, error) {
 	rawToken, err := decoder.Token()
 	if err != nil {
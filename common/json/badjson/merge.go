@@ -98,14 +98,17 @@ func MergeJSON(rawSource json.RawMessage, rawDestination json.RawMessage, disabl
 	} else if destination == nil {
 		return json.Marshal(source)
 	}
-	merged, err := mergeJSON(source, destination, disableAppend)
+	merged, err := mergeJSON(source, destination, disableAppend, false)
 	if err != nil {
 		return nil, err
 	}
 	return json.Marshal(merged)
 }
 
-func mergeJSON(anySource any, anyDestination any, disableAppend bool) (any, error) {
+// mergeJSON merges source into destination, appending arrays and merging objects by key.
+// Other values of destination are kept, unless override is set, in which case source replaces them
+// and every value it cannot be merged with.
+func mergeJSON(anySource any, anyDestination any, disableAppend bool, override bool) (any, error) {
 	switch destination := anyDestination.(type) {
 	case JSONArray:
 		if !disableAppend {
@@ -113,6 +116,9 @@ func mergeJSON(anySource any, anyDestination any, disableAppend bool) (any, erro
 			case JSONArray:
 				destination = append(destination, source...)
 			default:
+				if override {
+					return source, nil
+				}
 				destination = append(destination, source)
 			}
 		}
@@ -124,7 +130,7 @@ func mergeJSON(anySource any, anyDestination any, disableAppend bool) (any, erro
 				oldValue, loaded := destination.Get(entry.Key)
 				if loaded {
 					var err error
-					entry.Value, err = mergeJSON(entry.Value, oldValue, disableAppend)
+					entry.Value, err = mergeJSON(entry.Value, oldValue, disableAppend, override)
 					if err != nil {
 						return nil, E.Cause(err, "merge object item ", entry.Key)
 					}
@@ -132,10 +138,16 @@ func mergeJSON(anySource any, anyDestination any, disableAppend bool) (any, erro
 				destination.Put(entry.Key, entry.Value)
 			}
 		default:
+			if override {
+				return source, nil
+			}
 			return nil, E.New("cannot merge json object into ", reflect.TypeOf(source))
 		}
 		return destination, nil
 	default:
+		if override {
+			return anySource, nil
+		}
 		return destination, nil
 	}
 }
@@ -0,0 +1,551 @@
+package badjson
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json"
+)
+
+// JSON Patch (RFC 6902) operations.
+const (
+	PatchOperationAdd     = "add"
+	PatchOperationRemove  = "remove"
+	PatchOperationReplace = "replace"
+	PatchOperationMove    = "move"
+	PatchOperationCopy    = "copy"
+	PatchOperationTest    = "test"
+)
+
+// PatchOperation is an operation of a JSON Patch. Paths are JSON Pointers (RFC 6901).
+type PatchOperation struct {
+	Op    string
+	Path  string
+	From  string
+	Value any
+}
+
+func (o PatchOperation) MarshalJSON() ([]byte, error) {
+	var object JSONObject
+	object.Put("op", o.Op)
+	object.Put("path", o.Path)
+	switch o.Op {
+	case PatchOperationMove, PatchOperationCopy:
+		object.Put("from", o.From)
+	case PatchOperationAdd, PatchOperationReplace, PatchOperationTest:
+		object.Put("value", o.Value)
+	}
+	return Encode(&object)
+}
+
+func (o *PatchOperation) UnmarshalJSON(content []byte) error {
+	value, err := decodeValue(content)
+	if err != nil {
+		return err
+	}
+	object, isObject := value.(*JSONObject)
+	if !isObject {
+		return E.New("expected patch operation object")
+	}
+	var operation PatchOperation
+	for _, field := range []struct {
+		name  string
+		value *string
+	}{{"op", &operation.Op}, {"path", &operation.Path}, {"from", &operation.From}} {
+		rawValue, loaded := object.Get(field.name)
+		if !loaded {
+			continue
+		}
+		stringValue, isString := rawValue.(string)
+		if !isString {
+			return E.New("patch operation: expected string for ", field.name)
+		}
+		*field.value = stringValue
+	}
+	var loaded bool
+	operation.Value, loaded = object.Get("value")
+	switch operation.Op {
+	case PatchOperationAdd, PatchOperationReplace, PatchOperationTest:
+		if !loaded {
+			return E.New("patch operation ", operation.Op, ": missing value")
+		}
+	case PatchOperationMove, PatchOperationCopy:
+		if !object.ContainsKey("from") {
+			return E.New("patch operation ", operation.Op, ": missing from")
+		}
+	case PatchOperationRemove:
+	default:
+		return E.New("unknown patch operation ", strconv.Quote(operation.Op))
+	}
+	if !object.ContainsKey("path") {
+		return E.New("patch operation ", operation.Op, ": missing path")
+	}
+	*o = operation
+	return nil
+}
+
+// Patch is a JSON Patch (RFC 6902) document.
+type Patch []PatchOperation
+
+// Apply applies the patch to a copy of a value returned by Decode. If any operation fails, the patch is not applied.
+func (p Patch) Apply(document any) (any, error) {
+	document = copyJSON(document)
+	for i, operation := range p {
+		var err error
+		document, err = operation.apply(document)
+		if err != nil {
+			return nil, E.Cause(err, "patch operation ", i, " (", operation.Op, " ", operation.Path, ")")
+		}
+	}
+	return document, nil
+}
+
+func (o PatchOperation) apply(document any) (any, error) {
+	path, err := parsePointer(o.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch o.Op {
+	case PatchOperationAdd:
+		return addValue(document, path, copyJSON(o.Value))
+	case PatchOperationRemove:
+		document, _, err = removeValue(document, path)
+		return document, err
+	case PatchOperationReplace:
+		return replaceValue(document, path, copyJSON(o.Value))
+	case PatchOperationMove:
+		from, err := parsePointer(o.From)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) > len(from) && isPointerPrefix(from, path) {
+			return nil, E.New("cannot move a value into itself")
+		}
+		var value any
+		document, value, err = removeValue(document, from)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(document, path, value)
+	case PatchOperationCopy:
+		from, err := parsePointer(o.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := getValue(document, from)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(document, path, copyJSON(value))
+	case PatchOperationTest:
+		value, err := getValue(document, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equalJSON(value, o.Value) {
+			return nil, E.New("test failed")
+		}
+		return document, nil
+	default:
+		return nil, E.New("unknown patch operation ", strconv.Quote(o.Op))
+	}
+}
+
+// ApplyPatch applies a JSON Patch to the JSON form of value.
+func ApplyPatch[T any](value T, patch Patch) (T, error) {
+	document, err := toDocument(value)
+	if err != nil {
+		return common.DefaultValue[T](), err
+	}
+	document, err = patch.Apply(document)
+	if err != nil {
+		return common.DefaultValue[T](), err
+	}
+	return fromDocument[T](document)
+}
+
+// MergePatch applies a JSON Merge Patch (RFC 7386) to a copy of a value returned by Decode.
+func MergePatch(document any, patch any) any {
+	patchObject, isObject := patch.(*JSONObject)
+	if !isObject {
+		return copyJSON(patch)
+	}
+	documentObject, isObject := document.(*JSONObject)
+	if isObject {
+		documentObject = copyJSON(documentObject).(*JSONObject)
+	} else {
+		documentObject = new(JSONObject)
+	}
+	for _, entry := range patchObject.Entries() {
+		if entry.Value == nil {
+			documentObject.Remove(entry.Key)
+			continue
+		}
+		oldValue, _ := documentObject.Get(entry.Key)
+		documentObject.Put(entry.Key, MergePatch(oldValue, entry.Value))
+	}
+	return documentObject
+}
+
+// ApplyMergePatch applies a JSON Merge Patch to the JSON form of value.
+func ApplyMergePatch[T any](value T, rawPatch json.RawMessage) (T, error) {
+	document, err := toDocument(value)
+	if err != nil {
+		return common.DefaultValue[T](), err
+	}
+	patch, err := decodeValue(rawPatch)
+	if err != nil {
+		return common.DefaultValue[T](), E.Cause(err, "decode merge patch")
+	}
+	return fromDocument[T](MergePatch(document, patch))
+}
+
+// Diff returns a JSON Patch that turns source into destination, both values returned by Decode.
+// Objects are compared by key and arrays by their longest common subsequence, so that unchanged values are not repeated.
+func Diff(source any, destination any) Patch {
+	var patch Patch
+	diffValue(&patch, "", source, destination)
+	return patch
+}
+
+// DiffValues returns a JSON Patch that turns the JSON form of source into the JSON form of destination.
+func DiffValues[T any](source T, destination T) (Patch, error) {
+	sourceDocument, err := toDocument(source)
+	if err != nil {
+		return nil, err
+	}
+	destinationDocument, err := toDocument(destination)
+	if err != nil {
+		return nil, err
+	}
+	return Diff(sourceDocument, destinationDocument), nil
+}
+
+func diffValue(patch *Patch, path string, source any, destination any) {
+	if equalJSON(source, destination) {
+		return
+	}
+	switch sourceValue := source.(type) {
+	case *JSONObject:
+		if destinationObject, isObject := destination.(*JSONObject); isObject {
+			diffObject(patch, path, sourceValue, destinationObject)
+			return
+		}
+	case JSONArray:
+		if destinationArray, isArray := destination.(JSONArray); isArray {
+			diffArray(patch, path, sourceValue, destinationArray)
+			return
+		}
+	}
+	*patch = append(*patch, PatchOperation{Op: PatchOperationReplace, Path: path, Value: copyJSON(destination)})
+}
+
+func diffObject(patch *Patch, path string, source *JSONObject, destination *JSONObject) {
+	for _, entry := range source.Entries() {
+		if !destination.ContainsKey(entry.Key) {
+			*patch = append(*patch, PatchOperation{Op: PatchOperationRemove, Path: path + "/" + escapePointer(entry.Key)})
+		}
+	}
+	for _, entry := range destination.Entries() {
+		entryPath := path + "/" + escapePointer(entry.Key)
+		oldValue, loaded := source.Get(entry.Key)
+		if !loaded {
+			*patch = append(*patch, PatchOperation{Op: PatchOperationAdd, Path: entryPath, Value: copyJSON(entry.Value)})
+			continue
+		}
+		diffValue(patch, entryPath, oldValue, entry.Value)
+	}
+}
+
+func diffArray(patch *Patch, path string, source JSONArray, destination JSONArray) {
+	// lengths[i][j] is the length of the longest common subsequence of source[i:] and destination[j:]
+	lengths := make([][]int, len(source)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(destination)+1)
+	}
+	for i := len(source) - 1; i >= 0; i-- {
+		for j := len(destination) - 1; j >= 0; j-- {
+			if equalJSON(source[i], destination[j]) {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	var i, j, index int
+	for i < len(source) || j < len(destination) {
+		indexPath := path + "/" + strconv.Itoa(index)
+		switch {
+		case i < len(source) && j < len(destination) && equalJSON(source[i], destination[j]):
+			i++
+			j++
+			index++
+		case i < len(source) && j < len(destination) && lengths[i+1][j] == lengths[i][j+1]:
+			// neither side is part of the common subsequence: change the item in place
+			diffValue(patch, indexPath, source[i], destination[j])
+			i++
+			j++
+			index++
+		case j == len(destination) || i < len(source) && lengths[i+1][j] >= lengths[i][j+1]:
+			*patch = append(*patch, PatchOperation{Op: PatchOperationRemove, Path: indexPath})
+			i++
+		default:
+			*patch = append(*patch, PatchOperation{Op: PatchOperationAdd, Path: indexPath, Value: copyJSON(destination[j])})
+			j++
+			index++
+		}
+	}
+}
+
+func toDocument(value any) (any, error) {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return nil, E.Cause(err, "marshal value")
+	}
+	return decodeValue(content)
+}
+
+func fromDocument[T any](document any) (T, error) {
+	content, err := Encode(document)
+	if err != nil {
+		return common.DefaultValue[T](), E.Cause(err, "marshal patched value")
+	}
+	var value T
+	err = json.Unmarshal(content, &value)
+	if err != nil {
+		return common.DefaultValue[T](), E.Cause(err, "unmarshal patched value")
+	}
+	return value, nil
+}
+
+func equalJSON(a any, b any) bool {
+	switch aValue := a.(type) {
+	case *JSONObject:
+		bObject, isObject := b.(*JSONObject)
+		if !isObject || aValue.Size() != bObject.Size() {
+			return false
+		}
+		for _, entry := range aValue.Entries() {
+			bValue, loaded := bObject.Get(entry.Key)
+			if !loaded || !equalJSON(entry.Value, bValue) {
+				return false
+			}
+		}
+		return true
+	case JSONArray:
+		bArray, isArray := b.(JSONArray)
+		if !isArray || len(aValue) != len(bArray) {
+			return false
+		}
+		for i := range aValue {
+			if !equalJSON(aValue[i], bArray[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number, float64:
+		aNumber, aLoaded := numberValue(a)
+		bNumber, bLoaded := numberValue(b)
+		return aLoaded && bLoaded && aNumber.Cmp(bNumber) == 0
+	default:
+		return a == b
+	}
+}
+
+func numberValue(value any) (*big.Rat, bool) {
+	switch number := value.(type) {
+	case json.Number:
+		return new(big.Rat).SetString(string(number))
+	case float64:
+		rat := new(big.Rat)
+		if rat.SetFloat64(number) == nil {
+			return nil, false
+		}
+		return rat, true
+	default:
+		return nil, false
+	}
+}
+
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, E.New("invalid JSON pointer ", strconv.Quote(pointer))
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+func isPointerPrefix(prefix []string, path []string) bool {
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func arrayIndex(array JSONArray, token string, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return len(array), nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || token != strconv.Itoa(index) {
+		return 0, E.New("invalid array index ", strconv.Quote(token))
+	}
+	maxIndex := len(array) - 1
+	if allowEnd {
+		maxIndex++
+	}
+	if index > maxIndex {
+		return 0, E.New("array index ", index, " out of bounds")
+	}
+	return index, nil
+}
+
+func getValue(document any, path []string) (any, error) {
+	for _, token := range path {
+		switch container := document.(type) {
+		case *JSONObject:
+			value, loaded := container.Get(token)
+			if !loaded {
+				return nil, E.New("missing key ", strconv.Quote(token))
+			}
+			document = value
+		case JSONArray:
+			index, err := arrayIndex(container, token, false)
+			if err != nil {
+				return nil, err
+			}
+			document = container[index]
+		default:
+			return nil, E.New("cannot index into a non-container value with ", strconv.Quote(token))
+		}
+	}
+	return document, nil
+}
+
+// updateParent applies update to the parent of path, and returns the document with the updated parent.
+func updateParent(document any, path []string, update func(parent any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return update(document, path[0])
+	}
+	token := path[0]
+	switch container := document.(type) {
+	case *JSONObject:
+		child, loaded := container.Get(token)
+		if !loaded {
+			return nil, E.New("missing key ", strconv.Quote(token))
+		}
+		child, err := updateParent(child, path[1:], update)
+		if err != nil {
+			return nil, err
+		}
+		container.Put(token, child)
+		return container, nil
+	case JSONArray:
+		index, err := arrayIndex(container, token, false)
+		if err != nil {
+			return nil, err
+		}
+		container[index], err = updateParent(container[index], path[1:], update)
+		if err != nil {
+			return nil, err
+		}
+		return container, nil
+	default:
+		return nil, E.New("cannot index into a non-container value with ", strconv.Quote(token))
+	}
+}
+
+func addValue(document any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updateParent(document, path, func(parent any, token string) (any, error) {
+		switch container := parent.(type) {
+		case *JSONObject:
+			container.Put(token, value)
+			return container, nil
+		case JSONArray:
+			index, err := arrayIndex(container, token, true)
+			if err != nil {
+				return nil, err
+			}
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+			return container, nil
+		default:
+			return nil, E.New("cannot add to a non-container value")
+		}
+	})
+}
+
+func replaceValue(document any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updateParent(document, path, func(parent any, token string) (any, error) {
+		switch container := parent.(type) {
+		case *JSONObject:
+			if !container.ContainsKey(token) {
+				return nil, E.New("missing key ", strconv.Quote(token))
+			}
+			container.Put(token, value)
+			return container, nil
+		case JSONArray:
+			index, err := arrayIndex(container, token, false)
+			if err != nil {
+				return nil, err
+			}
+			container[index] = value
+			return container, nil
+		default:
+			return nil, E.New("cannot replace in a non-container value")
+		}
+	})
+}
+
+func removeValue(document any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, document, nil
+	}
+	var removed any
+	document, err := updateParent(document, path, func(parent any, token string) (any, error) {
+		switch container := parent.(type) {
+		case *JSONObject:
+			value, loaded := container.Get(token)
+			if !loaded {
+				return nil, E.New("missing key ", strconv.Quote(token))
+			}
+			removed = value
+			container.Remove(token)
+			return container, nil
+		case JSONArray:
+			index, err := arrayIndex(container, token, false)
+			if err != nil {
+				return nil, err
+			}
+			removed = container[index]
+			return append(container[:index:index], container[index+1:]...), nil
+		default:
+			return nil, E.New("cannot remove from a non-container value")
+		}
+	})
+	return document, removed, err
+}
@@ -0,0 +1,160 @@
+package badjson_test
+
+import (
+	"testing"
+
+	"github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/common/json/badjson"
+
+	"github.com/stretchr/testify/require"
+)
+
+func decodePatch(t *testing.T, content string) badjson.Patch {
+	var patch badjson.Patch
+	require.NoError(t, json.Unmarshal([]byte(content), &patch))
+	return patch
+}
+
+func decode(t *testing.T, content string) any {
+	value, err := badjson.Decode([]byte(content))
+	require.NoError(t, err)
+	return value
+}
+
+func requireDocument(t *testing.T, expected string, document any) {
+	content, err := badjson.Encode(document)
+	require.NoError(t, err)
+	require.JSONEq(t, expected, string(content))
+}
+
+func TestPatchApply(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range []struct {
+		document string
+		patch    string
+		expected string
+	}{
+		{`{"foo": "bar"}`, `[{"op": "add", "path": "/baz", "value": "qux"}]`, `{"baz": "qux", "foo": "bar"}`},
+		{`{"foo": ["bar", "baz"]}`, `[{"op": "add", "path": "/foo/1", "value": "qux"}]`, `{"foo": ["bar", "qux", "baz"]}`},
+		{`{"foo": ["bar"]}`, `[{"op": "add", "path": "/foo/-", "value": ["abc"]}]`, `{"foo": ["bar", ["abc"]]}`},
+		{`{"baz": "qux", "foo": "bar"}`, `[{"op": "remove", "path": "/baz"}]`, `{"foo": "bar"}`},
+		{`{"foo": ["bar", "qux", "baz"]}`, `[{"op": "remove", "path": "/foo/1"}]`, `{"foo": ["bar", "baz"]}`},
+		{`{"baz": "qux", "foo": "bar"}`, `[{"op": "replace", "path": "/baz", "value": "boo"}]`, `{"baz": "boo", "foo": "bar"}`},
+		{`{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`, `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`, `{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`},
+		{`{"foo": ["all", "grass", "cows", "eat"]}`, `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`, `{"foo": ["all", "cows", "eat", "grass"]}`},
+		{`{"a/b": {"m~n": 1}}`, `[{"op": "copy", "from": "/a~1b/m~0n", "path": "/c"}, {"op": "test", "path": "/c", "value": 1.0}]`, `{"a/b": {"m~n": 1}, "c": 1}`},
+		{`{"foo": 1}`, `[{"op": "replace", "path": "", "value": [null, {}]}]`, `[null, {}]`},
+	} {
+		document, err := decodePatch(t, testCase.patch).Apply(decode(t, testCase.document))
+		require.NoError(t, err, testCase.patch)
+		requireDocument(t, testCase.expected, document)
+	}
+}
+
+func TestPatchApplyError(t *testing.T) {
+	t.Parallel()
+	document := decode(t, `{"foo": ["bar"], "baz": "qux"}`)
+	for _, patch := range []string{
+		`[{"op": "remove", "path": "/missing"}]`,
+		`[{"op": "add", "path": "/foo/2", "value": 1}]`,
+		`[{"op": "add", "path": "/foo/01", "value": 1}]`,
+		`[{"op": "replace", "path": "/baz/0", "value": 1}]`,
+		`[{"op": "move", "from": "/foo", "path": "/foo/0"}]`,
+		`[{"op": "remove", "path": "/baz"}, {"op": "test", "path": "/foo/0", "value": "baz"}]`,
+	} {
+		_, err := decodePatch(t, patch).Apply(document)
+		require.Error(t, err, patch)
+	}
+	// failed patches leave the document unchanged
+	requireDocument(t, `{"foo": ["bar"], "baz": "qux"}`, document)
+
+	var patch badjson.Patch
+	require.Error(t, json.Unmarshal([]byte(`[{"op": "add", "path": "/a"}]`), &patch))
+	require.Error(t, json.Unmarshal([]byte(`[{"op": "unknown", "path": "/a"}]`), &patch))
+}
+
+func TestPatchKeepsKeyOrder(t *testing.T) {
+	t.Parallel()
+	document, err := decodePatch(t, `[{"op": "replace", "path": "/b", "value": 3}, {"op": "add", "path": "/a", "value": 4}]`).Apply(decode(t, `{"c": 1, "b": 2, "a": 0}`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "b", "a"}, document.(*badjson.JSONObject).Keys())
+}
+
+func TestMergePatch(t *testing.T) {
+	t.Parallel()
+	document := badjson.MergePatch(decode(t, `{
+  "title": "Goodbye!",
+  "author": {"givenName": "John", "familyName": "Doe"},
+  "tags": ["example", "sample"],
+  "content": "This will be unchanged"
+}`), decode(t, `{
+  "title": "Hello!",
+  "phoneNumber": "+01-123-456-7890",
+  "author": {"familyName": null},
+  "tags": ["example"]
+}`))
+	requireDocument(t, `{
+  "title": "Hello!",
+  "author": {"givenName": "John"},
+  "tags": ["example"],
+  "content": "This will be unchanged",
+  "phoneNumber": "+01-123-456-7890"
+}`, document)
+	requireDocument(t, `{"a": {"b": "c"}}`, badjson.MergePatch(decode(t, `["a"]`), decode(t, `{"a": {"b": "c", "d": null}}`)))
+	requireDocument(t, `["c"]`, badjson.MergePatch(decode(t, `{"a": "b"}`), decode(t, `["c"]`)))
+}
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range []struct {
+		source      string
+		destination string
+		patch       string
+	}{
+		{`{"a": 1, "b": 2}`, `{"a": 1, "b": 2}`, `null`},
+		{`{"a": 1, "b": 2}`, `{"b": 3, "c": 4}`, `[{"op": "remove", "path": "/a"}, {"op": "replace", "path": "/b", "value": 3}, {"op": "add", "path": "/c", "value": 4}]`},
+		{`{"list": [1, 2, 3, 4]}`, `{"list": [1, 3, 4, 5]}`, `[{"op": "remove", "path": "/list/1"}, {"op": "add", "path": "/list/3", "value": 5}]`},
+		{`{"list": [{"tag": "a", "port": 1}, {"tag": "b"}]}`, `{"list": [{"tag": "a", "port": 2}, {"tag": "b"}]}`, `[{"op": "replace", "path": "/list/0/port", "value": 2}]`},
+		{`{"a/b": {"x": 1}}`, `{"a/b": {"x": 1.0, "y": []}}`, `[{"op": "add", "path": "/a~1b/y", "value": []}]`},
+		{`[]`, `{}`, `[{"op": "replace", "path": "", "value": {}}]`},
+	} {
+		source := decode(t, testCase.source)
+		destination := decode(t, testCase.destination)
+		patch := badjson.Diff(source, destination)
+		content, err := json.Marshal(patch)
+		require.NoError(t, err)
+		require.JSONEq(t, testCase.patch, string(content))
+		patched, err := patch.Apply(source)
+		require.NoError(t, err)
+		requireDocument(t, testCase.destination, patched)
+	}
+	source := decode(t, `[1, "a", [2, 3], {"b": 4}, 5, 6, "c"]`)
+	destination := decode(t, `[[2, 4], 1, {"b": 5}, 6, "c", "d", 7]`)
+	patched, err := badjson.Diff(source, destination).Apply(source)
+	require.NoError(t, err)
+	requireDocument(t, `[[2, 4], 1, {"b": 5}, 6, "c", "d", 7]`, patched)
+}
+
+type testPatchOptions struct {
+	Tag     string   `json:"tag,omitempty"`
+	Port    uint64   `json:"port,omitempty"`
+	Servers []string `json:"servers,omitempty"`
+}
+
+func TestPatchTypedValue(t *testing.T) {
+	t.Parallel()
+	source := testPatchOptions{Tag: "a", Port: 1 << 60, Servers: []string{"1.1.1.1"}}
+	options, err := badjson.ApplyPatch(source, decodePatch(t, `[{"op": "add", "path": "/servers/-", "value": "8.8.8.8"}, {"op": "remove", "path": "/tag"}]`))
+	require.NoError(t, err)
+	require.Equal(t, testPatchOptions{Port: 1 << 60, Servers: []string{"1.1.1.1", "8.8.8.8"}}, options)
+
+	options, err = badjson.ApplyMergePatch(source, json.RawMessage(`{"tag": null, "servers": ["9.9.9.9"]}`))
+	require.NoError(t, err)
+	require.Equal(t, testPatchOptions{Port: 1 << 60, Servers: []string{"9.9.9.9"}}, options)
+
+	patch, err := badjson.DiffValues(source, options)
+	require.NoError(t, err)
+	content, err := json.Marshal(patch)
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"op": "remove", "path": "/tag"}, {"op": "replace", "path": "/servers/0", "value": "9.9.9.9"}]`, string(content))
+}
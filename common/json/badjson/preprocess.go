@@ -0,0 +1,570 @@
+package badjson
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/service/filemanager"
+)
+
+// Preprocessor directives, as object keys.
+const (
+	// DirectiveInclude replaces the object with the merged content of the files it names,
+	// with the other keys of the object merged on top. Included arrays are spliced into arrays.
+	DirectiveInclude = "$include"
+	// DirectiveAnchor names the object for use by DirectiveRef. Names must be unique,
+	// except that anchors of a file included more than once are defined once.
+	DirectiveAnchor = "$anchor"
+	// DirectiveRef replaces the object with a copy of the named anchor,
+	// with the other keys of the object merged on top.
+	DirectiveRef = "$ref"
+	// DirectiveMerge sets the merge strategy of keys merged on top of included or referenced content,
+	// as a map from key to MergeStrategyMerge, MergeStrategyAppend or MergeStrategyReplace.
+	DirectiveMerge = "$merge"
+)
+
+const (
+	// MergeStrategyMerge merges objects by key and appends arrays. It is the default.
+	MergeStrategyMerge = "merge"
+	// MergeStrategyAppend appends arrays, and fails for other values.
+	MergeStrategyAppend = "append"
+	// MergeStrategyReplace replaces the value.
+	MergeStrategyReplace = "replace"
+)
+
+type PreprocessOptions struct {
+	// LookupEnv resolves ${env:NAME} substitutions, os.LookupEnv by default.
+	LookupEnv func(name string) (string, bool)
+}
+
+// PreprocessFile loads a JSON config file and resolves its directives:
+// "$include" of other files, "${env:NAME}" and "${file:path}" substitutions in strings ("$${" escapes "${",
+// and a ":json" suffix substitutes a JSON scalar for the whole string, as in "${env:PORT:json}"),
+// "$anchor" and "$ref", and "$merge" strategies. Files are opened by the filemanager in ctx,
+// and relative paths are resolved from the directory of the file that contains them.
+func PreprocessFile(ctx context.Context, path string, options PreprocessOptions) (json.RawMessage, error) {
+	p := newPreprocessor(ctx, options)
+	value, err := p.include("", path, "")
+	if err != nil {
+		return nil, err
+	}
+	return p.finish(value)
+}
+
+// Preprocess resolves directives in content like PreprocessFile, with relative paths resolved by the filemanager in ctx.
+func Preprocess(ctx context.Context, content []byte, options PreprocessOptions) (json.RawMessage, error) {
+	p := newPreprocessor(ctx, options)
+	value, err := decodeConfig(content)
+	if err != nil {
+		return nil, err
+	}
+	value, err = p.expand(value, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return p.finish(value)
+}
+
+type preprocessor struct {
+	ctx       context.Context
+	lookupEnv func(name string) (string, bool)
+	includes  []string
+	anchors   map[string]*preprocessAnchor
+	resolving []string
+}
+
+type preprocessAnchor struct {
+	value    any
+	file     string
+	path     string
+	resolved bool
+}
+
+func newPreprocessor(ctx context.Context, options PreprocessOptions) *preprocessor {
+	lookupEnv := options.LookupEnv
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+	return &preprocessor{
+		ctx:       ctx,
+		lookupEnv: lookupEnv,
+		anchors:   make(map[string]*preprocessAnchor),
+	}
+}
+
+func (p *preprocessor) finish(value any) (json.RawMessage, error) {
+	value, err := p.resolve(value, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return Encode(value)
+}
+
+func decodeConfig(content []byte) (any, error) {
+	decoder := json.NewDecoder(json.NewCommentFilter(bytes.NewReader(content)))
+	decoder.UseNumber()
+	return decodeJSON(decoder)
+}
+
+func newPreprocessError(file string, path string, err error) error {
+	location := preprocessLocation(file, path)
+	if location == "" {
+		return err
+	}
+	return E.Cause(err, location)
+}
+
+func preprocessLocation(file string, path string) string {
+	if file == "" || path == "" {
+		return file + path
+	}
+	return file + ": " + path
+}
+
+func joinKey(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func joinIndex(path string, index int) string {
+	return path + "[" + strconv.Itoa(index) + "]"
+}
+
+// filePath returns the absolute path of name, relative to the directory of file.
+func (p *preprocessor) filePath(file string, name string) (string, error) {
+	if !filepath.IsAbs(name) {
+		if file != "" {
+			name = filepath.Join(filepath.Dir(file), name)
+		} else {
+			name = filemanager.BasePath(p.ctx, name)
+		}
+	}
+	return filepath.Abs(name)
+}
+
+func (p *preprocessor) readFile(file string, name string) ([]byte, string, error) {
+	path, err := p.filePath(file, name)
+	if err != nil {
+		return nil, "", err
+	}
+	reader, err := filemanager.OpenFile(p.ctx, path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, "", err
+	}
+	return content, path, nil
+}
+
+func (p *preprocessor) include(file string, name string, path string) (any, error) {
+	includePath, err := p.filePath(file, name)
+	if err != nil {
+		return nil, newPreprocessError(file, path, err)
+	}
+	for i, included := range p.includes {
+		if included == includePath {
+			return nil, newPreprocessError(file, path, E.New("include cycle: ", strings.Join(append(p.includes[i:], includePath), " -> ")))
+		}
+	}
+	content, includePath, err := p.readFile(file, name)
+	if err != nil {
+		return nil, newPreprocessError(file, path, E.Cause(err, "include ", name))
+	}
+	value, err := decodeConfig(content)
+	if err != nil {
+		return nil, newPreprocessError(includePath, "", err)
+	}
+	p.includes = append(p.includes, includePath)
+	defer func() {
+		p.includes = p.includes[:len(p.includes)-1]
+	}()
+	return p.expand(value, includePath, "")
+}
+
+// expand resolves includes and substitutions, and registers anchors.
+func (p *preprocessor) expand(value any, file string, path string) (any, error) {
+	switch typedValue := value.(type) {
+	case *JSONObject:
+		return p.expandObject(typedValue, file, path)
+	case JSONArray:
+		var array JSONArray
+		for i, item := range typedValue {
+			itemPath := joinIndex(path, i)
+			expanded, err := p.expand(item, file, itemPath)
+			if err != nil {
+				return nil, err
+			}
+			// {"$include": "list.json"} in an array is replaced by the items of the included array
+			if object, isObject := item.(*JSONObject); isObject && object.Size() == 1 && object.ContainsKey(DirectiveInclude) {
+				if includedArray, isArray := expanded.(JSONArray); isArray {
+					array = append(array, includedArray...)
+					continue
+				}
+			}
+			array = append(array, expanded)
+		}
+		if array == nil {
+			array = JSONArray{}
+		}
+		return array, nil
+	case string:
+		substituted, err := p.substitute(typedValue, file)
+		if err != nil {
+			return nil, newPreprocessError(file, path, err)
+		}
+		return substituted, nil
+	default:
+		return value, nil
+	}
+}
+
+func (p *preprocessor) expandObject(object *JSONObject, file string, path string) (any, error) {
+	var (
+		includes []string
+		anchor   string
+		local    JSONObject
+	)
+	for _, entry := range object.Entries() {
+		switch entry.Key {
+		case DirectiveInclude:
+			expanded, err := p.expand(entry.Value, file, joinKey(path, entry.Key))
+			if err != nil {
+				return nil, err
+			}
+			switch includeValue := expanded.(type) {
+			case string:
+				includes = append(includes, includeValue)
+			case JSONArray:
+				for i, item := range includeValue {
+					name, isString := item.(string)
+					if !isString {
+						return nil, newPreprocessError(file, joinIndex(joinKey(path, entry.Key), i), E.New("expected file name"))
+					}
+					includes = append(includes, name)
+				}
+			default:
+				return nil, newPreprocessError(file, joinKey(path, entry.Key), E.New("expected file name or list of file names"))
+			}
+		case DirectiveAnchor:
+			name, isString := entry.Value.(string)
+			if !isString || name == "" {
+				return nil, newPreprocessError(file, joinKey(path, entry.Key), E.New("expected anchor name"))
+			}
+			anchor = name
+		case DirectiveRef, DirectiveMerge:
+			local.Put(entry.Key, entry.Value)
+		default:
+			expanded, err := p.expand(entry.Value, file, joinKey(path, entry.Key))
+			if err != nil {
+				return nil, err
+			}
+			local.Put(entry.Key, expanded)
+		}
+	}
+	var result any = &local
+	if len(includes) > 0 {
+		strategies, err := mergeStrategies(&local, file, path)
+		if err != nil {
+			return nil, err
+		}
+		var merged any
+		for _, name := range includes {
+			included, err := p.include(file, name, joinKey(path, DirectiveInclude))
+			if err != nil {
+				return nil, err
+			}
+			if merged == nil {
+				merged = included
+				continue
+			}
+			if preprocessKind(included) != preprocessKind(merged) {
+				return nil, newPreprocessError(file, joinKey(path, DirectiveInclude), E.New("cannot merge included ", preprocessKind(included), " into included ", preprocessKind(merged)))
+			}
+			merged, err = mergePreprocessed(merged, included, nil)
+			if err != nil {
+				return nil, newPreprocessError(file, joinKey(path, DirectiveInclude), err)
+			}
+		}
+		if !local.ContainsKey(DirectiveRef) {
+			local.Remove(DirectiveMerge)
+		}
+		if local.Size() > 0 {
+			if _, isObject := merged.(*JSONObject); !isObject {
+				return nil, newPreprocessError(file, path, E.New("cannot merge object into included ", preprocessKind(merged)))
+			}
+			merged, err = mergePreprocessed(merged, &local, strategies)
+			if err != nil {
+				return nil, newPreprocessError(file, path, err)
+			}
+		}
+		result = merged
+	} else if !local.ContainsKey(DirectiveRef) && local.ContainsKey(DirectiveMerge) {
+		return nil, newPreprocessError(file, joinKey(path, DirectiveMerge), E.New("merge strategies require ", DirectiveInclude, " or ", DirectiveRef))
+	}
+	if anchor != "" {
+		if existing, loaded := p.anchors[anchor]; loaded && existing.file == file && existing.path == path {
+			// the file is included more than once
+			return result, nil
+		} else if loaded {
+			return nil, newPreprocessError(file, joinKey(path, DirectiveAnchor), E.New("anchor ", strconv.Quote(anchor), " is already defined at ", preprocessLocation(existing.file, existing.path)))
+		}
+		p.anchors[anchor] = &preprocessAnchor{value: copyJSON(result), file: file, path: path}
+	}
+	return result, nil
+}
+
+func preprocessKind(value any) string {
+	switch value.(type) {
+	case *JSONObject:
+		return "object"
+	case JSONArray:
+		return "array"
+	default:
+		return "value"
+	}
+}
+
+func mergeStrategies(object *JSONObject, file string, path string) (map[string]string, error) {
+	rawStrategies, loaded := object.Get(DirectiveMerge)
+	if !loaded {
+		return nil, nil
+	}
+	strategiesObject, isObject := rawStrategies.(*JSONObject)
+	if !isObject {
+		return nil, newPreprocessError(file, joinKey(path, DirectiveMerge), E.New("expected object of merge strategies"))
+	}
+	strategies := make(map[string]string)
+	for _, entry := range strategiesObject.Entries() {
+		strategy, isString := entry.Value.(string)
+		if !isString {
+			return nil, newPreprocessError(file, joinKey(joinKey(path, DirectiveMerge), entry.Key), E.New("expected merge strategy name"))
+		}
+		switch strategy {
+		case MergeStrategyMerge, MergeStrategyAppend, MergeStrategyReplace:
+			strategies[entry.Key] = strategy
+		default:
+			return nil, newPreprocessError(file, joinKey(joinKey(path, DirectiveMerge), entry.Key), E.New("unknown merge strategy ", strconv.Quote(strategy)))
+		}
+	}
+	return strategies, nil
+}
+
+// resolve replaces references with copies of their anchors.
+func (p *preprocessor) resolve(value any, file string, path string) (any, error) {
+	switch typedValue := value.(type) {
+	case *JSONObject:
+		var object JSONObject
+		for _, entry := range typedValue.Entries() {
+			if entry.Key == DirectiveRef || entry.Key == DirectiveMerge {
+				continue
+			}
+			resolved, err := p.resolve(entry.Value, file, joinKey(path, entry.Key))
+			if err != nil {
+				return nil, err
+			}
+			object.Put(entry.Key, resolved)
+		}
+		rawRef, loaded := typedValue.Get(DirectiveRef)
+		if !loaded {
+			return &object, nil
+		}
+		name, isString := rawRef.(string)
+		if !isString {
+			return nil, newPreprocessError(file, joinKey(path, DirectiveRef), E.New("expected anchor name"))
+		}
+		anchored, err := p.resolveAnchor(name, file, joinKey(path, DirectiveRef))
+		if err != nil {
+			return nil, err
+		}
+		if object.Size() == 0 {
+			return anchored, nil
+		}
+		strategies, err := mergeStrategies(typedValue, file, path)
+		if err != nil {
+			return nil, err
+		}
+		merged, err := mergePreprocessed(anchored, &object, strategies)
+		if err != nil {
+			return nil, newPreprocessError(file, path, err)
+		}
+		return merged, nil
+	case JSONArray:
+		array := make(JSONArray, 0, len(typedValue))
+		for i, item := range typedValue {
+			resolved, err := p.resolve(item, file, joinIndex(path, i))
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, resolved)
+		}
+		return array, nil
+	default:
+		return value, nil
+	}
+}
+
+func (p *preprocessor) resolveAnchor(name string, file string, path string) (any, error) {
+	anchor, loaded := p.anchors[name]
+	if !loaded {
+		return nil, newPreprocessError(file, path, E.New("undefined anchor ", strconv.Quote(name)))
+	}
+	if !anchor.resolved {
+		for i, resolving := range p.resolving {
+			if resolving == name {
+				return nil, newPreprocessError(file, path, E.New("anchor cycle: ", strings.Join(append(p.resolving[i:], name), " -> ")))
+			}
+		}
+		p.resolving = append(p.resolving, name)
+		resolved, err := p.resolve(anchor.value, anchor.file, anchor.path)
+		p.resolving = p.resolving[:len(p.resolving)-1]
+		if err != nil {
+			return nil, err
+		}
+		anchor.value = resolved
+		anchor.resolved = true
+	}
+	return copyJSON(anchor.value), nil
+}
+
+// substitute replaces ${env:NAME} and ${file:path} in value. A value that consists of a single substitution
+// with the ":json" suffix, such as "${env:PORT:json}", is replaced by the JSON number, boolean, null or string it contains.
+func (p *preprocessor) substitute(value string, file string) (any, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+	if strings.HasPrefix(value, "${") && strings.IndexByte(value, '}') == len(value)-1 {
+		if expression, isJSON := strings.CutSuffix(value[2:len(value)-1], ":json"); isJSON {
+			content, err := p.substitution(expression, file)
+			if err != nil {
+				return nil, err
+			}
+			scalar, err := decodeConfig([]byte(content))
+			if err != nil {
+				return nil, E.Cause(err, "decode ", value)
+			}
+			switch scalar.(type) {
+			case *JSONObject, JSONArray:
+				return nil, E.New(value, " is not a JSON scalar")
+			}
+			return scalar, nil
+		}
+	}
+	var builder strings.Builder
+	for {
+		index := strings.Index(value, "${")
+		if index == -1 {
+			builder.WriteString(value)
+			return builder.String(), nil
+		}
+		if index > 0 && value[index-1] == '$' {
+			builder.WriteString(value[:index-1])
+			builder.WriteString("${")
+			value = value[index+2:]
+			continue
+		}
+		builder.WriteString(value[:index])
+		end := strings.IndexByte(value[index:], '}')
+		if end == -1 {
+			return nil, E.New("unterminated substitution in ", strconv.Quote(value))
+		}
+		expression := value[index+2 : index+end]
+		value = value[index+end+1:]
+		if strings.HasSuffix(expression, ":json") {
+			return nil, E.New("${", expression, "} must be the whole string")
+		}
+		content, err := p.substitution(expression, file)
+		if err != nil {
+			return nil, err
+		}
+		builder.WriteString(content)
+	}
+}
+
+func (p *preprocessor) substitution(expression string, file string) (string, error) {
+	kind, argument, _ := strings.Cut(expression, ":")
+	switch kind {
+	case "env":
+		envValue, loaded := p.lookupEnv(argument)
+		if !loaded {
+			return "", E.New("environment variable ", argument, " is not set")
+		}
+		return envValue, nil
+	case "file":
+		content, _, err := p.readFile(file, argument)
+		if err != nil {
+			return "", E.Cause(err, "read ", argument)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	default:
+		return "", E.New("unknown substitution ${", expression, "}")
+	}
+}
+
+// mergePreprocessed merges override on top of base, with strategies for the keys of override.
+func mergePreprocessed(base any, override any, strategies map[string]string) (any, error) {
+	baseObject, isBaseObject := base.(*JSONObject)
+	overrideObject, isOverrideObject := override.(*JSONObject)
+	if !isBaseObject || !isOverrideObject {
+		return mergeJSON(override, base, false, true)
+	}
+	for _, entry := range overrideObject.Entries() {
+		oldValue, loaded := baseObject.Get(entry.Key)
+		if !loaded || entry.Key == DirectiveRef || entry.Key == DirectiveMerge {
+			baseObject.Put(entry.Key, entry.Value)
+			continue
+		}
+		var (
+			merged any
+			err    error
+		)
+		switch strategies[entry.Key] {
+		case MergeStrategyReplace:
+			merged = entry.Value
+		case MergeStrategyAppend:
+			baseArray, isBaseArray := oldValue.(JSONArray)
+			overrideArray, isOverrideArray := entry.Value.(JSONArray)
+			if !isBaseArray || !isOverrideArray {
+				return nil, E.Cause(E.New("append requires arrays"), entry.Key)
+			}
+			merged = append(baseArray, overrideArray...)
+		default:
+			merged, err = mergeJSON(entry.Value, oldValue, false, true)
+			if err != nil {
+				return nil, E.Cause(err, entry.Key)
+			}
+		}
+		baseObject.Put(entry.Key, merged)
+	}
+	return baseObject, nil
+}
+
+func copyJSON(value any) any {
+	switch typedValue := value.(type) {
+	case *JSONObject:
+		var object JSONObject
+		for _, entry := range typedValue.Entries() {
+			object.Put(entry.Key, copyJSON(entry.Value))
+		}
+		return &object
+	case JSONArray:
+		array := make(JSONArray, 0, len(typedValue))
+		for _, item := range typedValue {
+			array = append(array, copyJSON(item))
+		}
+		return array
+	default:
+		return value
+	}
+}
@@ -0,0 +1,158 @@
+package badjson_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sagernet/sing/common/json/badjson"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	directory := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(directory, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return directory
+}
+
+func lookupEnv(name string) (string, bool) {
+	if name == "TEST_PORT" {
+		return "8080", true
+	}
+	return "", false
+}
+
+func TestPreprocessFile(t *testing.T) {
+	t.Parallel()
+	directory := writeFiles(t, map[string]string{
+		"config.json": `{
+  // base config with overrides
+  "$include": ["base.json", "dns/dns.json"],
+  "$merge": {"route": "replace", "inbounds": "append"},
+  "log": {"level": "debug"},
+  "route": {"final": "direct"},
+  "inbounds": [
+    {"$ref": "mixed", "listen_port": "${env:TEST_PORT:json}", "tag": "mixed-${env:TEST_PORT}"},
+    {"type": "tun", "password": "${file:secret.txt}", "literal": "$${env:TEST_PORT}"}
+  ],
+  "outbounds": [
+    {"$include": "outbounds.json"},
+    {"type": "block", "tag": "block"}
+  ]
+}`,
+		"base.json": `{
+  "log": {"level": "info", "timestamp": true},
+  "route": {"rules": [{"outbound": "direct"}]},
+  "inbounds": [{"$anchor": "mixed", "type": "mixed", "listen": "::"}]
+}`,
+		"dns/dns.json":   `{"dns": {"servers": [{"address": "${file:../server.txt}"}]}}`,
+		"server.txt":     "1.1.1.1\n",
+		"secret.txt":     "password\n",
+		"outbounds.json": `[{"type": "direct", "tag": "direct"}]`,
+	})
+	content, err := badjson.PreprocessFile(context.Background(), filepath.Join(directory, "config.json"), badjson.PreprocessOptions{LookupEnv: lookupEnv})
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+  "log": {"level": "debug", "timestamp": true},
+  "route": {"final": "direct"},
+  "inbounds": [
+    {"type": "mixed", "listen": "::"},
+    {"type": "mixed", "listen": "::", "listen_port": 8080, "tag": "mixed-8080"},
+    {"type": "tun", "password": "password", "literal": "${env:TEST_PORT}"}
+  ],
+  "dns": {"servers": [{"address": "1.1.1.1"}]},
+  "outbounds": [
+    {"type": "direct", "tag": "direct"},
+    {"type": "block", "tag": "block"}
+  ]
+}`, string(content))
+}
+
+func TestPreprocessRef(t *testing.T) {
+	t.Parallel()
+	content, err := badjson.Preprocess(context.Background(), []byte(`{
+  "outbounds": [
+    {"$ref": "tls-server", "tag": "a"},
+    {"$ref": "tls-server", "tag": "b", "tls": {"server_name": "b.example"}}
+  ],
+  "templates": [
+    {"$anchor": "tls-server", "type": "vless", "tls": {"$ref": "tls", "enabled": true}},
+    {"$anchor": "tls", "server_name": "example.com", "alpn": ["h2"]}
+  ]
+}`), badjson.PreprocessOptions{})
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+  "outbounds": [
+    {"type": "vless", "tls": {"server_name": "example.com", "alpn": ["h2"], "enabled": true}, "tag": "a"},
+    {"type": "vless", "tls": {"server_name": "b.example", "alpn": ["h2"], "enabled": true}, "tag": "b"}
+  ],
+  "templates": [
+    {"type": "vless", "tls": {"server_name": "example.com", "alpn": ["h2"], "enabled": true}},
+    {"server_name": "example.com", "alpn": ["h2"]}
+  ]
+}`, string(content))
+}
+
+func TestPreprocessEmptyValues(t *testing.T) {
+	t.Parallel()
+	content, err := badjson.Preprocess(context.Background(), []byte(`{"dns": {}, "rules": [], "tag": "", "inbounds": [{"$anchor": "a", "users": []}, {"$ref": "a"}]}`), badjson.PreprocessOptions{})
+	require.NoError(t, err)
+	require.Equal(t, `{"dns":{},"rules":[],"tag":"","inbounds":[{"users":[]},{"users":[]}]}`, string(content))
+}
+
+func TestPreprocessDiamond(t *testing.T) {
+	t.Parallel()
+	directory := writeFiles(t, map[string]string{
+		"config.json": `{"$include": ["dns.json", "route.json"], "outbounds": [{"$ref": "direct"}]}`,
+		"dns.json":    `{"dns": {"$include": "common.json"}}`,
+		"route.json":  `{"route": {"$include": "common.json"}}`,
+		"common.json": `{"$anchor": "direct", "type": "direct"}`,
+	})
+	content, err := badjson.PreprocessFile(context.Background(), filepath.Join(directory, "config.json"), badjson.PreprocessOptions{})
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+  "dns": {"type": "direct"},
+  "route": {"type": "direct"},
+  "outbounds": [{"type": "direct"}]
+}`, string(content))
+}
+
+func TestPreprocessError(t *testing.T) {
+	t.Parallel()
+	directory := writeFiles(t, map[string]string{
+		"a.json":        `{"outbounds": [{"$include": "b.json"}]}`,
+		"b.json":        `[{"dns": {"$include": "a.json"}}]`,
+		"ref.json":      `{"a": {"$anchor": "a", "b": {"$ref": "b"}}, "b": {"$anchor": "b", "a": {"$ref": "a"}}}`,
+		"env.json":      `{"inbounds": [{"listen": "${env:UNDEFINED}"}]}`,
+		"merge.json":    `{"$include": "list.json", "$merge": {"a": "append"}, "a": 1}`,
+		"list.json":     `{"a": [1]}`,
+		"strategy.json": `{"$include": "list.json", "$merge": {"a": 1}}`,
+		"unknown.json":  `{"$include": "list.json", "$merge": {"a": "prepend"}}`,
+		"partial.json":  `{"listen_port": "port ${env:TEST_PORT:json}"}`,
+		"scalar.json":   `{"a": "${file:list.json:json}"}`,
+		"items.json":    `[1]`,
+		"array.json":    `{"$include": "items.json", "x": 1}`,
+		"mixed.json":    `{"$include": ["list.json", "items.json"]}`,
+	})
+	for name, message := range map[string]string{
+		"a.json":        "include cycle: " + filepath.Join(directory, "a.json") + " -> " + filepath.Join(directory, "b.json") + " -> " + filepath.Join(directory, "a.json"),
+		"ref.json":      "a.b.$ref: anchor cycle: b -> a -> b",
+		"env.json":      filepath.Join(directory, "env.json") + ": inbounds[0].listen: environment variable UNDEFINED is not set",
+		"merge.json":    "a: append requires arrays",
+		"strategy.json": "$merge.a: expected merge strategy name",
+		"unknown.json":  "$merge.a: unknown merge strategy \"prepend\"",
+		"partial.json":  "listen_port: ${env:TEST_PORT:json} must be the whole string",
+		"scalar.json":   "a: ${file:list.json:json} is not a JSON scalar",
+		"array.json":    "cannot merge object into included array",
+		"mixed.json":    "$include: cannot merge included array into included object",
+	} {
+		_, err := badjson.PreprocessFile(context.Background(), filepath.Join(directory, name), badjson.PreprocessOptions{LookupEnv: lookupEnv})
+		require.ErrorContains(t, err, message, name)
+	}
+}
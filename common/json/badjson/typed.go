@@ -2,10 +2,12 @@ package badjson
 
 import (
 	"bytes"
+	"reflect"
 	"strings"
 
 	E "github.com/sagernet/sing/common/exceptions"
 	"github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/common/json/jsonschema"
 	"github.com/sagernet/sing/common/x/linkedhashmap"
 )
 
@@ -84,3 +86,7 @@ func (m *TypedMap[K, V]) decodeJSON(decoder *json.Decoder) error {
 	}
 	return nil
 }
+
+func (m TypedMap[K, V]) JSONSchema(generator *jsonschema.Generator) *jsonschema.Schema {
+	return generator.SchemaOf(reflect.TypeOf(map[K]V(nil)))
+}
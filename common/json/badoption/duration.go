@@ -5,6 +5,7 @@ import (
 
 	"github.com/sagernet/sing/common/json"
 	"github.com/sagernet/sing/common/json/badoption/internal/my_time"
+	"github.com/sagernet/sing/common/json/jsonschema"
 )
 
 type Duration time.Duration
@@ -30,3 +31,10 @@ func (d *Duration) UnmarshalJSON(bytes []byte) error {
 	*d = Duration(duration)
 	return nil
 }
+
+func (d Duration) JSONSchema(generator *jsonschema.Generator) *jsonschema.Schema {
+	return &jsonschema.Schema{
+		Type:    "string",
+		Pattern: `^[-+]?(0|(([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|μs|ms|s|m|h|d))+)$`,
+	}
+}
@@ -1,6 +1,11 @@
 package badoption
 
-import "net/http"
+import (
+	"net/http"
+	"reflect"
+
+	"github.com/sagernet/sing/common/json/jsonschema"
+)
 
 type HTTPHeader map[string]Listable[string]
 
@@ -13,3 +18,10 @@ func (h HTTPHeader) Build() http.Header {
 	}
 	return header
 }
+
+func (h HTTPHeader) JSONSchema(generator *jsonschema.Generator) *jsonschema.Schema {
+	return &jsonschema.Schema{
+		Type:                 "object",
+		AdditionalProperties: generator.SchemaOf(reflect.TypeOf(Listable[string]{})),
+	}
+}
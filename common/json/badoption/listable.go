@@ -1,8 +1,11 @@
 package badoption
 
 import (
+	"reflect"
+
 	E "github.com/sagernet/sing/common/exceptions"
 	"github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/common/json/jsonschema"
 )
 
 type Listable[T any] []T
@@ -28,3 +31,13 @@ func (l *Listable[T]) UnmarshalJSON(content []byte) error {
 	*l = []T{singleItem}
 	return nil
 }
+
+func (l Listable[T]) JSONSchema(generator *jsonschema.Generator) *jsonschema.Schema {
+	itemSchema := generator.SchemaOf(reflect.TypeOf((*T)(nil)).Elem())
+	return &jsonschema.Schema{
+		AnyOf: []*jsonschema.Schema{
+			itemSchema,
+			{Type: "array", Items: itemSchema},
+		},
+	}
+}
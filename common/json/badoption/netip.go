@@ -4,6 +4,7 @@ import (
 	"net/netip"
 
 	"github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/common/json/jsonschema"
 )
 
 type Addr netip.Addr
@@ -82,3 +83,27 @@ func (p *Prefixable) UnmarshalJSON(content []byte) error {
 	}
 	return prefixErr
 }
+
+func (a Addr) JSONSchema(generator *jsonschema.Generator) *jsonschema.Schema {
+	return &jsonschema.Schema{
+		Type: "string",
+		AnyOf: []*jsonschema.Schema{
+			{Format: "ipv4"},
+			{Format: "ipv6"},
+		},
+	}
+}
+
+func (p Prefix) JSONSchema(generator *jsonschema.Generator) *jsonschema.Schema {
+	return &jsonschema.Schema{
+		Type:    "string",
+		Pattern: `^[0-9A-Fa-f:.]+/[0-9]{1,3}$`,
+	}
+}
+
+func (p Prefixable) JSONSchema(generator *jsonschema.Generator) *jsonschema.Schema {
+	return &jsonschema.Schema{
+		Type:    "string",
+		Pattern: `^[0-9A-Fa-f:.]+(/[0-9]{1,3})?$`,
+	}
+}
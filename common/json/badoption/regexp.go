@@ -4,6 +4,7 @@ import (
 	"regexp"
 
 	"github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/common/json/jsonschema"
 )
 
 type Regexp regexp.Regexp
@@ -29,3 +30,7 @@ func (r *Regexp) UnmarshalJSON(content []byte) error {
 	*r = Regexp(*regex)
 	return nil
 }
+
+func (r Regexp) JSONSchema(generator *jsonschema.Generator) *jsonschema.Schema {
+	return &jsonschema.Schema{Type: "string", Format: "regex"}
+}
@@ -0,0 +1,560 @@
+package badtoml
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json"
+)
+
+var (
+	integerRegex  = regexp.MustCompile(`^[+-]?(0|[1-9](_?[0-9])*)$`)
+	floatRegex    = regexp.MustCompile(`^[+-]?(0|[1-9](_?[0-9])*)(\.[0-9](_?[0-9])*)?([eE][+-]?[0-9](_?[0-9])*)?$`)
+	dateTimeRegex = regexp.MustCompile(`^([0-9]{4}-[0-9]{2}-[0-9]{2}([Tt ][0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?([Zz]|[+-][0-9]{2}:[0-9]{2})?)?|[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?)$`)
+	dateRegex     = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`)
+)
+
+type parser struct {
+	content []byte
+	offset  int
+	root    *table
+	current *table
+}
+
+func (p *parser) newError(offset int, message ...any) error {
+	return json.NewDecodeError(E.New(message...), "", int64(offset), p.content)
+}
+
+func (p *parser) eof() bool {
+	return p.offset >= len(p.content)
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.content[p.offset]
+}
+
+func (p *parser) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(string(p.content[p.offset:]), prefix)
+}
+
+func (p *parser) parse() error {
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return nil
+		}
+		var err error
+		if p.peek() == '[' {
+			err = p.header()
+		} else {
+			err = p.keyValue(p.current)
+		}
+		if err != nil {
+			return err
+		}
+		err = p.endOfLine()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// skipBlank skips whitespace, newlines and comments.
+func (p *parser) skipBlank() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r', '\n':
+			p.offset++
+		case '#':
+			p.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) skipSpace() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.offset++
+	}
+}
+
+func (p *parser) skipComment() {
+	for !p.eof() && p.peek() != '\n' {
+		p.offset++
+	}
+}
+
+func (p *parser) endOfLine() error {
+	p.skipSpace()
+	if p.peek() == '#' {
+		p.skipComment()
+	}
+	if p.eof() {
+		return nil
+	}
+	if p.hasPrefix("\n") || p.hasPrefix("\r\n") {
+		return nil
+	}
+	return p.newError(p.offset, "expected newline after value, found ", p.describe())
+}
+
+func (p *parser) describe() string {
+	if p.eof() {
+		return "end of file"
+	}
+	r, _ := utf8.DecodeRune(p.content[p.offset:])
+	return strconv.QuoteRune(r)
+}
+
+func (p *parser) header() error {
+	start := p.offset
+	isArray := p.hasPrefix("[[")
+	if isArray {
+		p.offset += 2
+	} else {
+		p.offset++
+	}
+	p.skipSpace()
+	keys, offsets, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	if isArray {
+		if !p.hasPrefix("]]") {
+			return p.newError(p.offset, "expected ]] after array of tables name, found ", p.describe())
+		}
+		p.offset += 2
+	} else {
+		if p.peek() != ']' {
+			return p.newError(p.offset, "expected ] after table name, found ", p.describe())
+		}
+		p.offset++
+	}
+	parent := p.root
+	for i, key := range keys[:len(keys)-1] {
+		parent, err = p.descend(parent, key, offsets[i], false)
+		if err != nil {
+			return err
+		}
+	}
+	key, keyOffset := keys[len(keys)-1], offsets[len(keys)-1]
+	existing := parent.entries[key]
+	if isArray {
+		newTable := newTable(start)
+		newTable.defined = true
+		if existing == nil {
+			parent.put(key, keyOffset, &value{offset: start, array: []*value{{table: newTable}}, tableArray: true})
+		} else if existing.value.tableArray {
+			existing.value.array = append(existing.value.array, &value{table: newTable})
+		} else {
+			return p.newError(keyOffset, "key ", strconv.Quote(key), " is already defined and is not an array of tables")
+		}
+		p.current = newTable
+		return nil
+	}
+	if existing == nil {
+		newTable := newTable(start)
+		newTable.defined = true
+		parent.put(key, keyOffset, &value{table: newTable})
+		p.current = newTable
+		return nil
+	}
+	existingTable := existing.value.table
+	if existingTable == nil || existingTable.defined || existingTable.dotted || existingTable.inline {
+		return p.newError(keyOffset, "table ", strconv.Quote(strings.Join(keys, ".")), " is already defined")
+	}
+	existingTable.defined = true
+	p.current = existingTable
+	return nil
+}
+
+// descend returns the table at key in parent, creating it if it does not exist.
+func (p *parser) descend(parent *table, key string, keyOffset int, dotted bool) (*table, error) {
+	existing := parent.entries[key]
+	if existing == nil {
+		newTable := newTable(keyOffset)
+		newTable.dotted = dotted
+		parent.put(key, keyOffset, &value{table: newTable})
+		return newTable, nil
+	}
+	switch {
+	case existing.value.table != nil:
+		existingTable := existing.value.table
+		if existingTable.inline || dotted && existingTable.defined {
+			return nil, p.newError(keyOffset, "table ", strconv.Quote(key), " is already defined")
+		}
+		return existingTable, nil
+	case existing.value.tableArray && !dotted:
+		return existing.value.array[len(existing.value.array)-1].table, nil
+	default:
+		return nil, p.newError(keyOffset, "key ", strconv.Quote(key), " is already defined")
+	}
+}
+
+func (p *parser) keyValue(parent *table) error {
+	keys, offsets, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	if p.peek() != '=' {
+		return p.newError(p.offset, "expected = after key, found ", p.describe())
+	}
+	p.offset++
+	p.skipSpace()
+	for i, key := range keys[:len(keys)-1] {
+		parent, err = p.descend(parent, key, offsets[i], true)
+		if err != nil {
+			return err
+		}
+	}
+	key, keyOffset := keys[len(keys)-1], offsets[len(keys)-1]
+	if _, loaded := parent.entries[key]; loaded {
+		return p.newError(keyOffset, "key ", strconv.Quote(key), " is already defined")
+	}
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	parent.put(key, keyOffset, v)
+	return nil
+}
+
+// key parses a possibly dotted key.
+func (p *parser) key() ([]string, []int, error) {
+	var (
+		keys    []string
+		offsets []int
+	)
+	for {
+		start := p.offset
+		var key string
+		switch p.peek() {
+		case '"':
+			if p.hasPrefix(`"""`) {
+				return nil, nil, p.newError(start, "multi-line strings are not allowed in keys")
+			}
+			p.offset++
+			var err error
+			key, err = p.basicString(start)
+			if err != nil {
+				return nil, nil, err
+			}
+		case '\'':
+			if p.hasPrefix("'''") {
+				return nil, nil, p.newError(start, "multi-line strings are not allowed in keys")
+			}
+			p.offset++
+			var err error
+			key, err = p.literalString(start)
+			if err != nil {
+				return nil, nil, err
+			}
+		default:
+			for !p.eof() && isBareKey(p.peek()) {
+				p.offset++
+			}
+			if p.offset == start {
+				return nil, nil, p.newError(start, "expected key, found ", p.describe())
+			}
+			key = string(p.content[start:p.offset])
+		}
+		keys = append(keys, key)
+		offsets = append(offsets, start)
+		p.skipSpace()
+		if p.peek() != '.' {
+			return keys, offsets, nil
+		}
+		p.offset++
+		p.skipSpace()
+	}
+}
+
+func (p *parser) value() (*value, error) {
+	start := p.offset
+	var (
+		content string
+		err     error
+	)
+	switch {
+	case p.hasPrefix(`"""`):
+		p.offset += 3
+		content, err = p.multilineBasicString(start)
+	case p.hasPrefix("'''"):
+		p.offset += 3
+		content, err = p.multilineLiteralString(start)
+	case p.peek() == '"':
+		p.offset++
+		content, err = p.basicString(start)
+	case p.peek() == '\'':
+		p.offset++
+		content, err = p.literalString(start)
+	case p.peek() == '[':
+		return p.array()
+	case p.peek() == '{':
+		return p.inlineTable()
+	default:
+		return p.scalar()
+	}
+	if err != nil {
+		return nil, err
+	}
+	scalar, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return &value{offset: start, scalar: scalar}, nil
+}
+
+func (p *parser) array() (*value, error) {
+	v := &value{offset: p.offset, array: []*value{}}
+	p.offset++
+	for {
+		p.skipBlank()
+		if p.peek() == ']' {
+			p.offset++
+			return v, nil
+		}
+		item, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		v.array = append(v.array, item)
+		p.skipBlank()
+		switch p.peek() {
+		case ',':
+			p.offset++
+		case ']':
+			p.offset++
+			return v, nil
+		default:
+			return nil, p.newError(p.offset, "expected , or ] in array, found ", p.describe())
+		}
+	}
+}
+
+func (p *parser) inlineTable() (*value, error) {
+	inlineTable := newTable(p.offset)
+	inlineTable.inline = true
+	p.offset++
+	p.skipSpace()
+	if p.peek() == '}' {
+		p.offset++
+		return &value{table: inlineTable}, nil
+	}
+	for {
+		p.skipSpace()
+		err := p.keyValue(inlineTable)
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.offset++
+		case '}':
+			p.offset++
+			return &value{table: inlineTable}, nil
+		default:
+			return nil, p.newError(p.offset, "expected , or } in inline table, found ", p.describe())
+		}
+	}
+}
+
+func (p *parser) scalar() (*value, error) {
+	start := p.offset
+	for !p.eof() && isScalar(p.peek()) {
+		p.offset++
+	}
+	// local date and time separated by a space
+	if dateRegex.Match(p.content[start:p.offset]) && p.hasPrefix(" ") && p.offset+3 < len(p.content) && p.content[p.offset+3] == ':' {
+		p.offset++
+		for !p.eof() && isScalar(p.peek()) {
+			p.offset++
+		}
+	}
+	token := string(p.content[start:p.offset])
+	v := &value{offset: start}
+	switch {
+	case token == "":
+		return nil, p.newError(start, "expected value, found ", p.describe())
+	case token == "true" || token == "false":
+		v.scalar = []byte(token)
+	case strings.TrimLeft(token, "+-") == "inf" || strings.TrimLeft(token, "+-") == "nan":
+		return nil, p.newError(start, "inf and nan are not supported")
+	case strings.HasPrefix(token, "0x") || strings.HasPrefix(token, "0o") || strings.HasPrefix(token, "0b"):
+		base := map[byte]int{'x': 16, 'o': 8, 'b': 2}[token[1]]
+		digits := token[2:]
+		if strings.HasPrefix(digits, "_") || strings.HasSuffix(digits, "_") || strings.Contains(digits, "__") {
+			return nil, p.newError(start, "invalid integer ", strconv.Quote(token))
+		}
+		integer, err := strconv.ParseInt(strings.ReplaceAll(digits, "_", ""), base, 64)
+		if err != nil {
+			return nil, p.newError(start, "invalid integer ", strconv.Quote(token))
+		}
+		v.scalar = strconv.AppendInt(nil, integer, 10)
+	case integerRegex.MatchString(token) || floatRegex.MatchString(token):
+		v.scalar = []byte(strings.ReplaceAll(strings.TrimPrefix(token, "+"), "_", ""))
+	case dateTimeRegex.MatchString(token):
+		v.scalar = []byte(strconv.Quote(token))
+	default:
+		return nil, p.newError(start, "invalid value ", strconv.Quote(token))
+	}
+	return v, nil
+}
+
+func (p *parser) basicString(start int) (string, error) {
+	var builder strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.newError(start, "unterminated string")
+		}
+		c := p.peek()
+		switch c {
+		case '"':
+			p.offset++
+			return builder.String(), nil
+		case '\\':
+			err := p.escape(&builder)
+			if err != nil {
+				return "", err
+			}
+		default:
+			builder.WriteByte(c)
+			p.offset++
+		}
+	}
+}
+
+func (p *parser) multilineBasicString(start int) (string, error) {
+	p.trimNewline()
+	var builder strings.Builder
+	for {
+		if p.eof() {
+			return "", p.newError(start, "unterminated string")
+		}
+		if p.hasPrefix(`"""`) {
+			p.offset += 3
+			// up to two quotes are allowed right before the delimiter
+			for i := 0; i < 2 && p.peek() == '"'; i++ {
+				builder.WriteByte('"')
+				p.offset++
+			}
+			return builder.String(), nil
+		}
+		c := p.peek()
+		if c != '\\' {
+			builder.WriteByte(c)
+			p.offset++
+			continue
+		}
+		// line ending backslash trims all whitespace up to the next non-whitespace character
+		end := p.offset + 1
+		for end < len(p.content) && (p.content[end] == ' ' || p.content[end] == '\t') {
+			end++
+		}
+		if end < len(p.content) && (p.content[end] == '\n' || p.content[end] == '\r') {
+			p.offset = end
+			for !p.eof() && strings.IndexByte(" \t\r\n", p.peek()) != -1 {
+				p.offset++
+			}
+			continue
+		}
+		err := p.escape(&builder)
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+func (p *parser) literalString(start int) (string, error) {
+	index := strings.IndexAny(string(p.content[p.offset:]), "'\n")
+	if index == -1 || p.content[p.offset+index] == '\n' {
+		return "", p.newError(start, "unterminated string")
+	}
+	content := string(p.content[p.offset : p.offset+index])
+	p.offset += index + 1
+	return content, nil
+}
+
+func (p *parser) multilineLiteralString(start int) (string, error) {
+	p.trimNewline()
+	index := strings.Index(string(p.content[p.offset:]), "'''")
+	if index == -1 {
+		return "", p.newError(start, "unterminated string")
+	}
+	end := p.offset + index
+	for i := 0; i < 2 && end+3 < len(p.content) && p.content[end+3] == '\''; i++ {
+		end++
+	}
+	content := string(p.content[p.offset:end])
+	p.offset = end + 3
+	return content, nil
+}
+
+// trimNewline skips a newline immediately following the opening delimiter of a multi-line string.
+func (p *parser) trimNewline() {
+	if p.hasPrefix("\n") {
+		p.offset++
+	} else if p.hasPrefix("\r\n") {
+		p.offset += 2
+	}
+}
+
+func (p *parser) escape(builder *strings.Builder) error {
+	start := p.offset
+	p.offset++
+	c := p.peek()
+	p.offset++
+	switch c {
+	case 'b':
+		builder.WriteByte('\b')
+	case 't':
+		builder.WriteByte('\t')
+	case 'n':
+		builder.WriteByte('\n')
+	case 'f':
+		builder.WriteByte('\f')
+	case 'r':
+		builder.WriteByte('\r')
+	case 'e':
+		builder.WriteByte(0x1b)
+	case '"':
+		builder.WriteByte('"')
+	case '\\':
+		builder.WriteByte('\\')
+	case 'u', 'U':
+		length := 4
+		if c == 'U' {
+			length = 8
+		}
+		if p.offset+length > len(p.content) {
+			return p.newError(start, "invalid escape in string")
+		}
+		code, err := strconv.ParseUint(string(p.content[p.offset:p.offset+length]), 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return p.newError(start, "invalid escape in string")
+		}
+		p.offset += length
+		builder.WriteRune(rune(code))
+	default:
+		return p.newError(start, "invalid escape in string")
+	}
+	return nil
+}
+
+func isBareKey(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func isScalar(c byte) bool {
+	return isBareKey(c) || c == '+' || c == '.' || c == ':'
+}
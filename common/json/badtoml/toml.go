@@ -0,0 +1,115 @@
+package badtoml
+
+import (
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/json"
+)
+
+// Unmarshal decodes a TOML document into a JSON option type, with the semantics of its UnmarshalJSON methods.
+// Unknown fields are rejected, and errors are returned as *json.DecodeError with positions in the TOML document.
+func Unmarshal[T any](content []byte) (T, error) {
+	jsonContent, offsets, err := ToJSON(content)
+	if err != nil {
+		return common.DefaultValue[T](), err
+	}
+	return json.UnmarshalExtended[T](jsonContent, json.Translated(content, offsets), json.DisallowUnknownFields())
+}
+
+// ToJSON translates a TOML document into JSON, with offsets mapping the JSON back to the document.
+// Date and time values are translated to strings.
+func ToJSON(content []byte) ([]byte, json.OffsetMap, error) {
+	p := &parser{content: content, root: newTable(0)}
+	p.current = p.root
+	err := p.parse()
+	if err != nil {
+		return nil, nil, err
+	}
+	var w writer
+	err = w.value(&value{table: p.root})
+	if err != nil {
+		return nil, nil, err
+	}
+	return w.output, w.offsets, nil
+}
+
+type table struct {
+	offset  int
+	keys    []string
+	entries map[string]*entry
+	// defined is set for tables defined by a header
+	defined bool
+	// dotted is set for tables created by dotted keys
+	dotted bool
+	inline bool
+}
+
+type entry struct {
+	keyOffset int
+	value     *value
+}
+
+type value struct {
+	offset     int
+	scalar     []byte
+	table      *table
+	array      []*value
+	tableArray bool
+}
+
+func newTable(offset int) *table {
+	return &table{offset: offset, entries: make(map[string]*entry)}
+}
+
+func (t *table) put(key string, keyOffset int, value *value) {
+	t.keys = append(t.keys, key)
+	t.entries[key] = &entry{keyOffset, value}
+}
+
+type writer struct {
+	output  []byte
+	offsets json.OffsetMap
+}
+
+func (w *writer) emit(offset int, content []byte) {
+	w.offsets.Record(int64(len(w.output)), int64(offset))
+	w.output = append(w.output, content...)
+}
+
+func (w *writer) value(v *value) error {
+	switch {
+	case v.table != nil:
+		w.emit(v.table.offset, []byte{'{'})
+		for i, key := range v.table.keys {
+			if i > 0 {
+				w.output = append(w.output, ',')
+			}
+			entry := v.table.entries[key]
+			keyContent, err := json.Marshal(key)
+			if err != nil {
+				return err
+			}
+			w.emit(entry.keyOffset, keyContent)
+			w.output = append(w.output, ':')
+			err = w.value(entry.value)
+			if err != nil {
+				return err
+			}
+		}
+		w.output = append(w.output, '}')
+	case v.scalar != nil:
+		w.emit(v.offset, v.scalar)
+	default:
+		w.emit(v.offset, []byte{'['})
+		for i, item := range v.array {
+			if i > 0 {
+				w.output = append(w.output, ',')
+			}
+			err := w.value(item)
+			if err != nil {
+				return err
+			}
+		}
+		w.output = append(w.output, ']')
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+//go:build go1.20 && !without_contextjson
+
+package badtoml_test
+
+import (
+	"testing"
+
+	"github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/common/json/badtoml"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshalError(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range []struct {
+		content string
+		row     int
+		column  int
+		path    string
+	}{
+		{"[[inbounds]]\ntag = 'a'\nlisten = 'bad'\n", 3, 10, "inbounds[0].listen"},
+		{"[[inbounds]]\n# comment\n  prot = 80\n", 3, 3, "inbounds[0].prot"},
+		{"[[inbounds]]\nport = [1]\n", 2, 8, "inbounds[0].port"},
+		{"[[inbounds]]\nport = 1\nport = 2\n", 3, 1, ""},
+		{"[log]\n[log]\n", 2, 2, ""},
+		{"[log]\nlevel = 'a' 'b'\n", 2, 13, ""},
+		{"[log]\nlevel = 'a\n", 2, 9, ""},
+		{"a = inf\n", 1, 5, ""},
+	} {
+		_, err := badtoml.Unmarshal[testConfig]([]byte(testCase.content))
+		var decodeError *json.DecodeError
+		require.ErrorAs(t, err, &decodeError, testCase.content)
+		require.Equal(t, testCase.row, decodeError.Row, err.Error())
+		require.Equal(t, testCase.column, decodeError.Column, err.Error())
+		require.Equal(t, testCase.path, decodeError.Path, err.Error())
+	}
+}
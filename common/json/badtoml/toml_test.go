@@ -0,0 +1,82 @@
+package badtoml_test
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/sagernet/sing/common/json/badoption"
+	"github.com/sagernet/sing/common/json/badtoml"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testInbound struct {
+	Tag     string                                   `json:"tag,omitempty"`
+	Listen  *badoption.Addr                          `json:"listen,omitempty"`
+	Port    uint16                                   `json:"port,omitempty"`
+	Address badoption.Listable[badoption.Prefixable] `json:"address,omitempty"`
+	Timeout badoption.Duration                       `json:"timeout,omitempty"`
+	Sniff   bool                                     `json:"sniff,omitempty"`
+}
+
+type testConfig struct {
+	Log struct {
+		Level string `json:"level,omitempty"`
+	} `json:"log,omitempty"`
+	Inbounds []testInbound `json:"inbounds,omitempty"`
+	Extra    any           `json:"extra,omitempty"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	t.Parallel()
+	config, err := badtoml.Unmarshal[testConfig]([]byte(`
+log.level = "debug" # comment
+
+[[inbounds]]
+tag = 'single'
+listen = "::"
+timeout = "5m"
+port = 0x50
+address = "172.18.0.1/30"
+
+[[inbounds]]
+tag = """
+list"""
+timeout = '1s'
+sniff = true
+address = [
+  "172.18.0.1",
+  "fdfe:dcba:9876::1/126", # trailing comma
+]
+
+[extra]
+numbers = [1_000, -2, +3.5e2, 0o17, 0b11]
+date = 1979-05-27 07:32:00Z
+inline = { "aé" = 1, b.c = 'x' }
+text = """\
+  joined \
+  line"""
+`))
+	require.NoError(t, err)
+	require.Equal(t, "debug", config.Log.Level)
+	require.Len(t, config.Inbounds, 2)
+	require.Equal(t, "single", config.Inbounds[0].Tag)
+	require.Equal(t, netip.IPv6Unspecified(), netip.Addr(*config.Inbounds[0].Listen))
+	require.Equal(t, uint16(80), config.Inbounds[0].Port)
+	require.Equal(t, 5*time.Minute, config.Inbounds[0].Timeout.Build())
+	require.Equal(t, badoption.Listable[badoption.Prefixable]{badoption.Prefixable(netip.MustParsePrefix("172.18.0.1/30"))}, config.Inbounds[0].Address)
+	require.Equal(t, "list", config.Inbounds[1].Tag)
+	require.Equal(t, time.Second, config.Inbounds[1].Timeout.Build())
+	require.True(t, config.Inbounds[1].Sniff)
+	require.Equal(t, badoption.Listable[badoption.Prefixable]{
+		badoption.Prefixable(netip.MustParsePrefix("172.18.0.1/32")),
+		badoption.Prefixable(netip.MustParsePrefix("fdfe:dcba:9876::1/126")),
+	}, config.Inbounds[1].Address)
+	require.Equal(t, map[string]any{
+		"numbers": []any{float64(1000), float64(-2), float64(350), float64(15), float64(3)},
+		"date":    "1979-05-27 07:32:00Z",
+		"inline":  map[string]any{"aé": float64(1), "b": map[string]any{"c": "x"}},
+		"text":    "joined line",
+	}, config.Extra)
+}
@@ -0,0 +1,288 @@
+package badyaml
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/sagernet/sing/common"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json"
+
+	"gopkg.in/yaml.v3"
+)
+
+var lineErrorRegex = regexp.MustCompile(`^yaml: line ([0-9]+): `)
+
+// Unmarshal decodes a YAML document into a JSON option type, with the semantics of its UnmarshalJSON methods.
+// Unknown fields are rejected, and errors are returned as *json.DecodeError with positions in the YAML document.
+func Unmarshal[T any](content []byte) (T, error) {
+	jsonContent, offsets, err := ToJSON(content)
+	if err != nil {
+		return common.DefaultValue[T](), err
+	}
+	return json.UnmarshalExtended[T](jsonContent, json.Translated(content, offsets), json.DisallowUnknownFields())
+}
+
+// ToJSON translates a YAML document into JSON, with offsets mapping the JSON back to the document.
+func ToJSON(content []byte) ([]byte, json.OffsetMap, error) {
+	var document yaml.Node
+	err := yaml.Unmarshal(content, &document)
+	if err != nil {
+		var offset int64
+		if match := lineErrorRegex.FindStringSubmatch(err.Error()); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			offset = lineOffset(content, line)
+		}
+		return nil, nil, json.NewDecodeError(err, "", offset, content)
+	}
+	if document.Kind == 0 {
+		// empty or comment-only document
+		return []byte("null"), nil, nil
+	}
+	t := &translator{
+		content:  content,
+		visiting: make(map[*yaml.Node]bool),
+	}
+	err = t.node(&document)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t.output, t.offsets, nil
+}
+
+type translator struct {
+	content  []byte
+	output   []byte
+	offsets  json.OffsetMap
+	visiting map[*yaml.Node]bool
+	// decodeCount and aliasCount limit alias expansion like yaml.v3 does for its own decoder
+	decodeCount int
+	aliasCount  int
+	aliasDepth  int
+}
+
+type mappingEntry struct {
+	key   *yaml.Node
+	value *yaml.Node
+	// merged entries are expanded from merge keys
+	merged bool
+}
+
+func (t *translator) emit(node *yaml.Node, content []byte) {
+	t.offsets.Record(int64(len(t.output)), t.offset(node))
+	t.output = append(t.output, content...)
+}
+
+func (t *translator) offset(node *yaml.Node) int64 {
+	offset := lineOffset(t.content, node.Line)
+	for column := 1; column < node.Column && offset < int64(len(t.content)); column++ {
+		_, size := utf8.DecodeRune(t.content[offset:])
+		offset += int64(size)
+	}
+	return offset
+}
+
+func (t *translator) newError(node *yaml.Node, message ...any) error {
+	return json.NewDecodeError(E.New(message...), "", t.offset(node), t.content)
+}
+
+func (t *translator) node(node *yaml.Node) error {
+	t.decodeCount++
+	if t.aliasDepth > 0 {
+		t.aliasCount++
+	}
+	if t.aliasCount > 100 && t.decodeCount > 1000 && float64(t.aliasCount)/float64(t.decodeCount) > allowedAliasRatio(t.decodeCount) {
+		return t.newError(node, "document contains excessive aliasing")
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			t.emit(node, []byte("null"))
+			return nil
+		}
+		return t.node(node.Content[0])
+	case yaml.MappingNode:
+		return t.mapping(node)
+	case yaml.SequenceNode:
+		t.emit(node, []byte{'['})
+		for i, item := range node.Content {
+			if i > 0 {
+				t.output = append(t.output, ',')
+			}
+			err := t.node(item)
+			if err != nil {
+				return err
+			}
+		}
+		t.output = append(t.output, ']')
+		return nil
+	case yaml.ScalarNode:
+		return t.scalar(node)
+	case yaml.AliasNode:
+		if t.visiting[node.Alias] {
+			return t.newError(node, "recursive alias *", node.Value)
+		}
+		t.visiting[node.Alias] = true
+		t.aliasDepth++
+		err := t.node(node.Alias)
+		t.aliasDepth--
+		delete(t.visiting, node.Alias)
+		return err
+	default:
+		return t.newError(node, "unknown YAML node kind ", strconv.Itoa(int(node.Kind)))
+	}
+}
+
+// allowedAliasRatio is the ratio of nodes decoded through aliases allowed by yaml.v3,
+// decreasing for large documents.
+func allowedAliasRatio(decodeCount int) float64 {
+	switch {
+	case decodeCount <= 400000:
+		return 0.99
+	case decodeCount >= 4000000:
+		return 0.10
+	default:
+		return 0.99 - 0.89*(float64(decodeCount-400000)/3600000)
+	}
+}
+
+func (t *translator) mapping(node *yaml.Node) error {
+	entries, err := t.mappingEntries(node)
+	if err != nil {
+		return err
+	}
+	t.emit(node, []byte{'{'})
+	for i, entry := range entries {
+		if i > 0 {
+			t.output = append(t.output, ',')
+		}
+		keyContent, err := json.Marshal(entry.key.Value)
+		if err != nil {
+			return err
+		}
+		t.emit(entry.key, keyContent)
+		t.output = append(t.output, ':')
+		if entry.merged {
+			t.aliasDepth++
+		}
+		err = t.node(entry.value)
+		if entry.merged {
+			t.aliasDepth--
+		}
+		if err != nil {
+			return err
+		}
+	}
+	t.output = append(t.output, '}')
+	return nil
+}
+
+// mappingEntries returns the entries of a mapping, followed by entries from merge keys ("<<") that it does not override.
+func (t *translator) mappingEntries(node *yaml.Node) ([]mappingEntry, error) {
+	var (
+		entries []mappingEntry
+		merged  []mappingEntry
+		keys    = make(map[string]bool)
+	)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := t.resolve(node.Content[i]), node.Content[i+1]
+		if key.Kind == yaml.ScalarNode && key.ShortTag() == "!!merge" {
+			mergeEntries, err := t.mergeEntries(value)
+			if err != nil {
+				return nil, err
+			}
+			merged = append(merged, mergeEntries...)
+			continue
+		}
+		if key.Kind != yaml.ScalarNode {
+			return nil, t.newError(key, "invalid mapping key: only scalar keys are supported")
+		}
+		if keys[key.Value] {
+			return nil, t.newError(key, "duplicate key ", strconv.Quote(key.Value))
+		}
+		keys[key.Value] = true
+		entries = append(entries, mappingEntry{key: key, value: value})
+	}
+	for _, entry := range merged {
+		if !keys[entry.key.Value] {
+			keys[entry.key.Value] = true
+			entry.merged = true
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (t *translator) mergeEntries(value *yaml.Node) ([]mappingEntry, error) {
+	if value.Kind == yaml.AliasNode {
+		if t.visiting[value.Alias] {
+			return nil, t.newError(value, "recursive alias *", value.Value)
+		}
+		t.visiting[value.Alias] = true
+		defer delete(t.visiting, value.Alias)
+		value = value.Alias
+	}
+	switch value.Kind {
+	case yaml.MappingNode:
+		return t.mappingEntries(value)
+	case yaml.SequenceNode:
+		// earlier mappings take precedence
+		var entries []mappingEntry
+		for _, item := range value.Content {
+			itemEntries, err := t.mergeEntries(item)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, itemEntries...)
+		}
+		return entries, nil
+	default:
+		return nil, t.newError(value, "invalid merge value: expected a mapping or a sequence of mappings")
+	}
+}
+
+func (t *translator) resolve(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.AliasNode {
+		return node.Alias
+	}
+	return node
+}
+
+func (t *translator) scalar(node *yaml.Node) error {
+	var value any
+	switch node.ShortTag() {
+	case "!!null":
+		t.emit(node, []byte("null"))
+		return nil
+	case "!!bool", "!!int", "!!float":
+		err := node.Decode(&value)
+		if err != nil {
+			return json.NewDecodeError(err, "", t.offset(node), t.content)
+		}
+	case "!!binary":
+		value = string(bytes.Join(bytes.Fields([]byte(node.Value)), nil))
+	default:
+		value = node.Value
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		return json.NewDecodeError(err, "", t.offset(node), t.content)
+	}
+	t.emit(node, content)
+	return nil
+}
+
+// lineOffset returns the offset of the start of a 1-based line.
+func lineOffset(content []byte, line int) int64 {
+	var offset int
+	for ; line > 1; line-- {
+		index := bytes.IndexByte(content[offset:], '\n')
+		if index == -1 {
+			return int64(len(content))
+		}
+		offset += index + 1
+	}
+	return int64(offset)
+}
@@ -0,0 +1,35 @@
+//go:build go1.20 && !without_contextjson
+
+package badyaml_test
+
+import (
+	"testing"
+
+	"github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/common/json/badyaml"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshalErrorPosition(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range []struct {
+		content string
+		row     int
+		column  int
+		path    string
+	}{
+		{"inbounds:\n  - tag: a\n    listen: bad\n", 3, 13, "inbounds[0].listen"},
+		{"inbounds:\n  - tag: a\n    # comment\n    prot: 80\n", 4, 5, "inbounds[0].prot"},
+		{"inbounds:\n  - port: [1]\n", 2, 11, "inbounds[0].port"},
+		{"inbounds:\n  - port: 1\n    tag: a: b\n", 3, 1, ""},
+		{"inbounds:\n  - port: .inf\n", 2, 11, ""},
+	} {
+		_, err := badyaml.Unmarshal[testConfig]([]byte(testCase.content))
+		var decodeError *json.DecodeError
+		require.ErrorAs(t, err, &decodeError, testCase.content)
+		require.Equal(t, testCase.row, decodeError.Row, err.Error())
+		require.Equal(t, testCase.column, decodeError.Column, err.Error())
+		require.Equal(t, testCase.path, decodeError.Path, err.Error())
+	}
+}
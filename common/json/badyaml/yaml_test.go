@@ -0,0 +1,98 @@
+package badyaml_test
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/sagernet/sing/common/json/badoption"
+	"github.com/sagernet/sing/common/json/badyaml"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testInbound struct {
+	Tag     string                                   `json:"tag,omitempty"`
+	Listen  *badoption.Addr                          `json:"listen,omitempty"`
+	Port    uint16                                   `json:"port,omitempty"`
+	Address badoption.Listable[badoption.Prefixable] `json:"address,omitempty"`
+	Timeout badoption.Duration                       `json:"timeout,omitempty"`
+	Sniff   bool                                     `json:"sniff,omitempty"`
+}
+
+type testConfig struct {
+	Inbounds []testInbound `json:"inbounds,omitempty"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	t.Parallel()
+	config, err := badyaml.Unmarshal[testConfig]([]byte(`
+inbounds:
+  - &base
+    tag: single
+    listen: "::"
+    timeout: 5m
+    port: 0x50
+    address: 172.18.0.1/30
+  - <<: *base
+    tag: list
+    timeout: 1s
+    sniff: true
+    address:
+      - 172.18.0.1
+      - fdfe:dcba:9876::1/126
+`))
+	require.NoError(t, err)
+	require.Len(t, config.Inbounds, 2)
+	require.Equal(t, netip.IPv6Unspecified(), netip.Addr(*config.Inbounds[0].Listen))
+	require.Equal(t, uint16(80), config.Inbounds[0].Port)
+	require.Equal(t, 5*time.Minute, config.Inbounds[0].Timeout.Build())
+	require.Equal(t, []netip.Prefix{netip.MustParsePrefix("172.18.0.1/30")}, buildPrefixes(config.Inbounds[0].Address))
+	require.Equal(t, "list", config.Inbounds[1].Tag)
+	require.Equal(t, uint16(80), config.Inbounds[1].Port)
+	require.Equal(t, time.Second, config.Inbounds[1].Timeout.Build())
+	require.True(t, config.Inbounds[1].Sniff)
+	require.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("172.18.0.1/32"),
+		netip.MustParsePrefix("fdfe:dcba:9876::1/126"),
+	}, buildPrefixes(config.Inbounds[1].Address))
+}
+
+func buildPrefixes(list badoption.Listable[badoption.Prefixable]) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, prefix := range list {
+		prefixes = append(prefixes, netip.Prefix(prefix))
+	}
+	return prefixes
+}
+
+func TestUnmarshalEmpty(t *testing.T) {
+	t.Parallel()
+	for _, content := range []string{"", "\n", "# comment only\n"} {
+		content, _, err := badyaml.ToJSON([]byte(content))
+		require.NoError(t, err)
+		require.Equal(t, "null", string(content))
+	}
+	config, err := badyaml.Unmarshal[testConfig]([]byte("# comment only\n"))
+	require.NoError(t, err)
+	require.Empty(t, config.Inbounds)
+}
+
+func TestUnmarshalExcessiveAliasing(t *testing.T) {
+	t.Parallel()
+	_, _, err := badyaml.ToJSON([]byte(`
+a: &a ["x", "x", "x", "x", "x", "x", "x", "x", "x", "x"]
+b: &b [*a, *a, *a, *a, *a, *a, *a, *a, *a, *a]
+c: &c [*b, *b, *b, *b, *b, *b, *b, *b, *b, *b]
+d: &d [*c, *c, *c, *c, *c, *c, *c, *c, *c, *c]
+e: [*d, *d, *d, *d, *d, *d, *d, *d, *d, *d]
+`))
+	require.ErrorContains(t, err, "excessive aliasing")
+	_, _, err = badyaml.ToJSON([]byte(`
+a: &a {a0: x, a1: x, a2: x, a3: x, a4: x, a5: x, a6: x, a7: x, a8: x, a9: x}
+b: &b {b0: {<<: *a}, b1: {<<: *a}, b2: {<<: *a}, b3: {<<: *a}, b4: {<<: *a}, b5: {<<: *a}, b6: {<<: *a}, b7: {<<: *a}, b8: {<<: *a}, b9: {<<: *a}}
+c: &c {c0: {<<: *b}, c1: {<<: *b}, c2: {<<: *b}, c3: {<<: *b}, c4: {<<: *b}, c5: {<<: *b}, c6: {<<: *b}, c7: {<<: *b}, c8: {<<: *b}, c9: {<<: *b}}
+d: {d0: {<<: *c}, d1: {<<: *c}, d2: {<<: *c}, d3: {<<: *c}, d4: {<<: *c}, d5: {<<: *c}, d6: {<<: *c}, d7: {<<: *c}, d8: {<<: *c}, d9: {<<: *c}}
+`))
+	require.ErrorContains(t, err, "excessive aliasing")
+}
@@ -23,16 +23,32 @@ const (
 )
 
 type CommentFilter struct {
-	br    *bufio.Reader
-	state commentFilterState
+	br           *bufio.Reader
+	state        commentFilterState
+	trackOffsets bool
+	read         int64
+	written      int64
+	offsets      OffsetMap
 }
 
 func NewCommentFilter(reader io.Reader) io.Reader {
 	return &CommentFilter{br: bufio.NewReader(reader)}
 }
 
+func newOffsetCommentFilter(reader io.Reader) *CommentFilter {
+	return &CommentFilter{br: bufio.NewReader(reader), trackOffsets: true}
+}
+
+// SourceOffset maps an offset in the filtered output back to the unfiltered input.
+func (v *CommentFilter) SourceOffset(offset int64) int64 {
+	return v.offsets.Source(offset)
+}
+
 func (v *CommentFilter) Read(b []byte) (int, error) {
 	p := b[:0]
+	defer func() {
+		v.written += int64(len(p))
+	}()
 	for len(p) < len(b)-2 {
 		x, err := v.br.ReadByte()
 		if err != nil {
@@ -41,6 +57,8 @@ func (v *CommentFilter) Read(b []byte) (int, error) {
 			}
 			return len(p), nil
 		}
+		v.read++
+		written := len(p)
 		switch v.state {
 		case commentFilterStateContent:
 			switch x {
@@ -123,6 +141,9 @@ func (v *CommentFilter) Read(b []byte) (int, error) {
 		default:
 			panic("Unknown state.")
 		}
+		if v.trackOffsets && len(p) > written {
+			v.offsets.Record(v.written+int64(written), v.read-int64(len(p)-written))
+		}
 	}
 	return len(p), nil
 }
@@ -20,4 +20,5 @@ type (
 	Delim       = json.Delim
 	SyntaxError = json.SyntaxError
 	RawMessage  = json.RawMessage
+	Number      = json.Number
 )
@@ -218,6 +218,7 @@ type decodeState struct {
 	useNumber             bool
 	disallowUnknownFields bool
 	context               *decodeContext
+	baseOffset            int64 // input offset of data, for values read by a Decoder
 }
 
 // readIndex returns the position of the last byte read.
@@ -233,6 +234,7 @@ const phasePanicMsg = "JSON decoder out of sync - data changing underfoot?"
 func (d *decodeState) init(data []byte) *decodeState {
 	d.data = data
 	d.off = 0
+	d.baseOffset = 0
 	d.savedError = nil
 	if d.errorContext != nil {
 		d.errorContext.Struct = nil
@@ -247,7 +249,7 @@ func (d *decodeState) init(data []byte) *decodeState {
 func (d *decodeState) saveError(err error) {
 	if d.savedError == nil {
 		if d.context != nil {
-			d.savedError = d.addErrorContext(&contextError{err, d.formatContext(), d.context.key == ""})
+			d.savedError = d.addErrorContext(&contextError{err, d.formatContext(), d.context.key == "", d.baseOffset + int64(d.context.offset)})
 		} else {
 			d.savedError = d.addErrorContext(err)
 		}
@@ -549,6 +551,7 @@ func (d *decodeState) array(v reflect.Value) error {
 		if d.opcode == scanEndArray {
 			break
 		}
+		d.context.offset = d.readIndex()
 
 		// Expand slice length, growing the slice if necessary.
 		if v.Kind() == reflect.Slice {
@@ -697,6 +700,7 @@ func (d *decodeState) object(v reflect.Value) error {
 			panic(phasePanicMsg)
 		}
 		d.context.key = string(key)
+		d.context.offset = start
 
 		// Figure out field corresponding to key.
 		var subv reflect.Value
@@ -759,6 +763,7 @@ func (d *decodeState) object(v reflect.Value) error {
 			panic(phasePanicMsg)
 		}
 		d.scanWhile(scanSkipSpace)
+		d.context.offset = d.readIndex()
 
 		if destring {
 			switch qv := d.valueQuoted().(type) {
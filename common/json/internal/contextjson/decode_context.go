@@ -1,11 +1,16 @@
 package json
 
-import "strconv"
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
 
 type decodeContext struct {
 	parent *decodeContext
 	index  int
 	key    string
+	offset int // start of the current key or value in data
 }
 
 func (d *decodeState) formatContext() string {
@@ -32,6 +37,7 @@ type contextError struct {
 	parent  error
 	context string
 	index   bool
+	offset  int64
 }
 
 func (c *contextError) Unwrap() error {
@@ -47,3 +53,66 @@ func (c *contextError) Error() string {
 		return c.context + ": " + c.parent.Error()
 	}
 }
+
+// ErrorOffset returns the input offset of the value that caused a decode error. Offsets of errors
+// returned by nested Unmarshal calls in UnmarshalJSON methods are relative to the value being unmarshalled,
+// so they are added up along the error chain.
+func ErrorOffset(err error) (offset int64, loaded bool) {
+	for err != nil {
+		//goland:noinspection GoTypeAssertionOnErrors
+		switch typedErr := err.(type) {
+		case *contextError:
+			offset += typedErr.offset
+			loaded = true
+			err = typedErr.parent
+			continue
+		case *SyntaxError:
+			return offset + typedErr.Offset, true
+		case *UnmarshalTypeError:
+			if !loaded {
+				return typedErr.Offset, true
+			}
+			return offset, true
+		case interface{ Unwrap() []error }:
+			errs := typedErr.Unwrap()
+			if len(errs) == 0 {
+				return offset, loaded
+			}
+			err = errs[0]
+			continue
+		}
+		err = errors.Unwrap(err)
+	}
+	return offset, loaded
+}
+
+// ErrorPath returns the path of the value that caused a decode error, such as "a.b[2]".
+func ErrorPath(err error) string {
+	var path string
+	for err != nil {
+		//goland:noinspection GoTypeAssertionOnErrors
+		switch typedErr := err.(type) {
+		case *contextError:
+			if path != "" && !strings.HasPrefix(typedErr.context, "[") {
+				path += "."
+			}
+			path += typedErr.context
+			err = typedErr.parent
+			continue
+		case *UnmarshalTypeError:
+			if path == "" {
+				return typedErr.Field
+			}
+			return path
+		case interface{ Unwrap() []error }:
+			errs := typedErr.Unwrap()
+			if len(errs) == 0 {
+				return path
+			}
+			err = errs[0]
+			continue
+		}
+		err = errors.Unwrap(err)
+	}
+	return path
+}
@@ -65,6 +65,7 @@ func (dec *Decoder) Decode(v any) error {
 		return err
 	}
 	dec.d.init(dec.buf[dec.scanp : dec.scanp+n])
+	dec.d.baseOffset = dec.InputOffset()
 	dec.scanp += n
 
 	// Don't save err from unmarshal into dec.err:
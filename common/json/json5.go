@@ -0,0 +1,437 @@
+package json
+
+import (
+	"bytes"
+	"math/big"
+	"strconv"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// json5Translator rewrites JSON5 into JSON, recording the source offset of every token it writes,
+// so that the result can be decoded by the context-aware decoder with errors pointing into the source.
+type json5Translator struct {
+	source       []byte
+	offset       int
+	output       []byte
+	offsets      OffsetMap
+	stack        []byte
+	last         byte
+	pendingComma int
+}
+
+type json5Error struct {
+	offset int
+	err    error
+}
+
+func (e *json5Error) Error() string {
+	return e.err.Error()
+}
+
+func (e *json5Error) Unwrap() error {
+	return e.err
+}
+
+func translateJSON5(content []byte) ([]byte, OffsetMap, error) {
+	translator := &json5Translator{source: content, pendingComma: -1}
+	err := translator.translate()
+	if err != nil {
+		//goland:noinspection GoTypeAssertionOnErrors
+		translateErr := err.(*json5Error)
+		return nil, nil, NewDecodeError(translateErr.err, "", int64(translateErr.offset), content)
+	}
+	return translator.output, translator.offsets, nil
+}
+
+func (t *json5Translator) newError(offset int, message ...any) error {
+	return &json5Error{offset, E.New(message...)}
+}
+
+func (t *json5Translator) emit(content []byte, source int) {
+	t.offsets.Record(int64(len(t.output)), int64(source))
+	t.output = append(t.output, content...)
+}
+
+func (t *json5Translator) translate() error {
+	for {
+		spaceStart := t.offset
+		hasSpace, err := t.skipSpace()
+		if err != nil {
+			return err
+		}
+		if t.offset >= len(t.source) {
+			if t.pendingComma >= 0 {
+				t.emit([]byte{','}, t.pendingComma)
+			}
+			return nil
+		}
+		c := t.source[t.offset]
+		if t.pendingComma >= 0 {
+			// trailing commas are dropped
+			if c != '}' && c != ']' {
+				t.emit([]byte{','}, t.pendingComma)
+			}
+			t.pendingComma = -1
+		}
+		if hasSpace {
+			t.emit([]byte{' '}, spaceStart)
+		}
+		switch {
+		case c == '{' || c == '[':
+			t.stack = append(t.stack, c)
+			t.emit([]byte{c}, t.offset)
+			t.offset++
+		case c == '}' || c == ']':
+			if len(t.stack) > 0 {
+				t.stack = t.stack[:len(t.stack)-1]
+			}
+			t.emit([]byte{c}, t.offset)
+			t.offset++
+		case c == ',':
+			t.pendingComma = t.offset
+			t.offset++
+		case c == ':':
+			t.emit([]byte{c}, t.offset)
+			t.offset++
+		case c == '"' || c == '\'':
+			err = t.string()
+			c = '"'
+		case c == '+' || c == '-' || c == '.' || c >= '0' && c <= '9':
+			err = t.number()
+			c = '0'
+		default:
+			err = t.identifier()
+			c = 'a'
+		}
+		if err != nil {
+			return err
+		}
+		t.last = c
+	}
+}
+
+func (t *json5Translator) skipSpace() (bool, error) {
+	start := t.offset
+	for t.offset < len(t.source) {
+		c := t.source[t.offset]
+		switch c {
+		case ' ', '\t', '\n', '\v', '\f', '\r':
+			t.offset++
+			continue
+		case '/':
+			if t.offset+1 >= len(t.source) {
+				return t.offset > start, nil
+			}
+			switch t.source[t.offset+1] {
+			case '/':
+				for t.offset < len(t.source) && t.source[t.offset] != '\n' && t.source[t.offset] != '\r' {
+					t.offset++
+				}
+				continue
+			case '*':
+				end := bytes.Index(t.source[t.offset+2:], []byte("*/"))
+				if end == -1 {
+					return false, t.newError(t.offset, "unterminated comment")
+				}
+				t.offset += 2 + end + 2
+				continue
+			}
+			return t.offset > start, nil
+		}
+		if c < utf8.RuneSelf {
+			break
+		}
+		r, size := utf8.DecodeRune(t.source[t.offset:])
+		if !isJSON5Space(r) {
+			break
+		}
+		t.offset += size
+	}
+	return t.offset > start, nil
+}
+
+func (t *json5Translator) string() error {
+	start := t.offset
+	quote := t.source[t.offset]
+	t.offset++
+	var value []byte
+	for {
+		if t.offset >= len(t.source) {
+			return t.newError(start, "unterminated string")
+		}
+		c := t.source[t.offset]
+		switch c {
+		case quote:
+			t.offset++
+			t.emit(appendJSONString(nil, value), start)
+			return nil
+		case '\\':
+			var err error
+			value, err = t.escape(value)
+			if err != nil {
+				return err
+			}
+		case '\n', '\r':
+			return t.newError(t.offset, "invalid line terminator in string")
+		default:
+			value = append(value, c)
+			t.offset++
+		}
+	}
+}
+
+func (t *json5Translator) escape(value []byte) ([]byte, error) {
+	start := t.offset
+	t.offset++
+	if t.offset >= len(t.source) {
+		return nil, t.newError(start, "unterminated string")
+	}
+	c := t.source[t.offset]
+	t.offset++
+	switch c {
+	case 'b':
+		return append(value, '\b'), nil
+	case 'f':
+		return append(value, '\f'), nil
+	case 'n':
+		return append(value, '\n'), nil
+	case 'r':
+		return append(value, '\r'), nil
+	case 't':
+		return append(value, '\t'), nil
+	case 'v':
+		return append(value, '\v'), nil
+	case '0':
+		if t.offset < len(t.source) && t.source[t.offset] >= '0' && t.source[t.offset] <= '9' {
+			return nil, t.newError(start, "invalid escape in string: octal escapes are not allowed")
+		}
+		return append(value, 0), nil
+	case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return nil, t.newError(start, "invalid escape in string: octal escapes are not allowed")
+	case 'x':
+		code, err := t.hex(start, 2)
+		if err != nil {
+			return nil, err
+		}
+		return utf8.AppendRune(value, rune(code)), nil
+	case 'u':
+		r, err := t.unicodeEscape(start)
+		if err != nil {
+			return nil, err
+		}
+		return utf8.AppendRune(value, r), nil
+	case '\n':
+		// line continuation
+		return value, nil
+	case '\r':
+		if t.offset < len(t.source) && t.source[t.offset] == '\n' {
+			t.offset++
+		}
+		return value, nil
+	}
+	t.offset--
+	r, size := utf8.DecodeRune(t.source[t.offset:])
+	t.offset += size
+	if r == '\u2028' || r == '\u2029' {
+		// line continuation
+		return value, nil
+	}
+	return append(value, t.source[t.offset-size:t.offset]...), nil
+}
+
+// unicodeEscape reads the digits of a \u escape, and the low surrogate that follows a high surrogate.
+func (t *json5Translator) unicodeEscape(start int) (rune, error) {
+	code, err := t.hex(start, 4)
+	if err != nil {
+		return 0, err
+	}
+	r := rune(code)
+	if utf16.IsSurrogate(r) && t.offset+1 < len(t.source) && t.source[t.offset] == '\\' && t.source[t.offset+1] == 'u' {
+		offset := t.offset
+		t.offset += 2
+		low, err := t.hex(offset, 4)
+		if err != nil {
+			return 0, err
+		}
+		if decoded := utf16.DecodeRune(r, rune(low)); decoded != utf8.RuneError {
+			return decoded, nil
+		}
+		t.offset = offset
+	}
+	return r, nil
+}
+
+func (t *json5Translator) hex(start int, length int) (uint64, error) {
+	if t.offset+length > len(t.source) {
+		return 0, t.newError(start, "invalid escape in string")
+	}
+	code, err := strconv.ParseUint(string(t.source[t.offset:t.offset+length]), 16, 32)
+	if err != nil {
+		return 0, t.newError(start, "invalid escape in string")
+	}
+	t.offset += length
+	return code, nil
+}
+
+func (t *json5Translator) number() error {
+	start := t.offset
+	var output []byte
+	switch t.source[t.offset] {
+	case '-':
+		output = append(output, '-')
+		t.offset++
+	case '+':
+		t.offset++
+	}
+	if t.hasPrefix("Infinity") || t.hasPrefix("NaN") {
+		return t.newError(start, "Infinity and NaN are not supported")
+	}
+	if t.hasPrefix("0x") || t.hasPrefix("0X") {
+		t.offset += 2
+		digits := t.digits(isHexDigit)
+		if digits == "" {
+			return t.newError(start, "invalid hexadecimal number")
+		}
+		value, _ := new(big.Int).SetString(digits, 16)
+		output = value.Append(output, 10)
+		t.emit(output, start)
+		return nil
+	}
+	integer := t.digits(isDigit)
+	var fraction string
+	if t.offset < len(t.source) && t.source[t.offset] == '.' {
+		t.offset++
+		fraction = t.digits(isDigit)
+	}
+	if integer == "" && fraction == "" {
+		return t.newError(start, "invalid character ", strconv.QuoteRune(rune(t.source[start])), " looking for beginning of value")
+	}
+	if integer == "" {
+		integer = "0"
+	}
+	output = append(output, integer...)
+	if fraction != "" {
+		output = append(output, '.')
+		output = append(output, fraction...)
+	}
+	if t.offset < len(t.source) && (t.source[t.offset] == 'e' || t.source[t.offset] == 'E') {
+		exponentStart := t.offset
+		t.offset++
+		output = append(output, 'e')
+		if t.offset < len(t.source) && (t.source[t.offset] == '+' || t.source[t.offset] == '-') {
+			output = append(output, t.source[t.offset])
+			t.offset++
+		}
+		exponent := t.digits(isDigit)
+		if exponent == "" {
+			return t.newError(exponentStart, "invalid exponent in number")
+		}
+		output = append(output, exponent...)
+	}
+	t.emit(output, start)
+	return nil
+}
+
+func (t *json5Translator) digits(isDigit func(c byte) bool) string {
+	start := t.offset
+	for t.offset < len(t.source) && isDigit(t.source[t.offset]) {
+		t.offset++
+	}
+	return string(t.source[start:t.offset])
+}
+
+func (t *json5Translator) hasPrefix(prefix string) bool {
+	return len(t.source)-t.offset >= len(prefix) && string(t.source[t.offset:t.offset+len(prefix)]) == prefix
+}
+
+func (t *json5Translator) identifier() error {
+	start := t.offset
+	var name []byte
+	for t.offset < len(t.source) {
+		var r rune
+		offset := t.offset
+		if t.source[t.offset] == '\\' {
+			if t.offset+1 >= len(t.source) || t.source[t.offset+1] != 'u' {
+				break
+			}
+			t.offset += 2
+			code, err := t.hex(offset, 4)
+			if err != nil {
+				return err
+			}
+			r = rune(code)
+		} else {
+			var size int
+			r, size = utf8.DecodeRune(t.source[t.offset:])
+			t.offset += size
+		}
+		if len(name) == 0 && !isIdentifierStart(r) || len(name) > 0 && !isIdentifierPart(r) {
+			t.offset = offset
+			break
+		}
+		name = utf8.AppendRune(name, r)
+	}
+	if len(name) == 0 {
+		r, _ := utf8.DecodeRune(t.source[start:])
+		return t.newError(start, "invalid character ", strconv.QuoteRune(r), " looking for beginning of value")
+	}
+	if len(t.stack) > 0 && t.stack[len(t.stack)-1] == '{' && (t.last == '{' || t.last == ',') {
+		t.emit(appendJSONString(nil, name), start)
+		return nil
+	}
+	switch string(name) {
+	case "true", "false", "null":
+		t.emit(name, start)
+		return nil
+	case "Infinity", "NaN":
+		return t.newError(start, "Infinity and NaN are not supported")
+	default:
+		return t.newError(start, "invalid literal ", strconv.Quote(string(name)), " looking for beginning of value")
+	}
+}
+
+func appendJSONString(output []byte, value []byte) []byte {
+	const hexDigits = "0123456789abcdef"
+	output = append(output, '"')
+	for _, c := range value {
+		switch {
+		case c == '"' || c == '\\':
+			output = append(output, '\\', c)
+		case c == '\n':
+			output = append(output, '\\', 'n')
+		case c == '\r':
+			output = append(output, '\\', 'r')
+		case c == '\t':
+			output = append(output, '\\', 't')
+		case c < 0x20:
+			output = append(output, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+		default:
+			output = append(output, c)
+		}
+	}
+	return append(output, '"')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func isJSON5Space(r rune) bool {
+	return r == '\u00a0' || r == '\u2028' || r == '\u2029' || r == '\ufeff' || unicode.Is(unicode.Zs, r)
+}
+
+func isIdentifierStart(r rune) bool {
+	return r == '$' || r == '_' || unicode.IsLetter(r) || unicode.Is(unicode.Nl, r)
+}
+
+func isIdentifierPart(r rune) bool {
+	return isIdentifierStart(r) || unicode.In(r, unicode.Mn, unicode.Mc, unicode.Nd, unicode.Pc) || r == '\u200c' || r == '\u200d'
+}
@@ -0,0 +1,57 @@
+package json_test
+
+import (
+	"testing"
+
+	"github.com/sagernet/sing/common/json"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshalJSON5(t *testing.T) {
+	t.Parallel()
+	value, err := json.UnmarshalExtended[map[string]any]([]byte(`// JSON5
+{
+  unquoted: 'single "quoted"',
+  $key_2: "line \
+continuation",
+  'escapes': '\x41é😀\t\0',
+  hex: 0xFF,
+  negativeHex: -0x10,
+  float: .5,
+  trailing: 5.,
+  positive: +1e3,
+  array: [1, 2, /* three */ 3,],
+  nested: {a: null, b: true,},
+}
+`), json.JSON5())
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{
+		"unquoted":    `single "quoted"`,
+		"$key_2":      "line continuation",
+		"escapes":     "Aé\U0001F600\t\x00",
+		"hex":         float64(255),
+		"negativeHex": float64(-16),
+		"float":       0.5,
+		"trailing":    float64(5),
+		"positive":    float64(1000),
+		"array":       []any{float64(1), float64(2), float64(3)},
+		"nested":      map[string]any{"a": nil, "b": true},
+	}, value)
+}
+
+func TestUnmarshalJSON5Invalid(t *testing.T) {
+	t.Parallel()
+	for _, content := range []string{
+		`{a: Infinity}`,
+		`{a: 'unterminated}`,
+		`{a: "line
+break"}`,
+		`{a: '\01'}`,
+		`{a: undefined}`,
+		`{a: 1} /* unterminated`,
+	} {
+		_, err := json.UnmarshalExtended[map[string]any]([]byte(content), json.JSON5())
+		require.Error(t, err, content)
+	}
+}
@@ -0,0 +1,298 @@
+package jsonschema
+
+import (
+	"encoding"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sagernet/sing/common/json"
+)
+
+// Provider is implemented by types whose JSON form differs from their Go structure,
+// such as types with a custom UnmarshalJSON method.
+type Provider interface {
+	JSONSchema(generator *Generator) *Schema
+}
+
+type Options struct {
+	ID    string
+	Title string
+	// AllowUnknownFields permits properties that are not struct fields,
+	// for configs decoded without DisallowUnknownFields.
+	AllowUnknownFields bool
+	// Types provides schemas for types that do not implement Provider, such as types from other modules.
+	Types map[reflect.Type]func(generator *Generator) *Schema
+}
+
+type jsonUnmarshaler interface {
+	UnmarshalJSON(content []byte) error
+}
+
+type Generator struct {
+	options Options
+	defs    SchemaMap
+	names   map[reflect.Type]string
+}
+
+// Variant is a possible shape of a polymorphic object, selected by the value of its discriminator property.
+// The empty value selects the variant used when the discriminator is missing.
+type Variant struct {
+	Value string
+	Type  reflect.Type
+}
+
+var (
+	providerType        = reflect.TypeOf((*Provider)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*jsonUnmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	rawMessageType      = reflect.TypeOf(json.RawMessage(nil))
+	defNameReplacer     = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+)
+
+func NewGenerator(options Options) *Generator {
+	return &Generator{
+		options: options,
+		names:   make(map[reflect.Type]string),
+	}
+}
+
+// Generate returns the root schema for the type of value, with every struct it references in $defs.
+func Generate(value any, options Options) *Schema {
+	return NewGenerator(options).Generate(value)
+}
+
+func (g *Generator) Generate(value any) *Schema {
+	schema := *g.SchemaOf(reflect.TypeOf(value))
+	schema.Schema = Draft202012
+	schema.ID = g.options.ID
+	schema.Title = g.options.Title
+	if !g.defs.IsEmpty() {
+		schema.Defs = &g.defs
+	}
+	return &schema
+}
+
+// SchemaOf returns the schema of values of type t. Structs are returned as references to $defs.
+func (g *Generator) SchemaOf(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	if typeSchema, loaded := g.options.Types[t]; loaded {
+		return typeSchema(g)
+	}
+	if t.Kind() == reflect.Pointer {
+		return g.SchemaOf(t.Elem())
+	}
+	// the zero value of an interface type is nil
+	if t.Kind() != reflect.Interface && t.Implements(providerType) {
+		return reflect.Zero(t).Interface().(Provider).JSONSchema(g)
+	}
+	if reflect.PointerTo(t).Implements(providerType) {
+		return reflect.New(t).Interface().(Provider).JSONSchema(g)
+	}
+	if t == rawMessageType {
+		return &Schema{}
+	}
+	if t.Implements(jsonUnmarshalerType) || reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		// unknown custom form
+		return &Schema{}
+	}
+	if t.Implements(textUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return &Schema{Type: "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var minimum float64
+		return &Schema{Type: "integer", Minimum: &minimum}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 && !reflect.PointerTo(t.Elem()).Implements(jsonUnmarshalerType) {
+			return &Schema{Type: "string", ContentEncoding: "base64"}
+		}
+		return &Schema{Type: "array", Items: g.SchemaOf(t.Elem())}
+	case reflect.Array:
+		return &Schema{Type: "array", Items: g.SchemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{
+			Type:                 "object",
+			PropertyNames:        g.propertyNames(t.Key()),
+			AdditionalProperties: g.SchemaOf(t.Elem()),
+		}
+	case reflect.Struct:
+		return g.define(t)
+	default:
+		return &Schema{}
+	}
+}
+
+// Object returns the schema of struct type t inline and open to other properties, for use in compositions.
+func (g *Generator) Object(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	schema := &Schema{Type: "object", Properties: new(SchemaMap)}
+	for _, field := range structFields(t) {
+		schema.Properties.Put(field.name, g.SchemaOf(field.typ))
+	}
+	return schema
+}
+
+// Union returns the schema of an object whose properties depend on the value of its discriminator,
+// with the properties of base shared by all variants.
+func (g *Generator) Union(discriminator string, base reflect.Type, variants ...Variant) *Schema {
+	schema := &Schema{Type: "object"}
+	if base != nil {
+		schema.AllOf = append(schema.AllOf, g.Object(base))
+	}
+	var values []any
+	for _, variant := range variants {
+		variantSchema := g.Object(variant.Type)
+		if variant.Value == "" {
+			schema.AllOf = append(schema.AllOf, &Schema{
+				If:   &Schema{Not: &Schema{Required: []string{discriminator}}},
+				Then: variantSchema,
+			})
+			continue
+		}
+		values = append(values, variant.Value)
+		discriminatorProperties := new(SchemaMap)
+		discriminatorProperties.Put(discriminator, &Schema{Const: variant.Value})
+		schema.AllOf = append(schema.AllOf, &Schema{
+			If:   &Schema{Properties: discriminatorProperties, Required: []string{discriminator}},
+			Then: variantSchema,
+		})
+	}
+	schema.Properties = new(SchemaMap)
+	schema.Properties.Put(discriminator, &Schema{Type: "string", Enum: values})
+	if !g.options.AllowUnknownFields {
+		schema.UnevaluatedProperties = False()
+	}
+	return schema
+}
+
+func (g *Generator) define(t reflect.Type) *Schema {
+	name, loaded := g.names[t]
+	if !loaded {
+		name = g.defName(t)
+		g.names[t] = name
+		// reserve the name first for recursive types
+		g.defs.Put(name, nil)
+		schema := g.Object(t)
+		if !g.options.AllowUnknownFields {
+			schema.AdditionalProperties = False()
+		}
+		g.defs.Put(name, schema)
+	}
+	return &Schema{Ref: "#/$defs/" + name}
+}
+
+func (g *Generator) defName(t reflect.Type) string {
+	name := defNameReplacer.ReplaceAllString(t.Name(), "_")
+	if name == "" {
+		name = "object"
+	}
+	if !g.defs.ContainsKey(name) {
+		return name
+	}
+	for i := 2; ; i++ {
+		indexedName := name + strconv.Itoa(i)
+		if !g.defs.ContainsKey(indexedName) {
+			return indexedName
+		}
+	}
+}
+
+func (g *Generator) propertyNames(t reflect.Type) *Schema {
+	if t.Kind() == reflect.String || t.Implements(textUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Pattern: `^-?[0-9]+$`}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Pattern: `^[0-9]+$`}
+	}
+	return nil
+}
+
+type structField struct {
+	name   string
+	typ    reflect.Type
+	depth  int
+	tagged bool
+}
+
+// structFields lists the JSON fields of t with the visibility rules of encoding/json.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	collectStructFields(t, 0, map[reflect.Type]bool{}, &fields)
+	dominant := make(map[string]int)
+	conflicts := make(map[string]bool)
+	for i, field := range fields {
+		index, loaded := dominant[field.name]
+		if !loaded {
+			dominant[field.name] = i
+			continue
+		}
+		current := fields[index]
+		switch {
+		case field.depth < current.depth || field.depth == current.depth && field.tagged && !current.tagged:
+			dominant[field.name] = i
+			conflicts[field.name] = false
+		case field.depth == current.depth && field.tagged == current.tagged:
+			conflicts[field.name] = true
+		}
+	}
+	var result []structField
+	for i, field := range fields {
+		if dominant[field.name] == i && !conflicts[field.name] {
+			result = append(result, field)
+		}
+	}
+	return result
+}
+
+func collectStructFields(t reflect.Type, depth int, visited map[reflect.Type]bool, fields *[]structField) {
+	if visited[t] {
+		return
+	}
+	visited[t] = true
+	defer delete(visited, t)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		fieldType := field.Type
+		if field.Anonymous {
+			if fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+			if name == "" && fieldType.Kind() == reflect.Struct {
+				collectStructFields(fieldType, depth+1, visited, fields)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+		} else if !field.IsExported() {
+			continue
+		}
+		tagged := name != ""
+		if !tagged {
+			name = field.Name
+		}
+		*fields = append(*fields, structField{name, field.Type, depth, tagged})
+	}
+}
@@ -0,0 +1,125 @@
+package jsonschema_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/common/json/badjson"
+	"github.com/sagernet/sing/common/json/badoption"
+	"github.com/sagernet/sing/common/json/jsonschema"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testBaseOptions struct {
+	Tag string `json:"tag,omitempty"`
+}
+
+type testOptions struct {
+	testBaseOptions
+	Listen    *badoption.Addr                          `json:"listen,omitempty"`
+	Address   badoption.Listable[badoption.Prefixable] `json:"address,omitempty"`
+	Timeout   badoption.Duration                       `json:"timeout,omitempty"`
+	Headers   badoption.HTTPHeader                     `json:"headers,omitempty"`
+	Regex     *badoption.Regexp                        `json:"regex,omitempty"`
+	Ports     map[uint16]string                        `json:"ports,omitempty"`
+	Env       badjson.TypedMap[string, int]            `json:"env,omitempty"`
+	Outbounds []testOutbound                           `json:"outbounds,omitempty"`
+	Child     *testOptions                             `json:"child,omitempty"`
+	Ignored   string                                   `json:"-"`
+}
+
+type testDirectOptions struct {
+	Interface string `json:"interface,omitempty"`
+}
+
+type testSocksOptions struct {
+	Server     string `json:"server"`
+	ServerPort uint16 `json:"server_port"`
+}
+
+type testOutbound struct {
+	Type          string            `json:"type"`
+	Tag           string            `json:"tag,omitempty"`
+	DirectOptions testDirectOptions `json:"-"`
+	SocksOptions  testSocksOptions  `json:"-"`
+}
+
+type testOutboundBase struct {
+	Tag string `json:"tag,omitempty"`
+}
+
+func (o testOutbound) JSONSchema(generator *jsonschema.Generator) *jsonschema.Schema {
+	return generator.Union("type", reflect.TypeOf(testOutboundBase{}),
+		jsonschema.Variant{Value: "direct", Type: reflect.TypeOf(testDirectOptions{})},
+		jsonschema.Variant{Value: "socks", Type: reflect.TypeOf(testSocksOptions{})},
+	)
+}
+
+func generateTestSchema(t *testing.T) map[string]any {
+	content, err := json.Marshal(jsonschema.Generate(testOptions{}, jsonschema.Options{Title: "test"}))
+	require.NoError(t, err)
+	var schema map[string]any
+	require.NoError(t, json.Unmarshal(content, &schema))
+	return schema
+}
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+	schema := generateTestSchema(t)
+	require.Equal(t, jsonschema.Draft202012, schema["$schema"])
+	require.Equal(t, "#/$defs/testOptions", schema["$ref"])
+	defs := schema["$defs"].(map[string]any)
+	options := defs["testOptions"].(map[string]any)
+	require.Equal(t, false, options["additionalProperties"])
+	properties := options["properties"].(map[string]any)
+	require.NotContains(t, properties, "Ignored")
+	require.Equal(t, map[string]any{"type": "string"}, properties["tag"])
+	require.Equal(t, map[string]any{"$ref": "#/$defs/testOptions"}, properties["child"])
+	require.Equal(t, "string", properties["timeout"].(map[string]any)["type"])
+	require.Equal(t, "regex", properties["regex"].(map[string]any)["format"])
+	require.Len(t, properties["listen"].(map[string]any)["anyOf"], 2)
+
+	address := properties["address"].(map[string]any)["anyOf"].([]any)
+	require.Equal(t, "string", address[0].(map[string]any)["type"])
+	require.Equal(t, "array", address[1].(map[string]any)["type"])
+
+	headers := properties["headers"].(map[string]any)
+	require.Equal(t, "object", headers["type"])
+	require.Contains(t, headers["additionalProperties"], "anyOf")
+
+	ports := properties["ports"].(map[string]any)
+	require.Equal(t, "^[0-9]+$", ports["propertyNames"].(map[string]any)["pattern"])
+	require.Equal(t, map[string]any{"type": "integer"}, properties["env"].(map[string]any)["additionalProperties"])
+
+	outbound := properties["outbounds"].(map[string]any)["items"].(map[string]any)
+	require.Equal(t, false, outbound["unevaluatedProperties"])
+	require.Equal(t, []any{"direct", "socks"}, outbound["properties"].(map[string]any)["type"].(map[string]any)["enum"])
+	variants := outbound["allOf"].([]any)
+	require.Len(t, variants, 3)
+	socks := variants[2].(map[string]any)
+	require.Equal(t, "socks", socks["if"].(map[string]any)["properties"].(map[string]any)["type"].(map[string]any)["const"])
+	require.Contains(t, socks["then"].(map[string]any)["properties"], "server_port")
+}
+
+func TestGenerateAllowUnknownFields(t *testing.T) {
+	t.Parallel()
+	schema := jsonschema.Generate(testOptions{}, jsonschema.Options{AllowUnknownFields: true})
+	options, loaded := schema.Defs.Get("testOptions")
+	require.True(t, loaded)
+	require.Nil(t, options.AdditionalProperties)
+	require.Equal(t, []string{"tag", "listen", "address", "timeout", "headers", "regex", "ports", "env", "outbounds", "child"}, options.Properties.Keys())
+}
+
+func TestGenerateProviderInterface(t *testing.T) {
+	t.Parallel()
+	schema := jsonschema.Generate(struct {
+		Provider jsonschema.Provider `json:"provider"`
+	}{}, jsonschema.Options{})
+	options, loaded := schema.Defs.Get("object")
+	require.True(t, loaded)
+	provider, loaded := options.Properties.Get("provider")
+	require.True(t, loaded)
+	require.Equal(t, &jsonschema.Schema{}, provider)
+}
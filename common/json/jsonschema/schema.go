@@ -0,0 +1,85 @@
+package jsonschema
+
+import (
+	"bytes"
+
+	"github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/common/x/linkedhashmap"
+)
+
+const Draft202012 = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema 2020-12 document or subschema.
+type Schema struct {
+	Schema                string     `json:"$schema,omitempty"`
+	ID                    string     `json:"$id,omitempty"`
+	Ref                   string     `json:"$ref,omitempty"`
+	Defs                  *SchemaMap `json:"$defs,omitempty"`
+	Title                 string     `json:"title,omitempty"`
+	Description           string     `json:"description,omitempty"`
+	Type                  string     `json:"type,omitempty"`
+	Format                string     `json:"format,omitempty"`
+	Pattern               string     `json:"pattern,omitempty"`
+	ContentEncoding       string     `json:"contentEncoding,omitempty"`
+	Enum                  []any      `json:"enum,omitempty"`
+	Const                 any        `json:"const,omitempty"`
+	Default               any        `json:"default,omitempty"`
+	Minimum               *float64   `json:"minimum,omitempty"`
+	Maximum               *float64   `json:"maximum,omitempty"`
+	Items                 *Schema    `json:"items,omitempty"`
+	MinItems              *int       `json:"minItems,omitempty"`
+	Properties            *SchemaMap `json:"properties,omitempty"`
+	Required              []string   `json:"required,omitempty"`
+	AdditionalProperties  *Schema    `json:"additionalProperties,omitempty"`
+	PropertyNames         *Schema    `json:"propertyNames,omitempty"`
+	UnevaluatedProperties *Schema    `json:"unevaluatedProperties,omitempty"`
+	AllOf                 []*Schema  `json:"allOf,omitempty"`
+	AnyOf                 []*Schema  `json:"anyOf,omitempty"`
+	OneOf                 []*Schema  `json:"oneOf,omitempty"`
+	If                    *Schema    `json:"if,omitempty"`
+	Then                  *Schema    `json:"then,omitempty"`
+	Else                  *Schema    `json:"else,omitempty"`
+	Not                   *Schema    `json:"not,omitempty"`
+	falseSchema           bool
+}
+
+// False returns the schema that matches nothing.
+func False() *Schema {
+	return &Schema{falseSchema: true}
+}
+
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	if s.falseSchema {
+		return []byte("false"), nil
+	}
+	type schema Schema
+	return json.Marshal((*schema)(s))
+}
+
+// SchemaMap is a map of schemas that keeps insertion order when marshalled.
+type SchemaMap struct {
+	linkedhashmap.Map[string, *Schema]
+}
+
+func (m *SchemaMap) MarshalJSON() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	buffer.WriteByte('{')
+	for i, entry := range m.Entries() {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		keyContent, err := json.Marshal(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(keyContent)
+		buffer.WriteByte(':')
+		valueContent, err := json.Marshal(entry.Value)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(valueContent)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,32 @@
+package json
+
+import "sort"
+
+// OffsetMap maps offsets in content rewritten into JSON, such as from JSON5 or YAML, back to the source it was rewritten from.
+type OffsetMap []offsetSegment
+
+type offsetSegment struct {
+	output int64
+	delta  int64
+}
+
+// Record maps the output byte at output to the source byte at source, and the bytes after it
+// to the bytes after source, until the next record.
+func (m *OffsetMap) Record(output int64, source int64) {
+	delta := source - output
+	segments := *m
+	if len(segments) == 0 && delta == 0 || len(segments) > 0 && segments[len(segments)-1].delta == delta {
+		return
+	}
+	*m = append(segments, offsetSegment{output, delta})
+}
+
+func (m OffsetMap) Source(offset int64) int64 {
+	index := sort.Search(len(m), func(i int) bool {
+		return m[i].output > offset
+	})
+	if index == 0 {
+		return offset
+	}
+	return offset + m[index-1].delta
+}
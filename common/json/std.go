@@ -18,4 +18,5 @@ type (
 	Delim       = json.Delim
 	SyntaxError = json.SyntaxError
 	RawMessage  = json.RawMessage
+	Number      = json.Number
 )
@@ -3,25 +3,140 @@ package json
 import (
 	"bytes"
 	"errors"
-	"strings"
+	"io"
+	"strconv"
 
 	"github.com/sagernet/sing/common"
-	E "github.com/sagernet/sing/common/exceptions"
 )
 
-func UnmarshalExtended[T any](content []byte) (T, error) {
-	decoder := NewDecoder(NewCommentFilter(bytes.NewReader(content)))
+// DecodeError is returned by UnmarshalExtended with the source position of the value that failed to decode.
+type DecodeError struct {
+	Err  error
+	Path string
+	// Offset is the byte offset of the value in the source, before comments were stripped.
+	Offset int64
+	Row    int
+	Column int
+	// Excerpt is the source line containing the value.
+	Excerpt string
+}
+
+func (e *DecodeError) Error() string {
+	return e.Err.Error() + ": row " + strconv.Itoa(e.Row) + ", column " + strconv.Itoa(e.Column)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+type ExtendedOption func(options *extendedOptions)
+
+type extendedOptions struct {
+	json5                 bool
+	disallowUnknownFields bool
+	source                []byte
+	offsets               OffsetMap
+}
+
+// JSON5 makes UnmarshalExtended accept JSON5: comments, trailing commas, unquoted keys,
+// single-quoted and multi-line strings, and hexadecimal numbers.
+func JSON5() ExtendedOption {
+	return func(options *extendedOptions) {
+		options.json5 = true
+	}
+}
+
+// DisallowUnknownFields makes UnmarshalExtended reject unknown fields like UnmarshalDisallowUnknownFields.
+func DisallowUnknownFields() ExtendedOption {
+	return func(options *extendedOptions) {
+		options.disallowUnknownFields = true
+	}
+}
+
+// Translated tells UnmarshalExtended that the content is plain JSON translated from source,
+// such as a YAML or TOML document, so errors are reported at their position in source.
+func Translated(source []byte, offsets OffsetMap) ExtendedOption {
+	return func(options *extendedOptions) {
+		options.source = source
+		options.offsets = offsets
+	}
+}
+
+func UnmarshalExtended[T any](content []byte, options ...ExtendedOption) (T, error) {
+	var extendedOptions extendedOptions
+	for _, option := range options {
+		option(&extendedOptions)
+	}
+	var (
+		reader       io.Reader
+		sourceOffset func(offset int64) int64
+	)
+	source := content
+	if extendedOptions.source != nil {
+		reader = bytes.NewReader(content)
+		source = extendedOptions.source
+		sourceOffset = extendedOptions.offsets.Source
+	} else if extendedOptions.json5 {
+		translated, offsets, err := translateJSON5(content)
+		if err != nil {
+			return common.DefaultValue[T](), err
+		}
+		reader = bytes.NewReader(translated)
+		sourceOffset = offsets.Source
+	} else {
+		filter := newOffsetCommentFilter(bytes.NewReader(content))
+		reader = filter
+		sourceOffset = filter.SourceOffset
+	}
+	decoder := NewDecoder(reader)
+	if extendedOptions.disallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
 	var value T
 	err := decoder.Decode(&value)
 	if err == nil {
 		return value, err
 	}
+	return common.DefaultValue[T](), newDecodeError(err, source, sourceOffset)
+}
+
+func newDecodeError(err error, content []byte, sourceOffset func(offset int64) int64) error {
+	var offset int64
 	var syntaxError *SyntaxError
 	if errors.As(err, &syntaxError) {
-		prefix := string(content[:syntaxError.Offset])
-		row := strings.Count(prefix, "\n") + 1
-		column := len(prefix) - strings.LastIndex(prefix, "\n") - 1
-		return common.DefaultValue[T](), E.Extend(syntaxError, "row ", row, ", column ", column)
+		// syntax error offsets point after the byte that caused the error
+		offset = syntaxError.Offset - 1
+	} else {
+		var loaded bool
+		offset, loaded = errorOffset(err)
+		if !loaded {
+			return err
+		}
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return NewDecodeError(err, errorPath(err), sourceOffset(offset), content)
+}
+
+// NewDecodeError returns a DecodeError for the value of path at offset in content.
+func NewDecodeError(err error, path string, offset int64, content []byte) *DecodeError {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	lineStart := bytes.LastIndexByte(content[:offset], '\n') + 1
+	lineEnd := bytes.IndexByte(content[offset:], '\n')
+	if lineEnd == -1 {
+		lineEnd = len(content)
+	} else {
+		lineEnd += int(offset)
+	}
+	return &DecodeError{
+		Err:     err,
+		Path:    path,
+		Offset:  offset,
+		Row:     bytes.Count(content[:offset], []byte{'\n'}) + 1,
+		Column:  int(offset) - lineStart + 1,
+		Excerpt: string(bytes.TrimRight(content[lineStart:lineEnd], "\r")),
 	}
-	return common.DefaultValue[T](), err
 }
@@ -7,3 +7,8 @@ import (
 )
 
 var UnmarshalDisallowUnknownFields = json.UnmarshalDisallowUnknownFields
+
+var (
+	errorOffset = json.ErrorOffset
+	errorPath   = json.ErrorPath
+)
@@ -0,0 +1,118 @@
+//go:build go1.20 && !without_contextjson
+
+package json_test
+
+import (
+	"testing"
+
+	"github.com/sagernet/sing/common/json"
+	"github.com/sagernet/sing/common/json/badoption"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testInbound struct {
+	Listen *badoption.Addr `json:"listen,omitempty"`
+	Port   uint16          `json:"port,omitempty"`
+}
+
+type testStrictOptions struct {
+	Name string `json:"name,omitempty"`
+}
+
+func (o *testStrictOptions) UnmarshalJSON(content []byte) error {
+	type options testStrictOptions
+	return json.UnmarshalDisallowUnknownFields(content, (*options)(o))
+}
+
+type testConfig struct {
+	Inbounds []testInbound     `json:"inbounds,omitempty"`
+	Strict   testStrictOptions `json:"strict,omitempty"`
+}
+
+func requireDecodeError(t *testing.T, content string, row int, column int, excerpt string) *json.DecodeError {
+	_, err := json.UnmarshalExtended[testConfig]([]byte(content))
+	require.Error(t, err)
+	var decodeError *json.DecodeError
+	require.ErrorAs(t, err, &decodeError)
+	require.Equal(t, row, decodeError.Row, err.Error())
+	require.Equal(t, column, decodeError.Column, err.Error())
+	require.Equal(t, excerpt, decodeError.Excerpt)
+	return decodeError
+}
+
+func TestUnmarshalExtendedCustomError(t *testing.T) {
+	t.Parallel()
+	decodeError := requireDecodeError(t, `{
+  // comment with "quotes" and { braces
+  "inbounds": [
+    /* block
+       comment */ {"listen": "::"},
+    {"listen": "bad"} # trailing
+  ]
+}`, 6, 16, `    {"listen": "bad"} # trailing`)
+	require.Equal(t, "inbounds[1].listen", decodeError.Path)
+}
+
+func TestUnmarshalExtendedTypeMismatch(t *testing.T) {
+	t.Parallel()
+	decodeError := requireDecodeError(t, `{
+  "inbounds": [{"port": "80"}] // port
+}`, 2, 25, `  "inbounds": [{"port": "80"}] // port`)
+	require.Equal(t, "inbounds[0].port", decodeError.Path)
+}
+
+func TestUnmarshalExtendedUnknownField(t *testing.T) {
+	t.Parallel()
+	decodeError := requireDecodeError(t, `{
+  /* strict */ "strict": {
+    "name": "a", "nmae": "b"
+  }
+}`, 3, 18, `    "name": "a", "nmae": "b"`)
+	require.Equal(t, "strict.nmae", decodeError.Path)
+}
+
+func TestUnmarshalExtendedSyntaxError(t *testing.T) {
+	t.Parallel()
+	requireDecodeError(t, `{
+  // comment
+  "inbounds": [}
+}`, 3, 16, `  "inbounds": [}`)
+}
+
+func TestUnmarshalExtendedJSON5Positions(t *testing.T) {
+	t.Parallel()
+	_, err := json.UnmarshalExtended[testConfig]([]byte(`{
+  // comment
+  inbounds: [
+    {listen: '::', port: 0x50,},
+    {port: 80, listen: 'bad'},
+  ],
+}`), json.JSON5())
+	var decodeError *json.DecodeError
+	require.ErrorAs(t, err, &decodeError)
+	require.Equal(t, 5, decodeError.Row)
+	require.Equal(t, 24, decodeError.Column)
+	require.Equal(t, "inbounds[1].listen", decodeError.Path)
+
+	_, err = json.UnmarshalExtended[testConfig]([]byte(`{
+  inbounds: [{port: 'a'}],
+}`), json.JSON5())
+	require.ErrorAs(t, err, &decodeError)
+	require.Equal(t, 2, decodeError.Row)
+	require.Equal(t, 21, decodeError.Column)
+
+	_, err = json.UnmarshalExtended[testConfig]([]byte(`{
+  inbounds: [{port: NaN}],
+}`), json.JSON5())
+	require.ErrorAs(t, err, &decodeError)
+	require.Equal(t, 2, decodeError.Row)
+	require.Equal(t, 21, decodeError.Column)
+
+	_, err = json.UnmarshalExtended[testConfig]([]byte(`{
+  inbounds: [{port: 1} {port: 2}],
+}`), json.JSON5())
+	require.ErrorAs(t, err, &decodeError)
+	require.Equal(t, 2, decodeError.Row)
+	require.Equal(t, 24, decodeError.Column)
+}
@@ -4,6 +4,8 @@ package json
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 )
 
 func UnmarshalDisallowUnknownFields(content []byte, value any) error {
@@ -11,3 +13,19 @@ func UnmarshalDisallowUnknownFields(content []byte, value any) error {
 	decoder.DisallowUnknownFields()
 	return decoder.Decode(value)
 }
+
+func errorOffset(err error) (int64, bool) {
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		return typeError.Offset, true
+	}
+	return 0, false
+}
+
+func errorPath(err error) string {
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		return typeError.Field
+	}
+	return ""
+}
@@ -1,6 +1,25 @@
 package metadata
 
-import _ "unsafe" // for linkname
+import (
+	_ "unsafe" // for linkname
+
+	"github.com/sagernet/sing/common/idna"
+)
 
 //go:linkname IsDomainName net.isDomainName
 func IsDomainName(domain string) bool
+
+// NormalizeFqdn returns the address with its domain name mapped to the lower-case ASCII form of
+// UTS #46, or an error unwrapping to idna.ErrInvalidDomain if the domain name is invalid.
+// Addresses without a domain name are returned unchanged.
+func NormalizeFqdn(ap Socksaddr) (Socksaddr, error) {
+	if ap.Fqdn == "" {
+		return ap, nil
+	}
+	domain, err := idna.ToASCII(ap.Fqdn)
+	if err != nil {
+		return ap, err
+	}
+	ap.Fqdn = domain
+	return ap, nil
+}
@@ -0,0 +1,45 @@
+package metadata_test
+
+import (
+	"testing"
+
+	"github.com/sagernet/sing/common/idna"
+	M "github.com/sagernet/sing/common/metadata"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeFqdn(t *testing.T) {
+	t.Parallel()
+	for input, expected := range map[string]string{
+		"example.com:443":       "example.com:443",
+		"WWW.Example.COM:443":   "www.example.com:443",
+		"münchen.de:443":        "xn--mnchen-3ya.de:443",
+		"xn--mnchen-3ya.de:443": "xn--mnchen-3ya.de:443",
+		"XN--MNCHEN-3YA.de:443": "xn--mnchen-3ya.de:443",
+		"例え.テスト:80":             "xn--r8jz45g.xn--zckzah:80",
+		"1.1.1.1:53":            "1.1.1.1:53",
+		"[::1]:53":              "[::1]:53",
+	} {
+		destination, err := M.NormalizeFqdn(M.ParseSocksaddr(input))
+		require.NoError(t, err, input)
+		require.Equal(t, expected, destination.String(), input)
+	}
+	destination, err := M.NormalizeFqdn(M.ParseSocksaddr("xn--mnchen-3ya.de:443"))
+	require.NoError(t, err)
+	unicodeDomain, err := idna.ToUnicode(destination.Fqdn)
+	require.NoError(t, err)
+	require.Equal(t, "münchen.de", unicodeDomain)
+	for _, input := range []string{
+		"xn--example.com",
+		"-example.com",
+		"a..b",
+		"exa mple.com",
+		"a\u200db.com",
+	} {
+		destination := M.Socksaddr{Fqdn: input, Port: 443}
+		normalized, err := M.NormalizeFqdn(destination)
+		require.ErrorIs(t, err, idna.ErrInvalidDomain, input)
+		require.Equal(t, destination, normalized, input)
+	}
+}
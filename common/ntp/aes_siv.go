@@ -0,0 +1,134 @@
+package ntp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// aesSIV implements AEAD_AES_SIV_CMAC_256 (RFC 5297), the mandatory NTS algorithm.
+type aesSIV struct {
+	mac cipher.Block
+	ctr cipher.Block
+}
+
+const aesSIVKeySize = 32
+
+func newAESSIV(key []byte) (*aesSIV, error) {
+	if len(key) != aesSIVKeySize {
+		return nil, E.New("bad AES-SIV key length: ", len(key))
+	}
+	mac, err := aes.NewCipher(key[:16])
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := aes.NewCipher(key[16:])
+	if err != nil {
+		return nil, err
+	}
+	return &aesSIV{mac, ctr}, nil
+}
+
+// seal returns the synthetic IV followed by the ciphertext. The nonce is the last associated data component.
+func (s *aesSIV) seal(nonce []byte, plaintext []byte, associatedData ...[]byte) []byte {
+	v := s.s2v(append(associatedData, nonce), plaintext)
+	output := make([]byte, aes.BlockSize+len(plaintext))
+	copy(output, v[:])
+	s.xorCTR(v, output[aes.BlockSize:], plaintext)
+	return output
+}
+
+func (s *aesSIV) open(nonce []byte, ciphertext []byte, associatedData ...[]byte) ([]byte, error) {
+	if len(ciphertext) < aes.BlockSize {
+		return nil, E.New("AES-SIV: ciphertext too short")
+	}
+	var v [aes.BlockSize]byte
+	copy(v[:], ciphertext)
+	plaintext := make([]byte, len(ciphertext)-aes.BlockSize)
+	s.xorCTR(v, plaintext, ciphertext[aes.BlockSize:])
+	expected := s.s2v(append(associatedData, nonce), plaintext)
+	if subtle.ConstantTimeCompare(expected[:], v[:]) != 1 {
+		return nil, E.New("AES-SIV: authentication failed")
+	}
+	return plaintext, nil
+}
+
+func (s *aesSIV) xorCTR(v [aes.BlockSize]byte, dst []byte, src []byte) {
+	v[8] &= 0x7f
+	v[12] &= 0x7f
+	cipher.NewCTR(s.ctr, v[:]).XORKeyStream(dst, src)
+}
+
+func (s *aesSIV) s2v(associatedData [][]byte, plaintext []byte) [aes.BlockSize]byte {
+	var zero [aes.BlockSize]byte
+	d := s.cmac(zero[:])
+	for _, data := range associatedData {
+		d = dbl(d)
+		xorBlock(&d, s.cmac(data))
+	}
+	if len(plaintext) >= aes.BlockSize {
+		t := make([]byte, len(plaintext))
+		copy(t, plaintext)
+		for i := 0; i < aes.BlockSize; i++ {
+			t[len(t)-aes.BlockSize+i] ^= d[i]
+		}
+		return s.cmac(t)
+	}
+	d = dbl(d)
+	var padded [aes.BlockSize]byte
+	copy(padded[:], plaintext)
+	padded[len(plaintext)] = 0x80
+	xorBlock(&d, padded)
+	return s.cmac(d[:])
+}
+
+// cmac implements AES-CMAC (RFC 4493).
+func (s *aesSIV) cmac(message []byte) [aes.BlockSize]byte {
+	var l [aes.BlockSize]byte
+	s.mac.Encrypt(l[:], l[:])
+	k1 := dbl(l)
+	var last [aes.BlockSize]byte
+	blocks := (len(message) + aes.BlockSize - 1) / aes.BlockSize
+	if blocks > 0 && len(message)%aes.BlockSize == 0 {
+		copy(last[:], message[(blocks-1)*aes.BlockSize:])
+		xorBlock(&last, k1)
+	} else {
+		if blocks == 0 {
+			blocks = 1
+		}
+		remaining := message[(blocks-1)*aes.BlockSize:]
+		copy(last[:], remaining)
+		last[len(remaining)] = 0x80
+		xorBlock(&last, dbl(k1))
+	}
+	var x [aes.BlockSize]byte
+	for i := 0; i < blocks-1; i++ {
+		var block [aes.BlockSize]byte
+		copy(block[:], message[i*aes.BlockSize:])
+		xorBlock(&x, block)
+		s.mac.Encrypt(x[:], x[:])
+	}
+	xorBlock(&x, last)
+	s.mac.Encrypt(x[:], x[:])
+	return x
+}
+
+func dbl(block [aes.BlockSize]byte) [aes.BlockSize]byte {
+	var result [aes.BlockSize]byte
+	for i := 0; i < aes.BlockSize-1; i++ {
+		result[i] = block[i]<<1 | block[i+1]>>7
+	}
+	result[aes.BlockSize-1] = block[aes.BlockSize-1] << 1
+	if block[0]&0x80 != 0 {
+		result[aes.BlockSize-1] ^= 0x87
+	}
+	return result
+}
+
+func xorBlock(dst *[aes.BlockSize]byte, src [aes.BlockSize]byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
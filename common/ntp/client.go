@@ -0,0 +1,332 @@
+package ntp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing/common/atomic"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/logger"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	aTLS "github.com/sagernet/sing/common/tls"
+)
+
+type ServerOptions struct {
+	// Address is the NTP server, or the NTS-KE server if NTS is enabled. The default port is 123, or 4460 for NTS-KE.
+	Address M.Socksaddr
+	NTS     bool
+	// TLSConfig is the NTS-KE client configuration; ALPN is set by the client.
+	TLSConfig aTLS.Config
+}
+
+type ClientOptions struct {
+	Dialer  N.Dialer
+	Logger  logger.Logger
+	Servers []ServerOptions
+	Timeout time.Duration
+}
+
+type PeerStatus uint8
+
+const (
+	PeerStatusUnreachable PeerStatus = iota
+	// PeerStatusFalseticker is a peer outside the interval agreed by the majority.
+	PeerStatusFalseticker
+	// PeerStatusOutlier is a truechimer discarded by the cluster algorithm.
+	PeerStatusOutlier
+	PeerStatusCandidate
+	PeerStatusSystemPeer
+)
+
+func (s PeerStatus) String() string {
+	switch s {
+	case PeerStatusUnreachable:
+		return "unreachable"
+	case PeerStatusFalseticker:
+		return "falseticker"
+	case PeerStatusOutlier:
+		return "outlier"
+	case PeerStatusCandidate:
+		return "candidate"
+	case PeerStatusSystemPeer:
+		return "system peer"
+	default:
+		return "unknown"
+	}
+}
+
+type PeerStatistics struct {
+	Server       M.Socksaddr
+	NTS          bool
+	Status       PeerStatus
+	Reach        uint8
+	Stratum      uint8
+	Offset       time.Duration
+	Delay        time.Duration
+	Dispersion   time.Duration
+	Jitter       time.Duration
+	RootDistance time.Duration
+	LastError    error
+}
+
+type Statistics struct {
+	Time           time.Time
+	Offset         time.Duration
+	Jitter         time.Duration
+	RootDelay      time.Duration
+	RootDispersion time.Duration
+	Stratum        uint8
+	Leap           LeapIndicator
+	SystemPeer     M.Socksaddr
+	Peers          []PeerStatistics
+}
+
+// Client polls a set of servers and combines their answers with the RFC 5905 clock filter,
+// selection, cluster and combine algorithms. A single server is used directly.
+type Client struct {
+	dialer     N.Dialer
+	logger     logger.Logger
+	timeout    time.Duration
+	access     sync.Mutex
+	peers      []*peer
+	statistics atomic.Pointer[Statistics]
+}
+
+type peer struct {
+	options        ServerOptions
+	session        *ntsSession
+	filter         clockFilter
+	stratum        uint8
+	leap           LeapIndicator
+	rootDelay      time.Duration
+	rootDispersion time.Duration
+	lastError      error
+}
+
+func NewClient(options ClientOptions) (*Client, error) {
+	if len(options.Servers) == 0 {
+		return nil, E.New("missing servers")
+	}
+	client := &Client{
+		dialer:  options.Dialer,
+		logger:  options.Logger,
+		timeout: options.Timeout,
+	}
+	if client.dialer == nil {
+		client.dialer = N.SystemDialer
+	}
+	if client.logger == nil {
+		client.logger = logger.NOP()
+	}
+	if client.timeout <= 0 {
+		client.timeout = defaultTimeout
+	}
+	for _, server := range options.Servers {
+		if !server.Address.IsValid() {
+			return nil, E.New("invalid server address: ", server.Address)
+		}
+		if server.Address.Port == 0 && !server.NTS {
+			server.Address.Port = 123
+		}
+		client.peers = append(client.peers, &peer{options: server})
+	}
+	return client, nil
+}
+
+// Update polls every server once and returns the new system statistics.
+func (c *Client) Update(ctx context.Context) (*Statistics, error) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	var group sync.WaitGroup
+	for _, p := range c.peers {
+		group.Add(1)
+		go func(p *peer) {
+			defer group.Done()
+			p.lastError = p.poll(ctx, c.dialer, c.timeout)
+			if p.lastError != nil {
+				p.filter.miss()
+				c.logger.Debug("poll ", p.options.Address, ": ", p.lastError)
+			}
+		}(p)
+	}
+	group.Wait()
+	var (
+		statistics *Statistics
+		err        error
+	)
+	if len(c.peers) == 1 {
+		statistics, err = c.singlePeer(time.Now())
+	} else {
+		statistics, err = c.selectPeers(time.Now())
+	}
+	c.statistics.Store(statistics)
+	return statistics, err
+}
+
+// Statistics returns the result of the last update, or nil.
+func (c *Client) Statistics() *Statistics {
+	return c.statistics.Load()
+}
+
+func (c *Client) selectPeers(now time.Time) (*Statistics, error) {
+	statistics := &Statistics{
+		Time:  now,
+		Leap:  LeapNotInSync,
+		Peers: make([]PeerStatistics, len(c.peers)),
+	}
+	var candidates []*selectCandidate
+	for i, p := range c.peers {
+		peerStatistics := &statistics.Peers[i]
+		*peerStatistics = PeerStatistics{
+			Server:    p.options.Address,
+			NTS:       p.options.NTS,
+			Reach:     p.filter.reach,
+			Stratum:   p.stratum,
+			LastError: p.lastError,
+		}
+		if !p.filter.valid() {
+			continue
+		}
+		distance := p.rootDistance(now)
+		peerStatistics.Offset = p.filter.offset
+		peerStatistics.Delay = p.filter.delay
+		peerStatistics.Dispersion = p.filter.dispersion
+		peerStatistics.Jitter = p.filter.jitter
+		peerStatistics.RootDistance = distance
+		if p.leap == LeapNotInSync || p.stratum >= maxStratum || distance > maxDistance {
+			continue
+		}
+		peerStatistics.Status = PeerStatusFalseticker
+		candidates = append(candidates, &selectCandidate{
+			peer:     p,
+			offset:   p.filter.offset,
+			distance: distance,
+			jitter:   p.filter.jitter,
+			stratum:  p.stratum,
+		})
+	}
+	if len(candidates) == 0 {
+		return statistics, E.New("no usable servers")
+	}
+	truechimers, err := selectTruechimers(candidates)
+	if err != nil {
+		return statistics, err
+	}
+	survivors, outliers := clusterSurvivors(truechimers)
+	for i, p := range c.peers {
+		for _, candidate := range survivors {
+			if candidate.peer == p {
+				statistics.Peers[i].Status = PeerStatusCandidate
+			}
+		}
+		for _, candidate := range outliers {
+			if candidate.peer == p {
+				statistics.Peers[i].Status = PeerStatusOutlier
+			}
+		}
+	}
+	systemPeer := survivors[0].peer
+	for i, p := range c.peers {
+		if p == systemPeer {
+			statistics.Peers[i].Status = PeerStatusSystemPeer
+		}
+	}
+	statistics.Offset, statistics.Jitter = combineOffsets(survivors)
+	statistics.SystemPeer = systemPeer.options.Address
+	statistics.Stratum = systemPeer.stratum + 1
+	statistics.Leap = systemPeer.leap
+	statistics.RootDelay = systemPeer.rootDelay + systemPeer.filter.delay
+	statistics.RootDispersion = systemPeer.rootDispersion + systemPeer.filter.dispersion + statistics.Jitter + agedDispersion(now.Sub(systemPeer.filter.updated))
+	if statistics.Offset < 0 {
+		statistics.RootDispersion -= statistics.Offset
+	} else {
+		statistics.RootDispersion += statistics.Offset
+	}
+	return statistics, nil
+}
+
+// singlePeer uses the latest sample of the only server like a plain exchange, as the selection
+// thresholds would reject slow or distant servers without giving a better answer.
+func (c *Client) singlePeer(now time.Time) (*Statistics, error) {
+	p := c.peers[0]
+	statistics := &Statistics{
+		Time: now,
+		Leap: LeapNotInSync,
+		Peers: []PeerStatistics{{
+			Server:    p.options.Address,
+			NTS:       p.options.NTS,
+			Reach:     p.filter.reach,
+			Stratum:   p.stratum,
+			LastError: p.lastError,
+		}},
+	}
+	if p.lastError != nil {
+		return statistics, p.lastError
+	}
+	sample := p.filter.samples[0]
+	peerStatistics := &statistics.Peers[0]
+	peerStatistics.Status = PeerStatusSystemPeer
+	peerStatistics.Offset = sample.offset
+	peerStatistics.Delay = sample.delay
+	peerStatistics.Dispersion = p.filter.dispersion
+	peerStatistics.Jitter = p.filter.jitter
+	peerStatistics.RootDistance = p.rootDistance(now)
+	statistics.Offset = sample.offset
+	statistics.Jitter = p.filter.jitter
+	statistics.SystemPeer = p.options.Address
+	statistics.Stratum = p.stratum + 1
+	statistics.Leap = p.leap
+	statistics.RootDelay = p.rootDelay + sample.delay
+	statistics.RootDispersion = p.rootDispersion + sample.dispersion
+	return statistics, nil
+}
+
+func (p *peer) poll(ctx context.Context, dialer N.Dialer, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	server := p.options.Address
+	if p.options.NTS {
+		if p.session == nil || !p.session.hasCookie() {
+			session, err := ntsKeyEstablishment(ctx, dialer, server, p.options.TLSConfig)
+			if err != nil {
+				return err
+			}
+			p.session = session
+		}
+		server = p.session.server
+	}
+	response, err := exchange(ctx, dialer, server, p.session)
+	if errors.Is(err, errNTSNAK) {
+		p.session = nil
+	}
+	if err != nil {
+		return err
+	}
+	err = response.Validate()
+	if err != nil {
+		return err
+	}
+	p.stratum = response.Stratum
+	p.leap = response.Leap
+	p.rootDelay = response.RootDelay
+	p.rootDispersion = response.RootDispersion
+	p.filter.add(&clockSample{
+		offset:     response.ClockOffset,
+		delay:      response.RTT,
+		dispersion: response.Precision + localPrecision + agedDispersion(response.RTT),
+		time:       time.Now(),
+	})
+	return nil
+}
+
+// rootDistance is the synchronization distance of the peer, lambda in RFC 5905.
+func (p *peer) rootDistance(now time.Time) time.Duration {
+	delay := p.rootDelay + p.filter.delay
+	if delay < minDispersion {
+		delay = minDispersion
+	}
+	return delay/2 + p.rootDispersion + p.filter.dispersion + agedDispersion(now.Sub(p.filter.updated)) + p.filter.jitter
+}
@@ -0,0 +1,338 @@
+package ntp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	M "github.com/sagernet/sing/common/metadata"
+	aTLS "github.com/sagernet/sing/common/tls"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAESSIV(t *testing.T) {
+	t.Parallel()
+	// RFC 5297 appendix A.1
+	key, _ := hex.DecodeString("fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+	associatedData, _ := hex.DecodeString("101112131415161718191a1b1c1d1e1f2021222324252627")
+	plaintext, _ := hex.DecodeString("112233445566778899aabbccddee")
+	expected, _ := hex.DecodeString("85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c")
+	siv, err := newAESSIV(key)
+	require.NoError(t, err)
+	v := siv.s2v([][]byte{associatedData}, plaintext)
+	output := make([]byte, len(plaintext))
+	siv.xorCTR(v, output, plaintext)
+	require.Equal(t, expected, append(v[:], output...))
+
+	nonce := []byte("0123456789abcdef")
+	sealed := siv.seal(nonce, plaintext, associatedData)
+	opened, err := siv.open(nonce, sealed, associatedData)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, opened)
+	sealed[len(sealed)-1] ^= 1
+	_, err = siv.open(nonce, sealed, associatedData)
+	require.Error(t, err)
+}
+
+type testNTPServer struct {
+	conn           net.PacketConn
+	offset         time.Duration
+	rootDispersion atomic.Uint32
+	// handleNTS appends the NTS extension fields to the response header
+	handleNTS func(request []byte, header []byte) []byte
+	tamper    atomic.Bool
+}
+
+func startTestNTPServer(t *testing.T, offset time.Duration, handleNTS func(request []byte, header []byte) []byte) *testNTPServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	server := &testNTPServer{conn: conn, offset: offset, handleNTS: handleNTS}
+	go server.loop()
+	return server
+}
+
+func (s *testNTPServer) address() M.Socksaddr {
+	return M.SocksaddrFromNet(s.conn.LocalAddr())
+}
+
+func (s *testNTPServer) loop() {
+	buffer := make([]byte, 4096)
+	for {
+		n, addr, err := s.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		if n < ntpHeaderLen {
+			continue
+		}
+		var request msg
+		binary.Read(bytes.NewReader(buffer[:ntpHeaderLen]), binary.BigEndian, &request)
+		now := time.Now().Add(s.offset)
+		response := msg{
+			Stratum:        1,
+			Precision:      -20,
+			RootDispersion: ntpTimeShort(s.rootDispersion.Load()),
+			ReferenceID:    0x47505300,
+			ReferenceTime:  toNtpTime(now.Add(-time.Second)),
+			OriginTime:     request.TransmitTime,
+			ReceiveTime:    toNtpTime(now),
+			TransmitTime:   toNtpTime(now),
+		}
+		response.setMode(server)
+		response.setVersion(defaultNtpVersion)
+		response.setLeap(LeapNoWarning)
+		packetBuffer := bytes.NewBuffer(nil)
+		binary.Write(packetBuffer, binary.BigEndian, response)
+		packet := packetBuffer.Bytes()
+		if s.handleNTS != nil {
+			packet = s.handleNTS(buffer[:n], packet)
+			if packet == nil {
+				continue
+			}
+		}
+		if s.tamper.Load() {
+			packet[len(packet)-1] ^= 1
+		}
+		s.conn.WriteTo(packet, addr)
+	}
+}
+
+func TestClientFalseticker(t *testing.T) {
+	t.Parallel()
+	var servers []ServerOptions
+	for _, offset := range []time.Duration{time.Second, time.Second, time.Second, time.Minute} {
+		servers = append(servers, ServerOptions{Address: startTestNTPServer(t, offset, nil).address()})
+	}
+	client, err := NewClient(ClientOptions{Servers: servers, Timeout: time.Second})
+	require.NoError(t, err)
+	var statistics *Statistics
+	for i := 0; i < 3; i++ {
+		statistics, err = client.Update(context.Background())
+		require.NoError(t, err)
+	}
+	require.Equal(t, statistics, client.Statistics())
+	require.InDelta(t, time.Second, statistics.Offset, float64(50*time.Millisecond))
+	require.Equal(t, uint8(2), statistics.Stratum)
+	require.Equal(t, LeapIndicator(LeapNoWarning), statistics.Leap)
+	require.Equal(t, PeerStatusFalseticker, statistics.Peers[3].Status)
+	require.Equal(t, uint8(0b111), statistics.Peers[3].Reach)
+	for _, peerStatistics := range statistics.Peers[:3] {
+		require.Contains(t, []PeerStatus{PeerStatusCandidate, PeerStatusSystemPeer}, peerStatistics.Status)
+	}
+}
+
+func TestClientSingleServer(t *testing.T) {
+	t.Parallel()
+	server := startTestNTPServer(t, time.Second, nil)
+	// beyond the selection threshold, but accepted by a plain exchange
+	server.rootDispersion.Store(2 << 16)
+	client, err := NewClient(ClientOptions{Servers: []ServerOptions{{Address: server.address()}}, Timeout: time.Second})
+	require.NoError(t, err)
+	statistics, err := client.Update(context.Background())
+	require.NoError(t, err)
+	require.InDelta(t, time.Second, statistics.Offset, float64(50*time.Millisecond))
+	require.Equal(t, PeerStatusSystemPeer, statistics.Peers[0].Status)
+	require.Equal(t, server.address(), statistics.SystemPeer)
+	require.Greater(t, statistics.RootDispersion, 2*time.Second-time.Millisecond)
+
+	server.conn.Close()
+	_, err = client.Update(context.Background())
+	require.Error(t, err)
+}
+
+func TestClientUnreachable(t *testing.T) {
+	t.Parallel()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	client, err := NewClient(ClientOptions{
+		Servers: []ServerOptions{
+			{Address: startTestNTPServer(t, -time.Second, nil).address()},
+			{Address: M.SocksaddrFromNet(conn.LocalAddr())},
+		},
+		Timeout: 200 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	statistics, err := client.Update(context.Background())
+	require.NoError(t, err)
+	require.InDelta(t, -time.Second, statistics.Offset, float64(50*time.Millisecond))
+	require.Equal(t, PeerStatusSystemPeer, statistics.Peers[0].Status)
+	require.Equal(t, PeerStatusUnreachable, statistics.Peers[1].Status)
+	require.Error(t, statistics.Peers[1].LastError)
+}
+
+type testTLSConfig struct {
+	config *tls.Config
+}
+
+func (c *testTLSConfig) ServerName() string {
+	return c.config.ServerName
+}
+
+func (c *testTLSConfig) SetServerName(serverName string) {
+	c.config.ServerName = serverName
+}
+
+func (c *testTLSConfig) NextProtos() []string {
+	return c.config.NextProtos
+}
+
+func (c *testTLSConfig) SetNextProtos(nextProto []string) {
+	c.config.NextProtos = nextProto
+}
+
+func (c *testTLSConfig) Config() (*aTLS.STDConfig, error) {
+	return c.config, nil
+}
+
+func (c *testTLSConfig) Client(conn net.Conn) (aTLS.Conn, error) {
+	return tls.Client(conn, c.config), nil
+}
+
+func (c *testTLSConfig) Clone() aTLS.Config {
+	return &testTLSConfig{c.config.Clone()}
+}
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{certificate}, PrivateKey: key}
+}
+
+// startTestNTSServer runs an NTS-KE server whose cookie holds the session keys, which keeps the NTP side stateless.
+func startTestNTSServer(t *testing.T, ntpServer *testNTPServer) M.Socksaddr {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{testCertificate(t)},
+		NextProtos:   []string{ntsKEALPN},
+		MinVersion:   tls.VersionTLS13,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	ntpAddress := ntpServer.address()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			tlsConn := conn.(*tls.Conn)
+			for {
+				recordType, _, _, err := readNTSRecord(tlsConn)
+				if err != nil || recordType == ntsRecordEndOfMessage {
+					break
+				}
+			}
+			state := tlsConn.ConnectionState()
+			c2sKey, _ := state.ExportKeyingMaterial(ntsExporter, []byte{0, 0, 0, byte(ntsAEADAESSIVCMAC256), 0}, aesSIVKeySize)
+			s2cKey, _ := state.ExportKeyingMaterial(ntsExporter, []byte{0, 0, 0, byte(ntsAEADAESSIVCMAC256), 1}, aesSIVKeySize)
+			var response []byte
+			response = appendNTSRecord(response, true, ntsRecordNextProtocol, binary.BigEndian.AppendUint16(nil, ntsProtocolNTPv4))
+			response = appendNTSRecord(response, true, ntsRecordAEADAlgorithm, binary.BigEndian.AppendUint16(nil, ntsAEADAESSIVCMAC256))
+			for i := 0; i < ntsMaxCookies; i++ {
+				response = appendNTSRecord(response, false, ntsRecordNewCookie, append(append([]byte(nil), c2sKey...), s2cKey...))
+			}
+			response = appendNTSRecord(response, false, ntsRecordServer, []byte(ntpAddress.AddrString()))
+			response = appendNTSRecord(response, true, ntsRecordPort, binary.BigEndian.AppendUint16(nil, ntpAddress.Port))
+			response = appendNTSRecord(response, true, ntsRecordEndOfMessage, nil)
+			tlsConn.Write(response)
+			tlsConn.Close()
+		}
+	}()
+	return M.SocksaddrFromNet(listener.Addr())
+}
+
+func handleTestNTS(request []byte, header []byte) []byte {
+	var (
+		uniqueID   []byte
+		cookie     []byte
+		authorized bool
+	)
+	for offset := ntpHeaderLen; offset < len(request); {
+		fieldType, body, next, err := readExtensionField(request, offset)
+		if err != nil {
+			return nil
+		}
+		switch fieldType {
+		case extensionUniqueIdentifier:
+			uniqueID = body
+		case extensionNTSCookie:
+			cookie = body
+		case extensionNTSAuthenticator:
+			if len(cookie) != 2*aesSIVKeySize {
+				return nil
+			}
+			c2s, _ := newAESSIV(cookie[:aesSIVKeySize])
+			nonce, ciphertext, err := decodeAuthenticator(body)
+			if err != nil {
+				return nil
+			}
+			_, err = c2s.open(nonce, ciphertext, request[:offset])
+			if err != nil {
+				return nil
+			}
+			authorized = true
+		}
+		offset = next
+	}
+	if !authorized {
+		return nil
+	}
+	s2c, _ := newAESSIV(cookie[aesSIVKeySize:])
+	packet := appendExtensionField(header, extensionUniqueIdentifier, uniqueID)
+	plaintext := appendExtensionField(nil, extensionNTSCookie, cookie)
+	nonce := make([]byte, ntsNonceLen)
+	rand.Read(nonce)
+	return appendExtensionField(packet, extensionNTSAuthenticator, encodeAuthenticator(nonce, s2c.seal(nonce, plaintext, packet)))
+}
+
+func TestClientNTS(t *testing.T) {
+	t.Parallel()
+	ntpServer := startTestNTPServer(t, 2*time.Second, handleTestNTS)
+	keAddress := startTestNTSServer(t, ntpServer)
+	client, err := NewClient(ClientOptions{
+		Servers: []ServerOptions{{
+			Address:   keAddress,
+			NTS:       true,
+			TLSConfig: &testTLSConfig{&tls.Config{InsecureSkipVerify: true}},
+		}},
+		Timeout: time.Second,
+	})
+	require.NoError(t, err)
+	for i := 0; i < ntsMaxCookies+2; i++ {
+		statistics, err := client.Update(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, statistics.Peers[0].LastError)
+		require.InDelta(t, 2*time.Second, statistics.Offset, float64(50*time.Millisecond))
+	}
+	require.Len(t, client.peers[0].session.cookies, ntsMaxCookies)
+
+	// a response that fails authentication must not be accepted
+	ntpServer.tamper.Store(true)
+	statistics, err := client.Update(context.Background())
+	require.Error(t, err)
+	require.Error(t, statistics.Peers[0].LastError)
+}
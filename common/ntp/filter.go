@@ -0,0 +1,240 @@
+package ntp
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+// Clock filter and system process algorithms from RFC 5905 appendix A.5.
+
+const (
+	filterStages       = 8
+	frequencyTolerance = 15e-6 // PHI, s/s
+	minDispersion      = 5 * time.Millisecond
+	maxDistance        = 1500 * time.Millisecond
+	minClusterPeers    = 3
+	localPrecision     = time.Second >> 20
+)
+
+type clockSample struct {
+	offset     time.Duration
+	delay      time.Duration
+	dispersion time.Duration
+	time       time.Time
+}
+
+// clockFilter is the per-peer shift register of the most recent samples.
+type clockFilter struct {
+	samples [filterStages]*clockSample
+	reach   uint8
+
+	offset     time.Duration
+	delay      time.Duration
+	dispersion time.Duration
+	jitter     time.Duration
+	updated    time.Time
+}
+
+func (f *clockFilter) add(sample *clockSample) {
+	f.reach = f.reach<<1 | 1
+	copy(f.samples[1:], f.samples[:filterStages-1])
+	f.samples[0] = sample
+	f.update(sample.time)
+}
+
+func (f *clockFilter) miss() {
+	f.reach <<= 1
+	copy(f.samples[1:], f.samples[:filterStages-1])
+	f.samples[0] = nil
+}
+
+func (f *clockFilter) valid() bool {
+	return f.reach != 0 && !f.updated.IsZero()
+}
+
+// update selects the sample with the lowest delay and computes peer dispersion and jitter.
+func (f *clockFilter) update(now time.Time) {
+	type agedSample struct {
+		*clockSample
+		dispersion time.Duration
+	}
+	samples := make([]agedSample, 0, filterStages)
+	for _, sample := range f.samples {
+		if sample == nil {
+			continue
+		}
+		samples = append(samples, agedSample{sample, sample.dispersion + agedDispersion(now.Sub(sample.time))})
+	}
+	if len(samples) == 0 {
+		return
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].delay < samples[j].delay
+	})
+	f.offset = samples[0].offset
+	f.delay = samples[0].delay
+	// unlike RFC 5905, empty stages are not counted as MAXDISP, so that a peer is usable after its
+	// first sample instead of after several poll intervals
+	var dispersion, weight, jitter float64
+	for i, sample := range samples {
+		offsetDifference := (sample.offset - samples[0].offset).Seconds()
+		jitter += offsetDifference * offsetDifference
+		dispersion += sample.dispersion.Seconds() / math.Exp2(float64(i+1))
+		weight += 1 / math.Exp2(float64(i+1))
+	}
+	f.dispersion = durationFromSeconds(dispersion / weight)
+	if len(samples) > 1 {
+		f.jitter = durationFromSeconds(math.Sqrt(jitter / float64(len(samples)-1)))
+	} else {
+		f.jitter = 0
+	}
+	if f.jitter < localPrecision {
+		f.jitter = localPrecision
+	}
+	f.updated = samples[0].time
+}
+
+type selectCandidate struct {
+	peer     *peer
+	offset   time.Duration
+	distance time.Duration
+	jitter   time.Duration
+	stratum  uint8
+}
+
+// selectTruechimers runs the intersection algorithm and returns the candidates whose offset lies in
+// the interval agreed by a majority.
+func selectTruechimers(candidates []*selectCandidate) ([]*selectCandidate, error) {
+	type endpoint struct {
+		value    time.Duration
+		edgeType int
+	}
+	endpoints := make([]endpoint, 0, len(candidates)*3)
+	for _, candidate := range candidates {
+		endpoints = append(endpoints,
+			endpoint{candidate.offset - candidate.distance, -1},
+			endpoint{candidate.offset, 0},
+			endpoint{candidate.offset + candidate.distance, 1},
+		)
+	}
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		return endpoints[i].value < endpoints[j].value
+	})
+	n := len(candidates)
+	var low, high time.Duration
+	allow := 0
+	for ; 2*allow < n; allow++ {
+		found := 0
+		chime := 0
+		for _, e := range endpoints {
+			chime -= e.edgeType
+			if chime >= n-allow {
+				low = e.value
+				break
+			}
+			if e.edgeType == 0 {
+				found++
+			}
+		}
+		chime = 0
+		for i := len(endpoints) - 1; i >= 0; i-- {
+			e := endpoints[i]
+			chime += e.edgeType
+			if chime >= n-allow {
+				high = e.value
+				break
+			}
+			if e.edgeType == 0 {
+				found++
+			}
+		}
+		if found > allow {
+			continue
+		}
+		if low < high {
+			break
+		}
+	}
+	if 2*allow >= n {
+		return nil, E.New("no majority of servers agree on the time")
+	}
+	var truechimers []*selectCandidate
+	for _, candidate := range candidates {
+		if candidate.offset >= low && candidate.offset <= high {
+			truechimers = append(truechimers, candidate)
+		}
+	}
+	return truechimers, nil
+}
+
+// clusterSurvivors discards the outliers with the largest selection jitter while doing so reduces it.
+func clusterSurvivors(survivors []*selectCandidate) (kept []*selectCandidate, outliers []*selectCandidate) {
+	survivors = append([]*selectCandidate(nil), survivors...)
+	sort.SliceStable(survivors, func(i, j int) bool {
+		return merit(survivors[i]) < merit(survivors[j])
+	})
+	for len(survivors) > minClusterPeers {
+		maxIndex, maxJitter := 0, -1.0
+		minPeerJitter := math.MaxFloat64
+		for i, candidate := range survivors {
+			jitter := selectionJitter(survivors, candidate)
+			if jitter > maxJitter {
+				maxIndex, maxJitter = i, jitter
+			}
+			minPeerJitter = math.Min(minPeerJitter, candidate.jitter.Seconds())
+		}
+		if maxJitter <= minPeerJitter {
+			break
+		}
+		outliers = append(outliers, survivors[maxIndex])
+		survivors = append(survivors[:maxIndex], survivors[maxIndex+1:]...)
+	}
+	return survivors, outliers
+}
+
+func selectionJitter(survivors []*selectCandidate, candidate *selectCandidate) float64 {
+	if len(survivors) < 2 {
+		return 0
+	}
+	var sum float64
+	for _, other := range survivors {
+		difference := (other.offset - candidate.offset).Seconds()
+		sum += difference * difference
+	}
+	return math.Sqrt(sum / float64(len(survivors)-1))
+}
+
+func merit(candidate *selectCandidate) time.Duration {
+	return time.Duration(candidate.stratum)*maxDistance + candidate.distance
+}
+
+// combineOffsets averages the survivor offsets weighted by the reciprocal of their root distance
+// and returns the combined offset and system jitter. survivors[0] is the system peer.
+func combineOffsets(survivors []*selectCandidate) (time.Duration, time.Duration) {
+	var weightSum, offsetSum, jitterSum float64
+	systemPeer := survivors[0]
+	for _, candidate := range survivors {
+		weight := 1 / candidate.distance.Seconds()
+		weightSum += weight
+		offsetSum += weight * candidate.offset.Seconds()
+		difference := (candidate.offset - systemPeer.offset).Seconds()
+		jitterSum += weight * difference * difference
+	}
+	selectJitterSquared := jitterSum / weightSum
+	peerJitter := systemPeer.jitter.Seconds()
+	return durationFromSeconds(offsetSum / weightSum), durationFromSeconds(math.Sqrt(peerJitter*peerJitter + selectJitterSquared))
+}
+
+func agedDispersion(age time.Duration) time.Duration {
+	if age < 0 {
+		return 0
+	}
+	return durationFromSeconds(age.Seconds() * frequencyTolerance)
+}
+
+func durationFromSeconds(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
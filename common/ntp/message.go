@@ -51,7 +51,7 @@ var (
 
 type mode uint8
 
-// NTP modes. This package uses only client mode.
+// NTP modes. This package uses only client and server mode.
 const (
 	reserved mode = 0 + iota
 	symmetricActive
@@ -114,6 +114,20 @@ func (t ntpTimeShort) Duration() time.Duration {
 	return time.Duration(sec + nsec)
 }
 
+// toNtpTimeShort converts the non-negative duration d into its 32-bit
+// fixed-point ntpTimeShort representation, saturating on overflow.
+func toNtpTimeShort(d time.Duration) ntpTimeShort {
+	if d <= 0 {
+		return 0
+	}
+	sec := uint64(d) / nanoPerSec
+	if sec > 0xffff {
+		return 0xffffffff
+	}
+	frac := (uint64(d) - sec*nanoPerSec) << 16 / nanoPerSec
+	return ntpTimeShort(sec<<16 | frac)
+}
+
 // msg is an internal representation of an NTP packet.
 type msg struct {
 	LiVnMode       uint8 // Leap Indicator (2) + Version (3) + Mode (3)
@@ -1,51 +1,85 @@
 package ntp
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"time"
 
+	E "github.com/sagernet/sing/common/exceptions"
 	M "github.com/sagernet/sing/common/metadata"
 	N "github.com/sagernet/sing/common/network"
 )
 
 func Exchange(ctx context.Context, dialer N.Dialer, serverAddress M.Socksaddr) (*Response, error) {
+	return exchange(ctx, dialer, serverAddress, nil)
+}
+
+// exchange sends one client request, authenticated with NTS if session is not nil.
+func exchange(ctx context.Context, dialer N.Dialer, serverAddress M.Socksaddr, session *ntsSession) (*Response, error) {
 	conn, err := dialer.DialContext(ctx, N.NetworkUDP, serverAddress)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
-	conn.SetDeadline(time.Now().Add(defaultTimeout))
+	deadline := time.Now().Add(defaultTimeout)
+	if ctxDeadline, loaded := ctx.Deadline(); loaded && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
 
 	var request msg
 	request.setMode(client)
 	request.setVersion(defaultNtpVersion)
 	request.setLeap(LeapNotInSync)
-
-	bits := make([]byte, 8)
-	_, err = rand.Read(bits)
-	var xmitTime time.Time
-	if err == nil {
-		request.TransmitTime = ntpTime(binary.BigEndian.Uint64(bits))
-		xmitTime = time.Now()
-	} else {
-		xmitTime = time.Now()
-		request.TransmitTime = toNtpTime(xmitTime)
-	}
-
-	err = binary.Write(conn, binary.BigEndian, request)
+	// a random transmit timestamp prevents off-path spoofing; the send time is kept locally
+	var transmitTime [8]byte
+	_, err = rand.Read(transmitTime[:])
 	if err != nil {
 		return nil, err
 	}
-
-	var response msg
-	err = binary.Read(conn, binary.BigEndian, &response)
+	request.TransmitTime = ntpTime(binary.BigEndian.Uint64(transmitTime[:]))
+	packetBuffer := bytes.NewBuffer(make([]byte, 0, ntpHeaderLen))
+	binary.Write(packetBuffer, binary.BigEndian, request)
+	packet := packetBuffer.Bytes()
+	var uniqueID []byte
+	if session != nil {
+		packet, uniqueID, err = session.appendRequest(packet)
+		if err != nil {
+			return nil, err
+		}
+	}
+	xmitTime := time.Now()
+	_, err = conn.Write(packet)
 	if err != nil {
 		return nil, err
 	}
-
+	responsePacket := make([]byte, 4096)
+	var n int
+	for {
+		n, err = conn.Read(responsePacket)
+		if err != nil {
+			return nil, err
+		}
+		if n >= ntpHeaderLen && binary.BigEndian.Uint64(responsePacket[24:32]) == uint64(request.TransmitTime) {
+			break
+		}
+		// ignore stray or spoofed packets
+	}
 	recvTime := toNtpTime(xmitTime.Add(time.Since(xmitTime)))
+	var response msg
+	binary.Read(bytes.NewReader(responsePacket[:ntpHeaderLen]), binary.BigEndian, &response)
+	if response.getMode() != server {
+		return nil, E.New("unexpected NTP mode: ", response.getMode())
+	}
 	response.OriginTime = toNtpTime(xmitTime)
-	return parseTime(&response, recvTime), nil
+	parsed := parseTime(&response, recvTime)
+	if session != nil {
+		err = session.verifyResponse(responsePacket[:n], uniqueID, parsed.KissCode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parsed, nil
 }
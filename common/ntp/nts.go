@@ -0,0 +1,358 @@
+package ntp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	aTLS "github.com/sagernet/sing/common/tls"
+)
+
+// Network Time Security (RFC 8915)
+
+const (
+	ntsKEPort     = 4460
+	ntsKEALPN     = "ntske/1"
+	ntsExporter   = "EXPORTER-network-time-security"
+	ntsMaxCookies = 8
+
+	ntsProtocolNTPv4     uint16 = 0
+	ntsAEADAESSIVCMAC256 uint16 = 15
+)
+
+const (
+	ntsRecordEndOfMessage uint16 = iota
+	ntsRecordNextProtocol
+	ntsRecordError
+	ntsRecordWarning
+	ntsRecordAEADAlgorithm
+	ntsRecordNewCookie
+	ntsRecordServer
+	ntsRecordPort
+)
+
+const ntsRecordCritical uint16 = 0x8000
+
+// NTP extension field types
+const (
+	extensionUniqueIdentifier  uint16 = 0x0104
+	extensionNTSCookie         uint16 = 0x0204
+	extensionCookiePlaceholder uint16 = 0x0304
+	extensionNTSAuthenticator  uint16 = 0x0404
+)
+
+const (
+	ntpHeaderLen                  = 48
+	ntsRecordHeaderLen            = 4
+	ntsExtensionHeaderLen         = 4
+	ntsUniqueIdentifierLen        = 32
+	ntsNonceLen                   = 16
+	ntsMaxKeyEstablishmentMessage = 65536
+	ntsKissCodeNAK                = "NTSN"
+)
+
+var errNTSNAK = E.New("NTS negative acknowledgment")
+
+type ntsSession struct {
+	access  sync.Mutex
+	server  M.Socksaddr
+	c2s     *aesSIV
+	s2c     *aesSIV
+	cookies [][]byte
+}
+
+// ntsKeyEstablishment performs NTS-KE with the server at address and returns a session for the negotiated NTP server.
+func ntsKeyEstablishment(ctx context.Context, dialer N.Dialer, address M.Socksaddr, config aTLS.Config) (*ntsSession, error) {
+	if address.Port == 0 {
+		address.Port = ntsKEPort
+	}
+	conn, err := dialer.DialContext(ctx, N.NetworkTCP, address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, loaded := ctx.Deadline(); loaded {
+		conn.SetDeadline(deadline)
+	}
+	var tlsConn aTLS.Conn
+	if config != nil {
+		config = config.Clone()
+		config.SetNextProtos([]string{ntsKEALPN})
+		if config.ServerName() == "" {
+			config.SetServerName(address.AddrString())
+		}
+		tlsConn, err = aTLS.ClientHandshake(ctx, conn, config)
+	} else {
+		stdConn := tls.Client(conn, &tls.Config{
+			ServerName: address.AddrString(),
+			NextProtos: []string{ntsKEALPN},
+			MinVersion: tls.VersionTLS13,
+		})
+		tlsConn, err = stdConn, stdConn.HandshakeContext(ctx)
+	}
+	if err != nil {
+		return nil, E.Cause(err, "NTS-KE handshake")
+	}
+	state := tlsConn.ConnectionState()
+	if state.Version < tls.VersionTLS13 {
+		return nil, E.New("NTS-KE requires TLS 1.3")
+	}
+	if state.NegotiatedProtocol != ntsKEALPN {
+		return nil, E.New("NTS-KE: server did not negotiate ", ntsKEALPN)
+	}
+	var request []byte
+	request = appendNTSRecord(request, true, ntsRecordNextProtocol, binary.BigEndian.AppendUint16(nil, ntsProtocolNTPv4))
+	request = appendNTSRecord(request, true, ntsRecordAEADAlgorithm, binary.BigEndian.AppendUint16(nil, ntsAEADAESSIVCMAC256))
+	request = appendNTSRecord(request, true, ntsRecordEndOfMessage, nil)
+	_, err = tlsConn.Write(request)
+	if err != nil {
+		return nil, err
+	}
+	session := &ntsSession{
+		server: M.Socksaddr{Addr: address.Addr, Fqdn: address.Fqdn, Port: 123},
+	}
+	var (
+		hasProtocol bool
+		hasAEAD     bool
+	)
+	reader := io.LimitReader(tlsConn, ntsMaxKeyEstablishmentMessage)
+	for {
+		recordType, critical, body, err := readNTSRecord(reader)
+		if err != nil {
+			return nil, E.Cause(err, "read NTS-KE response")
+		}
+		switch recordType {
+		case ntsRecordEndOfMessage:
+			if !hasProtocol || !hasAEAD {
+				return nil, E.New("NTS-KE: missing protocol or AEAD negotiation")
+			}
+			if len(session.cookies) == 0 {
+				return nil, E.New("NTS-KE: no cookies received")
+			}
+			err = session.exportKeys(state)
+			if err != nil {
+				return nil, err
+			}
+			return session, nil
+		case ntsRecordNextProtocol:
+			if len(body) != 2 || binary.BigEndian.Uint16(body) != ntsProtocolNTPv4 {
+				return nil, E.New("NTS-KE: NTPv4 not accepted")
+			}
+			hasProtocol = true
+		case ntsRecordAEADAlgorithm:
+			if len(body) != 2 || binary.BigEndian.Uint16(body) != ntsAEADAESSIVCMAC256 {
+				return nil, E.New("NTS-KE: AEAD algorithm not accepted")
+			}
+			hasAEAD = true
+		case ntsRecordError, ntsRecordWarning:
+			var code uint16
+			if len(body) >= 2 {
+				code = binary.BigEndian.Uint16(body)
+			}
+			return nil, E.New("NTS-KE: server sent error or warning ", code)
+		case ntsRecordNewCookie:
+			session.cookies = append(session.cookies, body)
+		case ntsRecordServer:
+			session.server = M.ParseSocksaddrHostPort(string(body), session.server.Port)
+		case ntsRecordPort:
+			if len(body) != 2 {
+				return nil, E.New("NTS-KE: bad port record")
+			}
+			session.server.Port = binary.BigEndian.Uint16(body)
+		default:
+			if critical {
+				return nil, E.New("NTS-KE: unknown critical record ", recordType)
+			}
+		}
+	}
+}
+
+func (s *ntsSession) exportKeys(state tls.ConnectionState) error {
+	exporterContext := []byte{0, 0, 0, byte(ntsAEADAESSIVCMAC256), 0}
+	c2sKey, err := state.ExportKeyingMaterial(ntsExporter, exporterContext, aesSIVKeySize)
+	if err != nil {
+		return err
+	}
+	exporterContext[4] = 1
+	s2cKey, err := state.ExportKeyingMaterial(ntsExporter, exporterContext, aesSIVKeySize)
+	if err != nil {
+		return err
+	}
+	s.c2s, err = newAESSIV(c2sKey)
+	if err != nil {
+		return err
+	}
+	s.s2c, err = newAESSIV(s2cKey)
+	return err
+}
+
+func (s *ntsSession) hasCookie() bool {
+	s.access.Lock()
+	defer s.access.Unlock()
+	return len(s.cookies) > 0
+}
+
+// appendRequest appends the NTS extension fields to an NTP request header and returns
+// the unique identifier to expect in the response.
+func (s *ntsSession) appendRequest(packet []byte) ([]byte, []byte, error) {
+	s.access.Lock()
+	if len(s.cookies) == 0 {
+		s.access.Unlock()
+		return nil, nil, E.New("NTS: out of cookies")
+	}
+	cookie := s.cookies[0]
+	s.cookies = s.cookies[1:]
+	placeholders := ntsMaxCookies - 1 - len(s.cookies)
+	s.access.Unlock()
+	uniqueID := make([]byte, ntsUniqueIdentifierLen)
+	_, err := rand.Read(uniqueID)
+	if err != nil {
+		return nil, nil, err
+	}
+	packet = appendExtensionField(packet, extensionUniqueIdentifier, uniqueID)
+	packet = appendExtensionField(packet, extensionNTSCookie, cookie)
+	for i := 0; i < placeholders; i++ {
+		packet = appendExtensionField(packet, extensionCookiePlaceholder, make([]byte, len(cookie)))
+	}
+	nonce := make([]byte, ntsNonceLen)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphertext := s.c2s.seal(nonce, nil, packet)
+	return appendExtensionField(packet, extensionNTSAuthenticator, encodeAuthenticator(nonce, ciphertext)), uniqueID, nil
+}
+
+// verifyResponse authenticates the extension fields of a response and stores the cookies it carries.
+func (s *ntsSession) verifyResponse(packet []byte, uniqueID []byte, kissCode string) error {
+	var (
+		hasUniqueID  bool
+		authorized   bool
+		newCookies   [][]byte
+		offset       = ntpHeaderLen
+		decryptedEFs []byte
+	)
+	for offset < len(packet) {
+		fieldType, body, next, err := readExtensionField(packet, offset)
+		if err != nil {
+			return err
+		}
+		switch fieldType {
+		case extensionUniqueIdentifier:
+			hasUniqueID = string(body) == string(uniqueID)
+		case extensionNTSAuthenticator:
+			nonce, ciphertext, err := decodeAuthenticator(body)
+			if err != nil {
+				return err
+			}
+			decryptedEFs, err = s.s2c.open(nonce, ciphertext, packet[:offset])
+			if err != nil {
+				return err
+			}
+			authorized = true
+		}
+		offset = next
+	}
+	if !hasUniqueID {
+		return E.New("NTS: unique identifier mismatch")
+	}
+	if !authorized {
+		if kissCode == ntsKissCodeNAK {
+			return errNTSNAK
+		}
+		return E.New("NTS: missing authenticator")
+	}
+	for offset = 0; offset < len(decryptedEFs); {
+		fieldType, body, next, err := readExtensionField(decryptedEFs, offset)
+		if err != nil {
+			return err
+		}
+		if fieldType == extensionNTSCookie {
+			newCookies = append(newCookies, body)
+		}
+		offset = next
+	}
+	s.access.Lock()
+	s.cookies = append(s.cookies, newCookies...)
+	if len(s.cookies) > ntsMaxCookies {
+		s.cookies = s.cookies[len(s.cookies)-ntsMaxCookies:]
+	}
+	s.access.Unlock()
+	return nil
+}
+
+func appendNTSRecord(buffer []byte, critical bool, recordType uint16, body []byte) []byte {
+	if critical {
+		recordType |= ntsRecordCritical
+	}
+	buffer = binary.BigEndian.AppendUint16(buffer, recordType)
+	buffer = binary.BigEndian.AppendUint16(buffer, uint16(len(body)))
+	return append(buffer, body...)
+}
+
+func readNTSRecord(reader io.Reader) (recordType uint16, critical bool, body []byte, err error) {
+	var header [ntsRecordHeaderLen]byte
+	_, err = io.ReadFull(reader, header[:])
+	if err != nil {
+		return
+	}
+	recordType = binary.BigEndian.Uint16(header[:2])
+	critical = recordType&ntsRecordCritical != 0
+	recordType &^= ntsRecordCritical
+	body = make([]byte, binary.BigEndian.Uint16(header[2:]))
+	_, err = io.ReadFull(reader, body)
+	return
+}
+
+func appendExtensionField(packet []byte, fieldType uint16, body []byte) []byte {
+	length := ntsExtensionHeaderLen + pad4(len(body))
+	packet = binary.BigEndian.AppendUint16(packet, fieldType)
+	packet = binary.BigEndian.AppendUint16(packet, uint16(length))
+	packet = append(packet, body...)
+	return append(packet, make([]byte, length-ntsExtensionHeaderLen-len(body))...)
+}
+
+func readExtensionField(packet []byte, offset int) (fieldType uint16, body []byte, next int, err error) {
+	if len(packet)-offset < ntsExtensionHeaderLen {
+		return 0, nil, 0, E.New("NTP: truncated extension field")
+	}
+	fieldType = binary.BigEndian.Uint16(packet[offset:])
+	length := int(binary.BigEndian.Uint16(packet[offset+2:]))
+	if length < ntsExtensionHeaderLen || length%4 != 0 || offset+length > len(packet) {
+		return 0, nil, 0, E.New("NTP: bad extension field length ", length)
+	}
+	return fieldType, packet[offset+ntsExtensionHeaderLen : offset+length], offset + length, nil
+}
+
+func encodeAuthenticator(nonce []byte, ciphertext []byte) []byte {
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(nonce)))
+	body = binary.BigEndian.AppendUint16(body, uint16(len(ciphertext)))
+	body = append(body, nonce...)
+	body = append(body, make([]byte, pad4(len(nonce))-len(nonce))...)
+	body = append(body, ciphertext...)
+	return append(body, make([]byte, pad4(len(ciphertext))-len(ciphertext))...)
+}
+
+func decodeAuthenticator(body []byte) (nonce []byte, ciphertext []byte, err error) {
+	if len(body) < 4 {
+		return nil, nil, E.New("NTS: truncated authenticator")
+	}
+	nonceLen := int(binary.BigEndian.Uint16(body))
+	ciphertextLen := int(binary.BigEndian.Uint16(body[2:]))
+	if 4+pad4(nonceLen)+ciphertextLen > len(body) {
+		return nil, nil, E.New("NTS: truncated authenticator")
+	}
+	nonce = body[4 : 4+nonceLen]
+	ciphertext = body[4+pad4(nonceLen) : 4+pad4(nonceLen)+ciphertextLen]
+	return
+}
+
+func pad4(length int) int {
+	return (length + 3) &^ 3
+}
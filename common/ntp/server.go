@@ -0,0 +1,253 @@
+package ntp
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing/common/buf"
+	"github.com/sagernet/sing/common/cache"
+	"github.com/sagernet/sing/common/ipcidr"
+	"github.com/sagernet/sing/common/logger"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+const (
+	defaultRateLimitInterval = 2 * time.Second
+	defaultRateLimitBurst    = 8
+	maxRateLimitClients      = 4096
+	serverPrecision          = -20
+)
+
+// Kiss codes sent by Server.
+const (
+	KissCodeDeny = "DENY"
+	KissCodeRate = "RATE"
+)
+
+type ServeOptions struct {
+	Logger logger.ContextLogger
+	// TimeFunc returns the time to serve, usually TimeService.TimeFunc(). Defaults to time.Now.
+	TimeFunc func() time.Time
+	// Statistics returns the synchronization state to advertise, usually Service.Statistics.
+	// Without statistics the server reports itself unsynchronized, unless LocalStratum is set.
+	Statistics func() *Statistics
+	// LocalStratum is the stratum advertised while there are no statistics, serving the local clock.
+	LocalStratum uint8
+	// AllowedClients restricts the clients served; others receive a DENY kiss-o'-death. Empty allows all.
+	AllowedClients []netip.Prefix
+	// RateLimitInterval is the minimum average interval between requests of a client, and RateLimitBurst
+	// the number of requests allowed in a row. Clients exceeding the limit receive a RATE kiss-o'-death.
+	RateLimitInterval time.Duration
+	RateLimitBurst    int
+	// DisableRateLimit disables the per-client rate limit.
+	DisableRateLimit bool
+}
+
+// Server is a stateless SNTP server (RFC 4330) answering client mode requests.
+type Server struct {
+	logger            logger.ContextLogger
+	timeFunc          func() time.Time
+	statistics        func() *Statistics
+	localStratum      uint8
+	allowedClients    *ipcidr.Set
+	rateLimitInterval time.Duration
+	rateLimitBurst    int
+	clients           *cache.LruCache[netip.Addr, *rateLimitBucket]
+}
+
+type rateLimitBucket struct {
+	access  sync.Mutex
+	tokens  float64
+	updated time.Time
+	kissed  bool
+}
+
+func NewServer(options ServeOptions) *Server {
+	server := &Server{
+		logger:            options.Logger,
+		timeFunc:          options.TimeFunc,
+		statistics:        options.Statistics,
+		localStratum:      options.LocalStratum,
+		rateLimitInterval: options.RateLimitInterval,
+		rateLimitBurst:    options.RateLimitBurst,
+	}
+	if server.logger == nil {
+		server.logger = logger.NOP()
+	}
+	if server.timeFunc == nil {
+		server.timeFunc = time.Now
+	}
+	if len(options.AllowedClients) > 0 {
+		server.allowedClients = ipcidr.New(options.AllowedClients)
+	}
+	if !options.DisableRateLimit {
+		if server.rateLimitInterval <= 0 {
+			server.rateLimitInterval = defaultRateLimitInterval
+		}
+		if server.rateLimitBurst <= 0 {
+			server.rateLimitBurst = defaultRateLimitBurst
+		}
+		server.clients = cache.New(
+			cache.WithSize[netip.Addr, *rateLimitBucket](maxRateLimitClients),
+			cache.WithDisabledCleaner[netip.Addr, *rateLimitBucket](),
+		)
+	}
+	return server
+}
+
+// Serve answers requests read from conn until reading fails, e.g. after conn is closed.
+func (s *Server) Serve(ctx context.Context, conn N.PacketConn) error {
+	buffer := buf.NewPacket()
+	defer buffer.Release()
+	for {
+		buffer.Reset()
+		source, err := conn.ReadPacket(buffer)
+		if err != nil {
+			return err
+		}
+		receiveTime := s.timeFunc()
+		response := s.handle(ctx, buffer.Bytes(), source, receiveTime)
+		if response == nil {
+			continue
+		}
+		responseBuffer := buf.NewSize(ntpHeaderLen)
+		binary.Write(responseBuffer, binary.BigEndian, response)
+		err = conn.WritePacket(responseBuffer, source)
+		if err != nil {
+			s.logger.DebugContext(ctx, "write NTP response to ", source, ": ", err)
+		}
+	}
+}
+
+func (s *Server) handle(ctx context.Context, packet []byte, source M.Socksaddr, receiveTime time.Time) *msg {
+	if len(packet) < ntpHeaderLen {
+		return nil
+	}
+	var request msg
+	binary.Read(bytes.NewReader(packet[:ntpHeaderLen]), binary.BigEndian, &request)
+	version := request.getVersion()
+	if request.getMode() != client || version < 1 || version > defaultNtpVersion {
+		return nil
+	}
+	clientAddr := source.Addr.Unmap()
+	if s.allowedClients != nil && !s.allowedClients.Contains(clientAddr) {
+		s.logger.DebugContext(ctx, "deny NTP client ", source)
+		return newKissOfDeath(&request, KissCodeDeny)
+	}
+	if s.clients != nil {
+		allowed, kiss := s.rateLimit(clientAddr, time.Now())
+		if !allowed {
+			if !kiss {
+				return nil
+			}
+			s.logger.DebugContext(ctx, "rate limit NTP client ", source)
+			return newKissOfDeath(&request, KissCodeRate)
+		}
+	}
+	response := &msg{
+		Poll:        request.Poll,
+		Precision:   serverPrecision,
+		OriginTime:  request.TransmitTime,
+		ReceiveTime: toNtpTime(receiveTime),
+	}
+	response.setMode(server)
+	response.setVersion(version)
+	s.fillSystemVariables(response, receiveTime)
+	response.TransmitTime = toNtpTime(s.timeFunc())
+	return response
+}
+
+func (s *Server) fillSystemVariables(response *msg, now time.Time) {
+	var statistics *Statistics
+	if s.statistics != nil {
+		statistics = s.statistics()
+	}
+	if statistics == nil || statistics.Stratum == 0 || statistics.Stratum >= maxStratum {
+		if s.localStratum == 0 || s.localStratum >= maxStratum {
+			response.setLeap(LeapNotInSync)
+			response.ReferenceID = kissCodeID("INIT")
+			return
+		}
+		response.setLeap(LeapNoWarning)
+		response.Stratum = s.localStratum
+		response.ReferenceID = kissCodeID("LOCL")
+		response.ReferenceTime = toNtpTime(now)
+		return
+	}
+	response.setLeap(statistics.Leap)
+	response.Stratum = statistics.Stratum
+	response.ReferenceID = referenceID(statistics.SystemPeer)
+	referenceTime := statistics.Time.Add(statistics.Offset)
+	response.ReferenceTime = toNtpTime(referenceTime)
+	response.RootDelay = toNtpTimeShort(statistics.RootDelay)
+	response.RootDispersion = toNtpTimeShort(statistics.RootDispersion + agedDispersion(now.Sub(referenceTime)))
+}
+
+// rateLimit reports whether a request is allowed, and if not, whether to answer it with a kiss-o'-death,
+// which is sent once per violation so that the limit also bounds the response rate.
+func (s *Server) rateLimit(clientAddr netip.Addr, now time.Time) (allowed bool, kiss bool) {
+	bucket, _ := s.clients.LoadOrStore(clientAddr, func() *rateLimitBucket {
+		return &rateLimitBucket{tokens: float64(s.rateLimitBurst), updated: now}
+	})
+	bucket.access.Lock()
+	defer bucket.access.Unlock()
+	elapsed := now.Sub(bucket.updated)
+	if elapsed > 0 {
+		bucket.tokens += float64(elapsed) / float64(s.rateLimitInterval)
+		if bucket.tokens > float64(s.rateLimitBurst) {
+			bucket.tokens = float64(s.rateLimitBurst)
+		}
+	}
+	bucket.updated = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		bucket.kissed = false
+		return true, false
+	}
+	kiss = !bucket.kissed
+	bucket.kissed = true
+	return false, kiss
+}
+
+func newKissOfDeath(request *msg, code string) *msg {
+	response := &msg{
+		Poll:         request.Poll,
+		Precision:    serverPrecision,
+		ReferenceID:  kissCodeID(code),
+		OriginTime:   request.TransmitTime,
+		ReceiveTime:  request.TransmitTime,
+		TransmitTime: request.TransmitTime,
+	}
+	response.setMode(server)
+	response.setVersion(request.getVersion())
+	response.setLeap(LeapNotInSync)
+	return response
+}
+
+func kissCodeID(code string) uint32 {
+	return binary.BigEndian.Uint32([]byte(code))
+}
+
+// referenceID identifies an upstream server by its IPv4 address, or by the first four bytes of the MD5 hash of
+// its IPv6 address or domain name.
+func referenceID(server M.Socksaddr) uint32 {
+	if server.IsIPv4() {
+		address := server.Addr.Unmap().As4()
+		return binary.BigEndian.Uint32(address[:])
+	}
+	var hashInput []byte
+	if server.IsIP() {
+		address := server.Addr.As16()
+		hashInput = address[:]
+	} else {
+		hashInput = []byte(server.Fqdn)
+	}
+	hash := md5.Sum(hashInput)
+	return binary.BigEndian.Uint32(hash[:4])
+}
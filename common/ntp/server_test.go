@@ -0,0 +1,97 @@
+package ntp
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/sagernet/sing/common/bufio"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+
+	"github.com/stretchr/testify/require"
+)
+
+func startTestServer(t *testing.T, options ServeOptions) M.Socksaddr {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	go NewServer(options).Serve(context.Background(), bufio.NewPacketConn(conn))
+	return M.SocksaddrFromNet(conn.LocalAddr())
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+	statistics := &Statistics{
+		Time:           time.Now(),
+		Offset:         3 * time.Second,
+		RootDelay:      20 * time.Millisecond,
+		RootDispersion: 10 * time.Millisecond,
+		Stratum:        2,
+		Leap:           LeapAddSecond,
+		SystemPeer:     M.ParseSocksaddr("192.0.2.1:123"),
+	}
+	serverAddress := startTestServer(t, ServeOptions{
+		TimeFunc: func() time.Time {
+			return time.Now().Add(3 * time.Second)
+		},
+		Statistics: func() *Statistics {
+			return statistics
+		},
+	})
+	response, err := Exchange(context.Background(), N.SystemDialer, serverAddress)
+	require.NoError(t, err)
+	require.NoError(t, response.Validate())
+	require.InDelta(t, 3*time.Second, response.ClockOffset, float64(50*time.Millisecond))
+	require.Equal(t, uint8(2), response.Stratum)
+	require.Equal(t, LeapIndicator(LeapAddSecond), response.Leap)
+	require.Equal(t, uint32(0xc0000201), response.ReferenceID)
+	require.InDelta(t, 20*time.Millisecond, response.RootDelay, float64(time.Millisecond))
+	require.InDelta(t, 10*time.Millisecond, response.RootDispersion, float64(time.Millisecond))
+}
+
+func TestServerUnsynchronized(t *testing.T) {
+	t.Parallel()
+	response, err := Exchange(context.Background(), N.SystemDialer, startTestServer(t, ServeOptions{}))
+	require.NoError(t, err)
+	require.Equal(t, LeapIndicator(LeapNotInSync), response.Leap)
+	require.Equal(t, "INIT", response.KissCode)
+	require.Error(t, response.Validate())
+
+	response, err = Exchange(context.Background(), N.SystemDialer, startTestServer(t, ServeOptions{LocalStratum: 10}))
+	require.NoError(t, err)
+	require.NoError(t, response.Validate())
+	require.Equal(t, uint8(10), response.Stratum)
+	require.Equal(t, LeapIndicator(LeapNoWarning), response.Leap)
+}
+
+func TestServerKissOfDeath(t *testing.T) {
+	t.Parallel()
+	response, err := Exchange(context.Background(), N.SystemDialer, startTestServer(t, ServeOptions{
+		AllowedClients: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	}))
+	require.NoError(t, err)
+	require.Equal(t, KissCodeDeny, response.KissCode)
+
+	serverAddress := startTestServer(t, ServeOptions{
+		LocalStratum:      10,
+		RateLimitInterval: time.Hour,
+		RateLimitBurst:    2,
+	})
+	for i := 0; i < 2; i++ {
+		response, err = Exchange(context.Background(), N.SystemDialer, serverAddress)
+		require.NoError(t, err)
+		require.NoError(t, response.Validate())
+	}
+	response, err = Exchange(context.Background(), N.SystemDialer, serverAddress)
+	require.NoError(t, err)
+	require.Equal(t, KissCodeRate, response.KissCode)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = Exchange(ctx, N.SystemDialer, serverAddress)
+	require.Error(t, err)
+}
@@ -3,13 +3,16 @@ package ntp
 import (
 	"context"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/atomic"
 	E "github.com/sagernet/sing/common/exceptions"
 	"github.com/sagernet/sing/common/logger"
 	M "github.com/sagernet/sing/common/metadata"
 	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/common/observable"
 	"github.com/sagernet/sing/service"
 	"github.com/sagernet/sing/service/pause"
 )
@@ -25,23 +28,38 @@ type Options struct {
 	Dialer        N.Dialer
 	Logger        logger.Logger
 	Server        M.Socksaddr
+	Servers       []ServerOptions
 	Interval      time.Duration
 	Timeout       time.Duration
 	WriteToSystem bool
+	// Slew makes TimeFunc converge to each new offset at MaxSlewRate instead of jumping,
+	// unless the difference exceeds StepThreshold. With WriteToSystem, only steps are written to the system clock.
+	Slew          bool
+	MaxSlewRate   float64
+	StepThreshold time.Duration
 }
 
-var _ TimeService = (*Service)(nil)
+var (
+	_ TimeService                      = (*Service)(nil)
+	_ observable.Observable[StepEvent] = (*Service)(nil)
+)
 
 type Service struct {
 	ctx           context.Context
 	cancel        common.ContextCancelCauseFunc
-	dialer        N.Dialer
 	logger        logger.Logger
-	server        M.Socksaddr
+	client        *Client
+	clientErr     error
 	writeToSystem bool
+	slew          bool
+	maxSlewRate   float64
+	stepThreshold time.Duration
 	ticker        *time.Ticker
-	timeout       time.Duration
-	clockOffset   time.Duration
+	clock         atomic.Pointer[clockState]
+	synchronized  bool
+	stepAccess    sync.Mutex
+	stepListeners map[observable.Subscription[StepEvent]]*observable.Subscriber[StepEvent]
+	closed        bool
 	pause         pause.Manager
 }
 
@@ -60,8 +78,9 @@ func NewService(options Options) *Service {
 	if options.Logger == nil {
 		options.Logger = logger.NOP()
 	}
-	if destination.Port == 0 {
-		destination.Port = 123
+	servers := options.Servers
+	if len(servers) == 0 {
+		servers = []ServerOptions{{Address: destination}}
 	}
 	var interval time.Duration
 	if options.Interval > 0 {
@@ -69,26 +88,40 @@ func NewService(options Options) *Service {
 	} else {
 		interval = 30 * time.Minute
 	}
-	var dialer N.Dialer
-	if options.Dialer != nil {
-		dialer = options.Dialer
-	} else {
-		dialer = N.SystemDialer
+	maxSlewRate := options.MaxSlewRate
+	if maxSlewRate <= 0 || maxSlewRate >= 1 {
+		maxSlewRate = defaultMaxSlewRate
 	}
+	stepThreshold := options.StepThreshold
+	if stepThreshold <= 0 {
+		stepThreshold = defaultStepThreshold
+	}
+	client, err := NewClient(ClientOptions{
+		Dialer:  options.Dialer,
+		Logger:  options.Logger,
+		Servers: servers,
+		Timeout: options.Timeout,
+	})
 	return &Service{
 		ctx:           ctx,
 		cancel:        cancel,
-		dialer:        dialer,
 		logger:        options.Logger,
+		client:        client,
+		clientErr:     err,
 		writeToSystem: options.WriteToSystem,
-		server:        destination,
+		slew:          options.Slew,
+		maxSlewRate:   maxSlewRate,
+		stepThreshold: stepThreshold,
 		ticker:        time.NewTicker(interval),
-		timeout:       options.Timeout,
+		stepListeners: make(map[observable.Subscription[StepEvent]]*observable.Subscriber[StepEvent]),
 		pause:         service.FromContext[pause.Manager](ctx),
 	}
 }
 
 func (s *Service) Start() error {
+	if s.clientErr != nil {
+		return s.clientErr
+	}
 	err := s.update()
 	if err != nil {
 		s.logger.Error(E.Cause(err, "initialize time"))
@@ -102,13 +135,62 @@ func (s *Service) Start() error {
 func (s *Service) Close() error {
 	s.ticker.Stop()
 	s.cancel(os.ErrClosed)
+	s.stepAccess.Lock()
+	defer s.stepAccess.Unlock()
+	s.closed = true
+	for subscription, listener := range s.stepListeners {
+		listener.Close()
+		delete(s.stepListeners, subscription)
+	}
 	return nil
 }
 
 func (s *Service) TimeFunc() func() time.Time {
 	return func() time.Time {
-		return time.Now().Add(s.clockOffset)
+		now := time.Now()
+		return now.Add(s.clockOffset(now))
+	}
+}
+
+// Subscribe returns a subscription to step events.
+func (s *Service) Subscribe() (subscription observable.Subscription[StepEvent], done <-chan struct{}, err error) {
+	s.stepAccess.Lock()
+	defer s.stepAccess.Unlock()
+	if s.closed {
+		return nil, nil, os.ErrClosed
+	}
+	listener := observable.NewSubscriber[StepEvent](16)
+	subscription, done = listener.Subscription()
+	s.stepListeners[subscription] = listener
+	return
+}
+
+func (s *Service) UnSubscribe(subscription observable.Subscription[StepEvent]) {
+	s.stepAccess.Lock()
+	defer s.stepAccess.Unlock()
+	listener, loaded := s.stepListeners[subscription]
+	if !loaded {
+		return
+	}
+	delete(s.stepListeners, subscription)
+	listener.Close()
+}
+
+// emitStep delivers an event to all subscriptions without blocking, dropping it for full buffers.
+func (s *Service) emitStep(event StepEvent) {
+	s.stepAccess.Lock()
+	defer s.stepAccess.Unlock()
+	for _, listener := range s.stepListeners {
+		listener.Emit(event)
+	}
+}
+
+func (s *Service) clockOffset(now time.Time) time.Duration {
+	clock := s.clock.Load()
+	if clock == nil {
+		return 0
 	}
+	return clock.offset(now)
 }
 
 func (s *Service) loopUpdate() {
@@ -135,25 +217,58 @@ func (s *Service) loopUpdate() {
 	}
 }
 
-func (s *Service) update() error {
-	ctx := s.ctx
-	var cancel context.CancelFunc
-	if s.timeout > 0 {
-		ctx, cancel = context.WithTimeout(ctx, s.timeout)
-	}
-	response, err := Exchange(ctx, s.dialer, s.server)
-	if cancel != nil {
-		cancel()
+// Statistics returns the client statistics of the last update, or nil.
+func (s *Service) Statistics() *Statistics {
+	if s.client == nil {
+		return nil
 	}
+	return s.client.Statistics()
+}
+
+func (s *Service) update() error {
+	statistics, err := s.client.Update(s.ctx)
 	if err != nil {
 		return err
 	}
-	s.clockOffset = response.ClockOffset
+	s.adjust(time.Now(), statistics.Offset)
+	return nil
+}
+
+func (s *Service) adjust(now time.Time, offset time.Duration) {
+	current := s.clockOffset(now)
+	difference := offset - current
+	if s.slew && s.synchronized && difference <= s.stepThreshold && difference >= -s.stepThreshold {
+		s.clock.Store(&clockState{
+			start: now,
+			from:  current,
+			to:    offset,
+			rate:  s.maxSlewRate,
+		})
+		return
+	}
+	s.step(now, difference, offset)
+}
+
+func (s *Service) step(now time.Time, difference time.Duration, offset time.Duration) {
+	event := StepEvent{
+		Time:    now.Add(offset),
+		Step:    difference,
+		Offset:  offset,
+		Initial: !s.synchronized,
+	}
+	s.synchronized = true
 	if s.writeToSystem {
-		writeErr := SetSystemTime(s.TimeFunc()())
+		writeErr := SetSystemTime(now.Add(offset))
 		if writeErr != nil {
 			s.logger.Error("write time to system: ", writeErr)
+		} else {
+			event.WriteToSystem = true
+			offset = 0
 		}
 	}
-	return nil
+	s.clock.Store(&clockState{from: offset, to: offset})
+	if s.slew && !event.Initial {
+		s.logger.Warn("step time by ", difference)
+	}
+	s.emitStep(event)
 }
@@ -0,0 +1,92 @@
+package ntp
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClockStateSlew(t *testing.T) {
+	t.Parallel()
+	start := time.Now()
+	clock := &clockState{start: start, from: time.Second, to: 900 * time.Millisecond, rate: defaultMaxSlewRate}
+	require.Equal(t, time.Second, clock.offset(start))
+	require.Equal(t, 950*time.Millisecond, clock.offset(start.Add(100*time.Second)))
+	require.Equal(t, 900*time.Millisecond, clock.offset(start.Add(time.Hour)))
+	clock = &clockState{start: start, from: -time.Second, to: time.Second, rate: 0.5}
+	require.Equal(t, 0*time.Second, clock.offset(start.Add(2*time.Second)))
+	require.Equal(t, time.Second, clock.offset(start.Add(time.Minute)))
+	// time served through a slewed offset never goes backwards
+	clock = &clockState{start: start, from: time.Second, to: -time.Second, rate: defaultMaxSlewRate}
+	previous := start.Add(clock.offset(start))
+	for i := 1; i <= 100; i++ {
+		now := start.Add(time.Duration(i) * 10 * time.Second)
+		served := now.Add(clock.offset(now))
+		require.True(t, served.After(previous))
+		previous = served
+	}
+}
+
+func TestServiceSlew(t *testing.T) {
+	t.Parallel()
+	ntpServer := startTestNTPServer(t, time.Second, nil)
+	service := NewService(Options{
+		Server: ntpServer.address(),
+		Slew:   true,
+	})
+	defer service.Close()
+	subscription, _, err := service.Subscribe()
+	require.NoError(t, err)
+	require.NoError(t, service.Start())
+
+	var event StepEvent
+	select {
+	case event = <-subscription:
+	case <-time.After(time.Second):
+		t.Fatal("missing initial step event")
+	}
+	require.True(t, event.Initial)
+	require.InDelta(t, time.Second, event.Offset, float64(50*time.Millisecond))
+	require.InDelta(t, float64(time.Second), float64(service.TimeFunc()().Sub(time.Now())), float64(50*time.Millisecond))
+
+	now := time.Now()
+	current := service.clockOffset(now)
+	service.adjust(now, current+100*time.Millisecond)
+	require.Equal(t, current, service.clockOffset(now))
+	require.Equal(t, current+50*time.Millisecond, service.clockOffset(now.Add(100*time.Second)))
+	require.Equal(t, current+100*time.Millisecond, service.clockOffset(now.Add(time.Hour)))
+	select {
+	case event = <-subscription:
+		t.Fatal("unexpected step event: ", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	service.adjust(now, current+time.Second)
+	require.Equal(t, current+time.Second, service.clockOffset(now))
+	select {
+	case event = <-subscription:
+	case <-time.After(time.Second):
+		t.Fatal("missing step event")
+	}
+	require.False(t, event.Initial)
+	require.Equal(t, time.Second, event.Step)
+}
+
+func TestServiceSubscription(t *testing.T) {
+	t.Parallel()
+	service := NewService(Options{})
+	subscription, done, err := service.Subscribe()
+	require.NoError(t, err)
+	service.UnSubscribe(subscription)
+	<-done
+	subscription, done, err = service.Subscribe()
+	require.NoError(t, err)
+	service.step(time.Now(), time.Second, time.Second)
+	require.Equal(t, time.Second, (<-subscription).Offset)
+	require.NoError(t, service.Close())
+	<-done
+	_, _, err = service.Subscribe()
+	require.ErrorIs(t, err, os.ErrClosed)
+}
@@ -0,0 +1,47 @@
+package ntp
+
+import "time"
+
+const (
+	defaultMaxSlewRate   = 500e-6
+	defaultStepThreshold = 128 * time.Millisecond
+)
+
+// StepEvent is published by Service when the corrected time jumps instead of being slewed.
+type StepEvent struct {
+	// Time is the corrected time after the step.
+	Time time.Time
+	// Step is the amount the corrected time jumped by.
+	Step time.Duration
+	// Offset is the new clock offset, before being written to the system clock.
+	Offset time.Duration
+	// Initial is set for the first synchronization of the service.
+	Initial bool
+	// WriteToSystem is set if the step was applied to the system clock.
+	WriteToSystem bool
+}
+
+// clockState moves the clock offset from one value to another at a bounded rate.
+type clockState struct {
+	start time.Time
+	from  time.Duration
+	to    time.Duration
+	rate  float64
+}
+
+func (c *clockState) offset(now time.Time) time.Duration {
+	if c.from == c.to {
+		return c.to
+	}
+	correction := durationFromSeconds(now.Sub(c.start).Seconds() * c.rate)
+	if c.to > c.from {
+		if c.to-c.from <= correction {
+			return c.to
+		}
+		return c.from + correction
+	}
+	if c.from-c.to <= correction {
+		return c.to
+	}
+	return c.from - correction
+}
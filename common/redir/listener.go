@@ -0,0 +1,114 @@
+package redir
+
+import (
+	"context"
+	"net"
+
+	"github.com/sagernet/sing/common/control"
+	E "github.com/sagernet/sing/common/exceptions"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+type Mode uint8
+
+const (
+	ModeRedirect Mode = iota
+	ModeTProxy
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeRedirect:
+		return "redirect"
+	case ModeTProxy:
+		return "tproxy"
+	default:
+		return "unknown"
+	}
+}
+
+type TCPListener struct {
+	net.Listener
+	mode Mode
+}
+
+func NewTCPListener(listener net.Listener, mode Mode) *TCPListener {
+	return &TCPListener{
+		Listener: listener,
+		mode:     mode,
+	}
+}
+
+func ListenTCP(ctx context.Context, address M.Socksaddr, mode Mode) (*TCPListener, error) {
+	var listenConfig net.ListenConfig
+	if mode == ModeTProxy {
+		listenConfig.Control = control.Append(listenConfig.Control, TProxy())
+	}
+	listener, err := listenConfig.Listen(ctx, N.NetworkTCP, address.String())
+	if err != nil {
+		return nil, err
+	}
+	return NewTCPListener(listener, mode), nil
+}
+
+func (l *TCPListener) Mode() Mode {
+	return l.mode
+}
+
+func (l *TCPListener) AcceptMetadata() (net.Conn, M.Metadata, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, M.Metadata{}, err
+	}
+	destination, err := OriginalDestination(conn, l.mode)
+	if err != nil {
+		conn.Close()
+		return nil, M.Metadata{}, E.Cause(err, l.mode.String(), ": get original destination")
+	}
+	return conn, M.Metadata{
+		Source:      M.SocksaddrFromNet(conn.RemoteAddr()).Unwrap(),
+		Destination: destination,
+	}, nil
+}
+
+func (l *TCPListener) Serve(ctx context.Context, handler N.TCPConnectionHandler, errorHandler E.Handler) error {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return err
+		}
+		destination, err := OriginalDestination(conn, l.mode)
+		if err != nil {
+			conn.Close()
+			errorHandler.NewError(ctx, E.Cause(err, l.mode.String(), ": get original destination"))
+			continue
+		}
+		metadata := M.Metadata{
+			Source:      M.SocksaddrFromNet(conn.RemoteAddr()).Unwrap(),
+			Destination: destination,
+		}
+		go func() {
+			hErr := handler.NewConnection(ctx, conn, metadata)
+			if hErr != nil {
+				conn.Close()
+				errorHandler.NewError(ctx, E.Cause(hErr, "process connection from ", metadata.Source))
+			}
+		}()
+	}
+}
+
+func OriginalDestination(conn net.Conn, mode Mode) (M.Socksaddr, error) {
+	switch mode {
+	case ModeRedirect:
+		destination, err := control.GetOriginalDestination(conn)
+		if err != nil {
+			return M.Socksaddr{}, err
+		}
+		return M.SocksaddrFromNetIP(destination).Unwrap(), nil
+	case ModeTProxy:
+		return M.SocksaddrFromNet(conn.LocalAddr()).Unwrap(), nil
+	default:
+		return M.Socksaddr{}, E.New("unknown mode: ", mode)
+	}
+}
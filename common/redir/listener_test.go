@@ -0,0 +1,52 @@
+package redir_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	M "github.com/sagernet/sing/common/metadata"
+	"github.com/sagernet/sing/common/redir"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestModeString(t *testing.T) {
+	t.Parallel()
+	require.Equal(t, "redirect", redir.ModeRedirect.String())
+	require.Equal(t, "tproxy", redir.ModeTProxy.String())
+	require.Equal(t, "unknown", redir.Mode(42).String())
+}
+
+func TestOriginalDestination(t *testing.T) {
+	t.Parallel()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	tcpListener := redir.NewTCPListener(listener, redir.ModeTProxy)
+	require.Equal(t, redir.ModeTProxy, tcpListener.Mode())
+	go func() {
+		conn, dialErr := net.Dial("tcp", listener.Addr().String())
+		if dialErr == nil {
+			defer conn.Close()
+			conn.Read(make([]byte, 1))
+		}
+	}()
+	conn, metadata, err := tcpListener.AcceptMetadata()
+	require.NoError(t, err)
+	defer conn.Close()
+	// with TProxy, the original destination is the local address of the accepted socket
+	require.Equal(t, listener.Addr().String(), metadata.Destination.String())
+	require.True(t, metadata.Source.Addr.IsLoopback())
+
+	_, err = redir.OriginalDestination(conn, redir.Mode(42))
+	require.Error(t, err)
+}
+
+func TestListenTCPRedirect(t *testing.T) {
+	t.Parallel()
+	listener, err := redir.ListenTCP(context.Background(), M.ParseSocksaddr("127.0.0.1:0"), redir.ModeRedirect)
+	require.NoError(t, err)
+	require.Equal(t, redir.ModeRedirect, listener.Mode())
+	require.NoError(t, listener.Close())
+}
@@ -0,0 +1,22 @@
+package redir
+
+import (
+	"syscall"
+
+	"github.com/sagernet/sing/common/control"
+	M "github.com/sagernet/sing/common/metadata"
+
+	"golang.org/x/sys/unix"
+)
+
+func TProxy() control.Func {
+	return func(network, address string, conn syscall.RawConn) error {
+		return control.Raw(conn, func(fd uintptr) error {
+			family := unix.AF_INET6
+			if M.ParseSocksaddr(address).Addr.Is4() {
+				family = unix.AF_INET
+			}
+			return control.TProxy(fd, family)
+		})
+	}
+}
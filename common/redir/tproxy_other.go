@@ -0,0 +1,16 @@
+//go:build !linux
+
+package redir
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/sagernet/sing/common/control"
+)
+
+func TProxy() control.Func {
+	return func(network, address string, conn syscall.RawConn) error {
+		return os.ErrInvalid
+	}
+}
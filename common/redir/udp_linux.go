@@ -0,0 +1,114 @@
+package redir
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/buf"
+	"github.com/sagernet/sing/common/control"
+	E "github.com/sagernet/sing/common/exceptions"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/common/udpnat"
+)
+
+type UDPListener struct {
+	*net.UDPConn
+	service *udpnat.Service[netip.AddrPort]
+}
+
+func ListenUDP(ctx context.Context, address M.Socksaddr, timeout int64, handler udpnat.Handler) (*UDPListener, error) {
+	var listenConfig net.ListenConfig
+	listenConfig.Control = control.Append(listenConfig.Control, TProxy())
+	packetConn, err := listenConfig.ListenPacket(ctx, N.NetworkUDP, address.String())
+	if err != nil {
+		return nil, err
+	}
+	return NewUDPListener(packetConn.(*net.UDPConn), timeout, handler), nil
+}
+
+// NewUDPListener wraps a socket that already has IP_TRANSPARENT and IP_RECVORIGDSTADDR enabled.
+func NewUDPListener(conn *net.UDPConn, timeout int64, handler udpnat.Handler) *UDPListener {
+	return &UDPListener{
+		UDPConn: conn,
+		service: udpnat.New[netip.AddrPort](timeout, handler),
+	}
+}
+
+func (l *UDPListener) Serve(ctx context.Context, errorHandler E.Handler) error {
+	oob := make([]byte, 1024)
+	for {
+		buffer := buf.NewPacket()
+		n, oobN, _, source, err := l.ReadMsgUDPAddrPort(buffer.FreeBytes(), oob)
+		if err != nil {
+			buffer.Release()
+			return err
+		}
+		buffer.Truncate(n)
+		destination, err := control.GetOriginalDestinationFromOOB(oob[:oobN])
+		if err != nil {
+			buffer.Release()
+			errorHandler.NewError(ctx, E.Cause(err, "tproxy: get original destination"))
+			continue
+		}
+		source = netip.AddrPortFrom(source.Addr().Unmap(), source.Port())
+		metadata := M.Metadata{
+			Source:      M.SocksaddrFromNetIP(source),
+			Destination: M.SocksaddrFromNetIP(destination).Unwrap(),
+		}
+		l.service.NewPacket(ctx, source, buffer, metadata, func(natConn N.PacketConn) N.PacketWriter {
+			return &writeBackWriter{
+				ctx:         ctx,
+				source:      source,
+				destination: metadata.Destination,
+			}
+		})
+	}
+}
+
+type writeBackWriter struct {
+	ctx         context.Context
+	source      netip.AddrPort
+	destination M.Socksaddr
+	access      sync.Mutex
+	conn        *net.UDPConn
+}
+
+func (w *writeBackWriter) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
+	defer buffer.Release()
+	w.access.Lock()
+	defer w.access.Unlock()
+	if destination == w.destination && w.conn != nil {
+		return common.Error(w.conn.WriteToUDPAddrPort(buffer.Bytes(), w.source))
+	}
+	udpConn, err := listenWriteBack(w.ctx, destination)
+	if err != nil {
+		return E.Cause(err, "tproxy: listen write back for ", destination)
+	}
+	if destination == w.destination {
+		w.conn = udpConn
+	} else {
+		defer udpConn.Close()
+	}
+	return common.Error(udpConn.WriteToUDPAddrPort(buffer.Bytes(), w.source))
+}
+
+func (w *writeBackWriter) Close() error {
+	w.access.Lock()
+	defer w.access.Unlock()
+	return common.Close(common.PtrOrNil(w.conn))
+}
+
+func listenWriteBack(ctx context.Context, destination M.Socksaddr) (*net.UDPConn, error) {
+	var listenConfig net.ListenConfig
+	listenConfig.Control = control.Append(listenConfig.Control, control.ReuseAddr())
+	listenConfig.Control = control.Append(listenConfig.Control, control.TProxyWriteBack())
+	packetConn, err := listenConfig.ListenPacket(ctx, N.NetworkUDP, destination.String())
+	if err != nil {
+		return nil, err
+	}
+	return packetConn.(*net.UDPConn), nil
+}
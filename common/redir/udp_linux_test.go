@@ -0,0 +1,50 @@
+package redir_test
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"unsafe"
+
+	"github.com/sagernet/sing/common/control"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func controlMessage(level int32, messageType int32, data []byte) []byte {
+	message := make([]byte, unix.CmsgSpace(len(data)))
+	header := (*unix.Cmsghdr)(unsafe.Pointer(&message[0]))
+	header.Level = level
+	header.Type = messageType
+	header.SetLen(unix.CmsgLen(len(data)))
+	copy(message[unix.CmsgLen(0):], data)
+	return message
+}
+
+func TestOriginalDestinationFromOOB(t *testing.T) {
+	t.Parallel()
+	// struct sockaddr_in
+	inet4 := make([]byte, unix.SizeofSockaddrInet4)
+	binary.LittleEndian.PutUint16(inet4[0:2], unix.AF_INET)
+	binary.BigEndian.PutUint16(inet4[2:4], 53)
+	copy(inet4[4:8], []byte{1, 1, 1, 1})
+	// a preceding unrelated message is skipped
+	oob := append(controlMessage(unix.SOL_IP, unix.IP_TTL, []byte{64, 0, 0, 0}), controlMessage(unix.SOL_IP, unix.IP_RECVORIGDSTADDR, inet4)...)
+	destination, err := control.GetOriginalDestinationFromOOB(oob)
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParseAddrPort("1.1.1.1:53"), destination)
+
+	// struct sockaddr_in6
+	inet6 := make([]byte, unix.SizeofSockaddrInet6)
+	binary.LittleEndian.PutUint16(inet6[0:2], unix.AF_INET6)
+	binary.BigEndian.PutUint16(inet6[2:4], 443)
+	address := netip.MustParseAddr("2001:db8::1").As16()
+	copy(inet6[8:24], address[:])
+	destination, err = control.GetOriginalDestinationFromOOB(controlMessage(unix.SOL_IPV6, unix.IPV6_RECVORIGDSTADDR, inet6))
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParseAddrPort("[2001:db8::1]:443"), destination)
+
+	_, err = control.GetOriginalDestinationFromOOB(controlMessage(unix.SOL_IP, unix.IP_TTL, []byte{64, 0, 0, 0}))
+	require.Error(t, err)
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+
+package redir
+
+import (
+	"context"
+	"net"
+	"os"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	M "github.com/sagernet/sing/common/metadata"
+	"github.com/sagernet/sing/common/udpnat"
+)
+
+type UDPListener struct {
+	*net.UDPConn
+}
+
+func ListenUDP(ctx context.Context, address M.Socksaddr, timeout int64, handler udpnat.Handler) (*UDPListener, error) {
+	return nil, os.ErrInvalid
+}
+
+func NewUDPListener(conn *net.UDPConn, timeout int64, handler udpnat.Handler) *UDPListener {
+	return &UDPListener{UDPConn: conn}
+}
+
+func (l *UDPListener) Serve(ctx context.Context, errorHandler E.Handler) error {
+	return os.ErrInvalid
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package redir_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	M "github.com/sagernet/sing/common/metadata"
+	"github.com/sagernet/sing/common/redir"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStubs(t *testing.T) {
+	t.Parallel()
+	_, err := redir.ListenUDP(context.Background(), M.ParseSocksaddr("127.0.0.1:0"), 0, nil)
+	require.ErrorIs(t, err, os.ErrInvalid)
+	require.ErrorIs(t, redir.NewUDPListener(nil, 0, nil).Serve(context.Background(), nil), os.ErrInvalid)
+	require.ErrorIs(t, redir.TProxy()("udp", "127.0.0.1:0", nil), os.ErrInvalid)
+	_, err = redir.ListenTCP(context.Background(), M.ParseSocksaddr("127.0.0.1:0"), redir.ModeTProxy)
+	require.ErrorIs(t, err, os.ErrInvalid)
+}
@@ -0,0 +1,68 @@
+package sniff
+
+import (
+	std_bufio "bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+var httpMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodOptions,
+	http.MethodTrace,
+}
+
+func HTTPHost(ctx context.Context, reader *bytes.Reader) (*Result, error) {
+	data := make([]byte, reader.Len())
+	reader.ReadAt(data, 0)
+	if !hasHTTPMethodPrefix(data) {
+		return nil, E.New("not HTTP")
+	}
+	if !bytes.Contains(data, []byte("\r\n\r\n")) {
+		return nil, ErrNeedMoreData
+	}
+	request, err := http.ReadRequest(std_bufio.NewReader(reader))
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNeedMoreData
+		}
+		return nil, err
+	}
+	if request.Host == "" {
+		return nil, E.New("HTTP request without host")
+	}
+	host := request.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return &Result{
+		Protocol: ProtocolHTTP,
+		Domain:   host,
+	}, nil
+}
+
+func hasHTTPMethodPrefix(data []byte) bool {
+	for _, method := range httpMethods {
+		prefix := method + " "
+		if len(data) < len(prefix) {
+			if prefix[:len(data)] == string(data) {
+				return true
+			}
+		} else if string(data[:len(prefix)]) == prefix {
+			return true
+		}
+	}
+	return false
+}
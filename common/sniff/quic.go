@@ -0,0 +1,291 @@
+package sniff
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"encoding/binary"
+	"sort"
+
+	_ "crypto/sha256"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/tls"
+)
+
+const (
+	quicVersion1 uint32 = 0x00000001
+	quicVersion2 uint32 = 0x6b3343cf
+)
+
+var (
+	quicSaltV1 = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+	quicSaltV2 = []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9}
+)
+
+const (
+	quicFramePadding         = 0x00
+	quicFramePing            = 0x01
+	quicFrameACK             = 0x02
+	quicFrameACKECN          = 0x03
+	quicFrameCrypto          = 0x06
+	quicFrameConnectionClose = 0x1c
+)
+
+type quicCryptoFragment struct {
+	offset uint64
+	data   []byte
+}
+
+// QUICClientHello decrypts the Initial packets coalesced in a client datagram and parses the ClientHello
+// carried in their CRYPTO frames. ErrNeedMoreData is returned if the ClientHello continues in later datagrams.
+func QUICClientHello(ctx context.Context, packet []byte) (*Result, error) {
+	var fragments []quicCryptoFragment
+	for len(packet) > 0 {
+		if packet[0]&0x80 == 0 {
+			break
+		}
+		plaintext, next, err := decryptQUICInitial(packet)
+		if err != nil {
+			if fragments != nil {
+				break
+			}
+			return nil, err
+		}
+		packet = next
+		frameFragments, err := readQUICCryptoFrames(plaintext)
+		if err != nil {
+			return nil, err
+		}
+		fragments = append(fragments, frameFragments...)
+	}
+	if len(fragments) == 0 {
+		return nil, E.New("QUIC Initial without CRYPTO frames")
+	}
+	message := reassembleQUICCrypto(fragments)
+	if len(message) < 4 {
+		return nil, ErrNeedMoreData
+	}
+	if message[0] != tls.HandshakeTypeClientHello {
+		return nil, tls.ErrNotClientHello
+	}
+	messageLen := 4 + (int(message[1])<<16 | int(message[2])<<8 | int(message[3]))
+	if len(message) < messageLen {
+		return nil, ErrNeedMoreData
+	}
+	clientHello, err := tls.ParseClientHello(message[:messageLen])
+	if err != nil {
+		return nil, err
+	}
+	return clientHelloResult(ProtocolQUIC, clientHello), nil
+}
+
+func decryptQUICInitial(packet []byte) (plaintext []byte, next []byte, err error) {
+	if len(packet) < 7 || packet[0]&0x40 == 0 {
+		return nil, nil, E.New("not a QUIC long header packet")
+	}
+	version := binary.BigEndian.Uint32(packet[1:5])
+	var (
+		salt        []byte
+		labelPrefix string
+		initialType byte
+	)
+	switch version {
+	case quicVersion1:
+		salt, labelPrefix, initialType = quicSaltV1, "quic ", 0
+	case quicVersion2:
+		salt, labelPrefix, initialType = quicSaltV2, "quicv2 ", 1
+	default:
+		return nil, nil, E.New("unsupported QUIC version: ", version)
+	}
+	if (packet[0]&0x30)>>4 != initialType {
+		return nil, nil, E.New("not a QUIC Initial packet")
+	}
+	offset := 5
+	dcidLen := int(packet[offset])
+	offset++
+	if dcidLen > 20 || len(packet) < offset+dcidLen+1 {
+		return nil, nil, E.New("invalid QUIC destination connection ID")
+	}
+	dcid := packet[offset : offset+dcidLen]
+	offset += dcidLen
+	scidLen := int(packet[offset])
+	offset += 1 + scidLen
+	if scidLen > 20 || len(packet) < offset {
+		return nil, nil, E.New("invalid QUIC source connection ID")
+	}
+	tokenLen, n := readQUICVarint(packet[offset:])
+	if n == 0 || uint64(len(packet)-offset-n) < tokenLen {
+		return nil, nil, E.New("invalid QUIC token")
+	}
+	offset += n + int(tokenLen)
+	length, n := readQUICVarint(packet[offset:])
+	if n == 0 || uint64(len(packet)-offset-n) < length {
+		return nil, nil, E.New("invalid QUIC packet length")
+	}
+	offset += n
+	pnOffset := offset
+	packetEnd := pnOffset + int(length)
+	if pnOffset+4+16 > packetEnd {
+		return nil, nil, E.New("QUIC packet too short")
+	}
+	key, iv, hp := quicClientInitialKeys(salt, dcid, labelPrefix)
+	hpBlock, err := aes.NewCipher(hp)
+	if err != nil {
+		return nil, nil, err
+	}
+	mask := make([]byte, aes.BlockSize)
+	hpBlock.Encrypt(mask, packet[pnOffset+4:pnOffset+4+16])
+	header := make([]byte, pnOffset+4)
+	copy(header, packet[:pnOffset+4])
+	header[0] ^= mask[0] & 0x0f
+	pnLen := int(header[0]&0x03) + 1
+	var packetNumber uint64
+	for i := 0; i < pnLen; i++ {
+		header[pnOffset+i] ^= mask[1+i]
+		packetNumber = packetNumber<<8 | uint64(header[pnOffset+i])
+	}
+	header = header[:pnOffset+pnLen]
+	nonce := make([]byte, len(iv))
+	copy(nonce, iv)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(packetNumber >> (8 * i))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err = aead.Open(nil, nonce, packet[pnOffset+pnLen:packetEnd], header)
+	if err != nil {
+		return nil, nil, E.Cause(err, "decrypt QUIC Initial")
+	}
+	return plaintext, packet[packetEnd:], nil
+}
+
+func readQUICCryptoFrames(payload []byte) ([]quicCryptoFragment, error) {
+	var fragments []quicCryptoFragment
+	for len(payload) > 0 {
+		frameType := payload[0]
+		payload = payload[1:]
+		switch frameType {
+		case quicFramePadding, quicFramePing:
+		case quicFrameACK, quicFrameACKECN:
+			fieldCount := 4
+			var rangeCount uint64
+			for i := 0; i < fieldCount; i++ {
+				value, n := readQUICVarint(payload)
+				if n == 0 {
+					return nil, E.New("invalid QUIC ACK frame")
+				}
+				payload = payload[n:]
+				if i == 2 {
+					rangeCount = value
+				}
+			}
+			extra := rangeCount * 2
+			if frameType == quicFrameACKECN {
+				extra += 3
+			}
+			for i := uint64(0); i < extra; i++ {
+				_, n := readQUICVarint(payload)
+				if n == 0 {
+					return nil, E.New("invalid QUIC ACK frame")
+				}
+				payload = payload[n:]
+			}
+		case quicFrameCrypto:
+			offset, n := readQUICVarint(payload)
+			if n == 0 {
+				return nil, E.New("invalid QUIC CRYPTO frame")
+			}
+			payload = payload[n:]
+			length, n := readQUICVarint(payload)
+			if n == 0 || uint64(len(payload)-n) < length {
+				return nil, E.New("invalid QUIC CRYPTO frame")
+			}
+			payload = payload[n:]
+			fragments = append(fragments, quicCryptoFragment{offset, payload[:length]})
+			payload = payload[length:]
+		case quicFrameConnectionClose:
+			return nil, E.New("QUIC connection closed by client")
+		default:
+			return nil, E.New("unexpected QUIC frame in Initial packet: ", frameType)
+		}
+	}
+	return fragments, nil
+}
+
+func reassembleQUICCrypto(fragments []quicCryptoFragment) []byte {
+	sort.SliceStable(fragments, func(i, j int) bool {
+		return fragments[i].offset < fragments[j].offset
+	})
+	var message []byte
+	for _, fragment := range fragments {
+		end := fragment.offset + uint64(len(fragment.data))
+		if fragment.offset > uint64(len(message)) {
+			break
+		}
+		if end > uint64(len(message)) {
+			message = append(message, fragment.data[uint64(len(message))-fragment.offset:]...)
+		}
+	}
+	return message
+}
+
+func readQUICVarint(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	length := 1 << (b[0] >> 6)
+	if len(b) < length {
+		return 0, 0
+	}
+	value := uint64(b[0] & 0x3f)
+	for i := 1; i < length; i++ {
+		value = value<<8 | uint64(b[i])
+	}
+	return value, length
+}
+
+func quicClientInitialKeys(salt []byte, dcid []byte, labelPrefix string) (key, iv, hp []byte) {
+	initialSecret := hkdfExtract(salt, dcid)
+	clientSecret := hkdfExpandLabel(initialSecret, "client in", 32)
+	key = hkdfExpandLabel(clientSecret, labelPrefix+"key", 16)
+	iv = hkdfExpandLabel(clientSecret, labelPrefix+"iv", 12)
+	hp = hkdfExpandLabel(clientSecret, labelPrefix+"hp", 16)
+	return
+}
+
+func hkdfExtract(salt []byte, secret []byte) []byte {
+	mac := hmac.New(crypto.SHA256.New, salt)
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	fullLabel := "tls13 " + label
+	info := make([]byte, 0, 4+len(fullLabel))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len(fullLabel)))
+	info = append(info, fullLabel...)
+	info = append(info, 0)
+	var (
+		output   []byte
+		previous []byte
+	)
+	for counter := byte(1); len(output) < length; counter++ {
+		mac := hmac.New(crypto.SHA256.New, secret)
+		mac.Write(previous)
+		mac.Write(info)
+		mac.Write([]byte{counter})
+		previous = mac.Sum(nil)
+		output = append(output, previous...)
+	}
+	return output[:length]
+}
@@ -0,0 +1,124 @@
+package sniff
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/sagernet/sing/common/buf"
+	"github.com/sagernet/sing/common/bufio"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/tls"
+)
+
+const (
+	ProtocolTLS  = "tls"
+	ProtocolHTTP = "http"
+	ProtocolQUIC = "quic"
+)
+
+var ErrNeedMoreData = E.New("need more data")
+
+type Result struct {
+	Protocol          string
+	Domain            string
+	ALPN              []string
+	SupportedVersions []uint16
+	ECH               bool
+	ClientHello       *tls.ClientHello
+}
+
+type (
+	StreamSniffer = func(ctx context.Context, reader *bytes.Reader) (*Result, error)
+	PacketSniffer = func(ctx context.Context, packet []byte) (*Result, error)
+)
+
+func DefaultStreamSniffers() []StreamSniffer {
+	return []StreamSniffer{TLSClientHello, HTTPHost}
+}
+
+// PeekStream reads from conn into buffer until one of sniffers succeeds, all of them reject the data,
+// the buffer is full or timeout is reached. The bytes read stay in buffer; wrap conn with
+// bufio.NewCachedConn to replay them.
+func PeekStream(ctx context.Context, conn net.Conn, buffer *buf.Buffer, timeout time.Duration, sniffers ...StreamSniffer) (*Result, error) {
+	if len(sniffers) == 0 {
+		sniffers = DefaultStreamSniffers()
+	}
+	if buffer.Len() > 0 {
+		result, err := sniffStream(ctx, buffer.Bytes(), sniffers)
+		if !errors.Is(err, ErrNeedMoreData) {
+			return result, err
+		}
+	}
+	if timeout > 0 {
+		err := conn.SetReadDeadline(time.Now().Add(timeout))
+		if err != nil {
+			return nil, err
+		}
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	var lastErr error
+	for !buffer.IsFull() {
+		_, err := buffer.ReadOnceFrom(conn)
+		if err != nil {
+			if lastErr != nil {
+				return nil, E.Errors(lastErr, err)
+			}
+			return nil, err
+		}
+		var result *Result
+		result, lastErr = sniffStream(ctx, buffer.Bytes(), sniffers)
+		if !errors.Is(lastErr, ErrNeedMoreData) {
+			return result, lastErr
+		}
+	}
+	return nil, lastErr
+}
+
+// PeekConn sniffs conn and returns a connection that replays the bytes consumed while sniffing.
+func PeekConn(ctx context.Context, conn net.Conn, timeout time.Duration, sniffers ...StreamSniffer) (*bufio.CachedConn, *Result, error) {
+	buffer := buf.NewPacket()
+	result, err := PeekStream(ctx, conn, buffer, timeout, sniffers...)
+	cachedConn := bufio.NewCachedConn(conn, buffer)
+	buffer.Release()
+	return cachedConn, result, err
+}
+
+func PeekPacket(ctx context.Context, packet []byte, sniffers ...PacketSniffer) (*Result, error) {
+	if len(sniffers) == 0 {
+		sniffers = []PacketSniffer{QUICClientHello}
+	}
+	var errs []error
+	for _, sniffer := range sniffers {
+		result, err := sniffer(ctx, packet)
+		if err == nil {
+			return result, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, E.Errors(errs...)
+}
+
+func sniffStream(ctx context.Context, data []byte, sniffers []StreamSniffer) (*Result, error) {
+	var (
+		errs         []error
+		needMoreData bool
+	)
+	for _, sniffer := range sniffers {
+		result, err := sniffer(ctx, bytes.NewReader(data))
+		if err == nil {
+			return result, nil
+		}
+		if errors.Is(err, ErrNeedMoreData) {
+			needMoreData = true
+		} else {
+			errs = append(errs, err)
+		}
+	}
+	if needMoreData {
+		return nil, ErrNeedMoreData
+	}
+	return nil, E.Errors(errs...)
+}
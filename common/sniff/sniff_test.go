@@ -0,0 +1,174 @@
+package sniff
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sagernet/sing/common/buf"
+
+	"github.com/stretchr/testify/require"
+)
+
+func captureClientHello(t *testing.T, config *tls.Config) []byte {
+	client, server := net.Pipe()
+	go func() {
+		tls.Client(client, config).Handshake()
+	}()
+	buffer := buf.NewPacket()
+	defer buffer.Release()
+	_, err := PeekStream(context.Background(), server, buffer, time.Second, TLSClientHello)
+	require.NoError(t, err)
+	client.Close()
+	server.Close()
+	return bytes.Clone(buffer.Bytes())
+}
+
+func TestSniffTLS(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	go func() {
+		tls.Client(client, &tls.Config{
+			ServerName: "example.com",
+			NextProtos: []string{"h2", "http/1.1"},
+		}).Handshake()
+	}()
+	cachedConn, result, err := PeekConn(context.Background(), server, time.Second)
+	require.NoError(t, err)
+	defer cachedConn.Close()
+	defer client.Close()
+	require.Equal(t, ProtocolTLS, result.Protocol)
+	require.Equal(t, "example.com", result.Domain)
+	require.Equal(t, []string{"h2", "http/1.1"}, result.ALPN)
+	require.Contains(t, result.SupportedVersions, uint16(tls.VersionTLS13))
+	require.False(t, result.ECH)
+	cached := cachedConn.ReadCached()
+	require.NotNil(t, cached)
+	require.Equal(t, result.ClientHello.Raw, cached.Bytes()[5:5+len(result.ClientHello.Raw)])
+}
+
+func TestSniffTLSIncomplete(t *testing.T) {
+	t.Parallel()
+	record := captureClientHello(t, &tls.Config{ServerName: "example.com"})
+	for _, n := range []int{1, 5, 20, len(record) - 1} {
+		_, err := TLSClientHello(context.Background(), bytes.NewReader(record[:n]))
+		require.ErrorIs(t, err, ErrNeedMoreData)
+	}
+	result, err := TLSClientHello(context.Background(), bytes.NewReader(record))
+	require.NoError(t, err)
+	require.Equal(t, "example.com", result.Domain)
+}
+
+func TestSniffHTTP(t *testing.T) {
+	t.Parallel()
+	request := []byte("GET / HTTP/1.1\r\nHost: example.com:8080\r\nUser-Agent: test\r\n\r\n")
+	_, err := HTTPHost(context.Background(), bytes.NewReader(request[:20]))
+	require.ErrorIs(t, err, ErrNeedMoreData)
+	result, err := HTTPHost(context.Background(), bytes.NewReader(request))
+	require.NoError(t, err)
+	require.Equal(t, ProtocolHTTP, result.Protocol)
+	require.Equal(t, "example.com", result.Domain)
+	_, err = HTTPHost(context.Background(), bytes.NewReader([]byte{0x16, 0x03, 0x01}))
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrNeedMoreData)
+}
+
+func TestQUICInitialKeys(t *testing.T) {
+	t.Parallel()
+	// RFC 9001, Appendix A.1
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	key, iv, hp := quicClientInitialKeys(quicSaltV1, dcid, "quic ")
+	require.Equal(t, "1f369613dd76d5467730efcbe3b1a22d", hex.EncodeToString(key))
+	require.Equal(t, "fa044b2f42a3fd3b46fb255c", hex.EncodeToString(iv))
+	require.Equal(t, "9f50449e04a0e810283a1e9933adedd2", hex.EncodeToString(hp))
+}
+
+func TestSniffQUIC(t *testing.T) {
+	t.Parallel()
+	record := captureClientHello(t, &tls.Config{
+		ServerName: "quic.example.com",
+		NextProtos: []string{"h3"},
+		MinVersion: tls.VersionTLS13,
+	})
+	message := record[5:]
+	for _, version := range []uint32{quicVersion1, quicVersion2} {
+		dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+		half := len(message) / 2
+		var payload []byte
+		// out of order CRYPTO frames, as sent by some browsers
+		payload = appendCryptoFrame(payload, uint64(half), message[half:])
+		payload = append(payload, quicFramePing)
+		payload = appendCryptoFrame(payload, 0, message[:half])
+		packet := sealQUICInitial(t, version, dcid, payload)
+		result, err := PeekPacket(context.Background(), packet)
+		require.NoError(t, err)
+		require.Equal(t, ProtocolQUIC, result.Protocol)
+		require.Equal(t, "quic.example.com", result.Domain)
+		require.Equal(t, []string{"h3"}, result.ALPN)
+
+		partial := sealQUICInitial(t, version, dcid, appendCryptoFrame(nil, 0, message[:half]))
+		_, err = QUICClientHello(context.Background(), partial)
+		require.ErrorIs(t, err, ErrNeedMoreData)
+	}
+}
+
+func appendCryptoFrame(payload []byte, offset uint64, data []byte) []byte {
+	payload = append(payload, quicFrameCrypto)
+	payload = appendQUICVarint(payload, offset)
+	payload = appendQUICVarint(payload, uint64(len(data)))
+	return append(payload, data...)
+}
+
+func appendQUICVarint(b []byte, value uint64) []byte {
+	switch {
+	case value < 1<<6:
+		return append(b, byte(value))
+	case value < 1<<14:
+		return binary.BigEndian.AppendUint16(b, uint16(value)|0x4000)
+	default:
+		return binary.BigEndian.AppendUint32(b, uint32(value)|0x80000000)
+	}
+}
+
+func sealQUICInitial(t *testing.T, version uint32, dcid []byte, payload []byte) []byte {
+	salt, labelPrefix, initialType := quicSaltV1, "quic ", byte(0)
+	if version == quicVersion2 {
+		salt, labelPrefix, initialType = quicSaltV2, "quicv2 ", 1
+	}
+	key, iv, hp := quicClientInitialKeys(salt, dcid, labelPrefix)
+	const pnLen = 2
+	packetNumber := uint16(7)
+	header := []byte{0xc0 | initialType<<4 | (pnLen - 1)}
+	header = binary.BigEndian.AppendUint32(header, version)
+	header = append(header, byte(len(dcid)))
+	header = append(header, dcid...)
+	header = append(header, 0)
+	header = appendQUICVarint(header, 0)
+	header = appendQUICVarint(header, uint64(pnLen+len(payload)+16))
+	pnOffset := len(header)
+	header = binary.BigEndian.AppendUint16(header, packetNumber)
+	nonce := append([]byte(nil), iv...)
+	nonce[len(nonce)-1] ^= byte(packetNumber)
+	nonce[len(nonce)-2] ^= byte(packetNumber >> 8)
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	packet := aead.Seal(append([]byte(nil), header...), nonce, payload, header)
+	hpBlock, err := aes.NewCipher(hp)
+	require.NoError(t, err)
+	mask := make([]byte, aes.BlockSize)
+	hpBlock.Encrypt(mask, packet[pnOffset+4:pnOffset+4+16])
+	packet[0] ^= mask[0] & 0x0f
+	for i := 0; i < pnLen; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+	}
+	return packet
+}
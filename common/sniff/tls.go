@@ -0,0 +1,32 @@
+package sniff
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/sagernet/sing/common/tls"
+)
+
+func TLSClientHello(ctx context.Context, reader *bytes.Reader) (*Result, error) {
+	clientHello, err := tls.ReadClientHello(reader)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNeedMoreData
+		}
+		return nil, err
+	}
+	return clientHelloResult(ProtocolTLS, clientHello), nil
+}
+
+func clientHelloResult(protocol string, clientHello *tls.ClientHello) *Result {
+	return &Result{
+		Protocol:          protocol,
+		Domain:            clientHello.ServerName,
+		ALPN:              clientHello.ALPNProtocols,
+		SupportedVersions: clientHello.SupportedVersions,
+		ECH:               clientHello.EncryptedClientHello,
+		ClientHello:       clientHello,
+	}
+}
@@ -0,0 +1,329 @@
+package tls
+
+import (
+	"encoding/binary"
+	"io"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+const (
+	RecordTypeHandshake      = 22
+	HandshakeTypeClientHello = 1
+
+	recordHeaderLen    = 5
+	handshakeHeaderLen = 4
+	maxPlaintext       = 16384
+)
+
+const (
+	ExtensionServerName           uint16 = 0
+	ExtensionSupportedGroups      uint16 = 10
+	ExtensionSupportedPoints      uint16 = 11
+	ExtensionSignatureAlgorithms  uint16 = 13
+	ExtensionALPN                 uint16 = 16
+	ExtensionSupportedVersions    uint16 = 43
+	ExtensionPSKModes             uint16 = 45
+	ExtensionKeyShare             uint16 = 51
+	ExtensionEncryptedClientHello uint16 = 0xfe0d
+)
+
+var (
+	ErrNotTLS         = E.New("not a TLS handshake")
+	ErrNotClientHello = E.New("not a TLS ClientHello")
+)
+
+type ClientHello struct {
+	// Raw is the handshake message, including the four-byte handshake header.
+	Raw                  []byte
+	Version              uint16
+	Random               []byte
+	SessionID            []byte
+	CipherSuites         []uint16
+	CompressionMethods   []uint8
+	Extensions           []uint16
+	ServerName           string
+	ALPNProtocols        []string
+	SupportedVersions    []uint16
+	SupportedGroups      []uint16
+	SupportedPoints      []uint8
+	SignatureAlgorithms  []uint16
+	KeyShareGroups       []uint16
+	PSKModes             []uint8
+	EncryptedClientHello bool
+}
+
+// ReadClientHello reads TLS records from reader until a complete ClientHello handshake message is available.
+// io.ErrUnexpectedEOF is returned if the reader ends in the middle of the message.
+func ReadClientHello(reader io.Reader) (*ClientHello, error) {
+	var (
+		header  [recordHeaderLen]byte
+		message []byte
+	)
+	for {
+		_, err := io.ReadFull(reader, header[:])
+		if err != nil {
+			if err == io.EOF && message != nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if header[0] != RecordTypeHandshake || header[1] != 3 {
+			return nil, ErrNotTLS
+		}
+		recordLen := int(binary.BigEndian.Uint16(header[3:]))
+		if recordLen == 0 || recordLen > maxPlaintext {
+			return nil, E.New("invalid TLS record length: ", recordLen)
+		}
+		offset := len(message)
+		message = append(message, make([]byte, recordLen)...)
+		_, err = io.ReadFull(reader, message[offset:])
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if len(message) < handshakeHeaderLen {
+			continue
+		}
+		if message[0] != HandshakeTypeClientHello {
+			return nil, ErrNotClientHello
+		}
+		messageLen := handshakeHeaderLen + (int(message[1])<<16 | int(message[2])<<8 | int(message[3]))
+		if len(message) >= messageLen {
+			return ParseClientHello(message[:messageLen])
+		}
+	}
+}
+
+// ParseClientHello parses a ClientHello handshake message, as carried in TLS records or QUIC CRYPTO frames.
+func ParseClientHello(message []byte) (*ClientHello, error) {
+	s := helloReader(message)
+	var (
+		messageType uint8
+		body        helloReader
+	)
+	if !s.readUint8(&messageType) || !s.readUint24LengthPrefixed(&body) || !s.empty() {
+		return nil, E.New("malformed ClientHello")
+	}
+	if messageType != HandshakeTypeClientHello {
+		return nil, ErrNotClientHello
+	}
+	hello := &ClientHello{Raw: message}
+	var cipherSuites, compressionMethods helloReader
+	if !body.readUint16(&hello.Version) ||
+		!body.readBytes(&hello.Random, 32) ||
+		!body.readUint8LengthPrefixedBytes(&hello.SessionID) ||
+		!body.readUint16LengthPrefixed(&cipherSuites) ||
+		!body.readUint8LengthPrefixed(&compressionMethods) {
+		return nil, E.New("malformed ClientHello")
+	}
+	for !cipherSuites.empty() {
+		var suite uint16
+		if !cipherSuites.readUint16(&suite) {
+			return nil, E.New("malformed ClientHello cipher suites")
+		}
+		hello.CipherSuites = append(hello.CipherSuites, suite)
+	}
+	hello.CompressionMethods = []uint8(compressionMethods)
+	if body.empty() {
+		return hello, nil
+	}
+	var extensions helloReader
+	if !body.readUint16LengthPrefixed(&extensions) || !body.empty() {
+		return nil, E.New("malformed ClientHello extensions")
+	}
+	for !extensions.empty() {
+		var (
+			extension uint16
+			data      helloReader
+		)
+		if !extensions.readUint16(&extension) || !extensions.readUint16LengthPrefixed(&data) {
+			return nil, E.New("malformed ClientHello extensions")
+		}
+		hello.Extensions = append(hello.Extensions, extension)
+		if !hello.parseExtension(extension, data) {
+			return nil, E.New("malformed ClientHello extension ", extension)
+		}
+	}
+	return hello, nil
+}
+
+func (h *ClientHello) parseExtension(extension uint16, data helloReader) bool {
+	switch extension {
+	case ExtensionServerName:
+		var nameList helloReader
+		if !data.readUint16LengthPrefixed(&nameList) {
+			return false
+		}
+		for !nameList.empty() {
+			var (
+				nameType uint8
+				name     []byte
+			)
+			if !nameList.readUint8(&nameType) || !nameList.readUint16LengthPrefixedBytes(&name) {
+				return false
+			}
+			if nameType == 0 {
+				h.ServerName = string(name)
+			}
+		}
+	case ExtensionALPN:
+		var protocolList helloReader
+		if !data.readUint16LengthPrefixed(&protocolList) {
+			return false
+		}
+		for !protocolList.empty() {
+			var protocol []byte
+			if !protocolList.readUint8LengthPrefixedBytes(&protocol) {
+				return false
+			}
+			h.ALPNProtocols = append(h.ALPNProtocols, string(protocol))
+		}
+	case ExtensionSupportedVersions:
+		var versions helloReader
+		if !data.readUint8LengthPrefixed(&versions) {
+			return false
+		}
+		return versions.readUint16List(&h.SupportedVersions)
+	case ExtensionSupportedGroups:
+		var groups helloReader
+		if !data.readUint16LengthPrefixed(&groups) {
+			return false
+		}
+		return groups.readUint16List(&h.SupportedGroups)
+	case ExtensionSupportedPoints:
+		var points []byte
+		if !data.readUint8LengthPrefixedBytes(&points) {
+			return false
+		}
+		h.SupportedPoints = points
+	case ExtensionSignatureAlgorithms:
+		var algorithms helloReader
+		if !data.readUint16LengthPrefixed(&algorithms) {
+			return false
+		}
+		return algorithms.readUint16List(&h.SignatureAlgorithms)
+	case ExtensionPSKModes:
+		var modes []byte
+		if !data.readUint8LengthPrefixedBytes(&modes) {
+			return false
+		}
+		h.PSKModes = modes
+	case ExtensionKeyShare:
+		var keyShares helloReader
+		if !data.readUint16LengthPrefixed(&keyShares) {
+			return false
+		}
+		for !keyShares.empty() {
+			var (
+				group uint16
+				key   []byte
+			)
+			if !keyShares.readUint16(&group) || !keyShares.readUint16LengthPrefixedBytes(&key) {
+				return false
+			}
+			h.KeyShareGroups = append(h.KeyShareGroups, group)
+		}
+	case ExtensionEncryptedClientHello:
+		h.EncryptedClientHello = true
+	}
+	return true
+}
+
+// IsGREASE reports whether value is one of the RFC 8701 reserved values.
+func IsGREASE(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+type helloReader []byte
+
+func (s *helloReader) empty() bool {
+	return len(*s) == 0
+}
+
+func (s *helloReader) read(n int) ([]byte, bool) {
+	if len(*s) < n || n < 0 {
+		return nil, false
+	}
+	v := (*s)[:n:n]
+	*s = (*s)[n:]
+	return v, true
+}
+
+func (s *helloReader) readUint8(out *uint8) bool {
+	v, loaded := s.read(1)
+	if !loaded {
+		return false
+	}
+	*out = v[0]
+	return true
+}
+
+func (s *helloReader) readUint16(out *uint16) bool {
+	v, loaded := s.read(2)
+	if !loaded {
+		return false
+	}
+	*out = binary.BigEndian.Uint16(v)
+	return true
+}
+
+func (s *helloReader) readBytes(out *[]byte, n int) bool {
+	v, loaded := s.read(n)
+	if !loaded {
+		return false
+	}
+	*out = v
+	return true
+}
+
+func (s *helloReader) readLengthPrefixed(lenLen int, out *helloReader) bool {
+	lenBytes, loaded := s.read(lenLen)
+	if !loaded {
+		return false
+	}
+	var length int
+	for _, b := range lenBytes {
+		length = length<<8 | int(b)
+	}
+	v, loaded := s.read(length)
+	if !loaded {
+		return false
+	}
+	*out = v
+	return true
+}
+
+func (s *helloReader) readUint8LengthPrefixed(out *helloReader) bool {
+	return s.readLengthPrefixed(1, out)
+}
+
+func (s *helloReader) readUint16LengthPrefixed(out *helloReader) bool {
+	return s.readLengthPrefixed(2, out)
+}
+
+func (s *helloReader) readUint24LengthPrefixed(out *helloReader) bool {
+	return s.readLengthPrefixed(3, out)
+}
+
+func (s *helloReader) readUint8LengthPrefixedBytes(out *[]byte) bool {
+	return s.readLengthPrefixed(1, (*helloReader)(out))
+}
+
+func (s *helloReader) readUint16LengthPrefixedBytes(out *[]byte) bool {
+	return s.readLengthPrefixed(2, (*helloReader)(out))
+}
+
+func (s *helloReader) readUint16List(out *[]uint16) bool {
+	if len(*s)%2 != 0 {
+		return false
+	}
+	for !s.empty() {
+		var value uint16
+		s.readUint16(&value)
+		*out = append(*out, value)
+	}
+	return true
+}
@@ -0,0 +1,256 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sagernet/sing/common"
+)
+
+// JA3 returns the JA3 fingerprint string of the ClientHello, with GREASE values removed.
+func (h *ClientHello) JA3() string {
+	var builder strings.Builder
+	builder.WriteString(strconv.Itoa(int(h.Version)))
+	builder.WriteByte(',')
+	writeJA3List(&builder, h.CipherSuites)
+	builder.WriteByte(',')
+	writeJA3List(&builder, h.Extensions)
+	builder.WriteByte(',')
+	writeJA3List(&builder, h.SupportedGroups)
+	builder.WriteByte(',')
+	for i, point := range h.SupportedPoints {
+		if i > 0 {
+			builder.WriteByte('-')
+		}
+		builder.WriteString(strconv.Itoa(int(point)))
+	}
+	return builder.String()
+}
+
+// JA3Hash returns the MD5 digest of JA3 in hex, as used by most JA3 databases.
+func (h *ClientHello) JA3Hash() string {
+	hash := md5.Sum([]byte(h.JA3()))
+	return hex.EncodeToString(hash[:])
+}
+
+func writeJA3List(builder *strings.Builder, values []uint16) {
+	var written bool
+	for _, value := range values {
+		if IsGREASE(value) {
+			continue
+		}
+		if written {
+			builder.WriteByte('-')
+		}
+		builder.WriteString(strconv.Itoa(int(value)))
+		written = true
+	}
+}
+
+// JA4 returns the JA4 fingerprint of the ClientHello. isQUIC selects the QUIC protocol marker.
+func (h *ClientHello) JA4(isQUIC bool) string {
+	var builder strings.Builder
+	if isQUIC {
+		builder.WriteByte('q')
+	} else {
+		builder.WriteByte('t')
+	}
+	version := h.Version
+	for _, supportedVersion := range h.SupportedVersions {
+		if !IsGREASE(supportedVersion) && supportedVersion > version {
+			version = supportedVersion
+		}
+	}
+	switch version {
+	case 0x0304:
+		builder.WriteString("13")
+	case 0x0303:
+		builder.WriteString("12")
+	case 0x0302:
+		builder.WriteString("11")
+	case 0x0301:
+		builder.WriteString("10")
+	case 0x0300:
+		builder.WriteString("s3")
+	default:
+		builder.WriteString("00")
+	}
+	if common.Contains(h.Extensions, ExtensionServerName) {
+		builder.WriteByte('d')
+	} else {
+		builder.WriteByte('i')
+	}
+	cipherSuites := common.Filter(h.CipherSuites, func(it uint16) bool {
+		return !IsGREASE(it)
+	})
+	extensions := common.Filter(h.Extensions, func(it uint16) bool {
+		return !IsGREASE(it)
+	})
+	builder.WriteString(ja4Count(len(cipherSuites)))
+	builder.WriteString(ja4Count(len(extensions)))
+	builder.WriteString(ja4ALPN(h.ALPNProtocols))
+	builder.WriteByte('_')
+	builder.WriteString(ja4Hash(ja4HexList(sortedUint16(cipherSuites))))
+	builder.WriteByte('_')
+	extensions = common.Filter(extensions, func(it uint16) bool {
+		return it != ExtensionServerName && it != ExtensionALPN
+	})
+	if len(extensions) == 0 {
+		builder.WriteString(ja4Hash(""))
+	} else {
+		extensionsString := ja4HexList(sortedUint16(extensions))
+		signatureAlgorithms := common.Filter(h.SignatureAlgorithms, func(it uint16) bool {
+			return !IsGREASE(it)
+		})
+		if len(signatureAlgorithms) > 0 {
+			extensionsString += "_" + ja4HexList(signatureAlgorithms)
+		}
+		builder.WriteString(ja4Hash(extensionsString))
+	}
+	return builder.String()
+}
+
+func ja4Count(count int) string {
+	if count > 99 {
+		count = 99
+	}
+	if count < 10 {
+		return "0" + strconv.Itoa(count)
+	}
+	return strconv.Itoa(count)
+}
+
+func ja4ALPN(protocols []string) string {
+	if len(protocols) == 0 || protocols[0] == "" {
+		return "00"
+	}
+	protocol := protocols[0]
+	first, last := protocol[0], protocol[len(protocol)-1]
+	if isAlphanumeric(first) && isAlphanumeric(last) {
+		return string([]byte{first, last})
+	}
+	encoded := hex.EncodeToString([]byte(protocol))
+	return string([]byte{encoded[0], encoded[len(encoded)-1]})
+}
+
+func isAlphanumeric(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+func ja4HexList(values []uint16) string {
+	return strings.Join(common.Map(values, func(it uint16) string {
+		return hex.EncodeToString([]byte{byte(it >> 8), byte(it)})
+	}), ",")
+}
+
+func ja4Hash(value string) string {
+	if value == "" {
+		return "000000000000"
+	}
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+func sortedUint16(values []uint16) []uint16 {
+	sorted := append([]uint16(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
+
+const maxClientHelloRecord = 4 * (recordHeaderLen + maxPlaintext)
+
+// ClientHelloConn records the ClientHello read through it, so that a server handshake running on top
+// of the connection can be fingerprinted afterwards.
+type ClientHelloConn struct {
+	net.Conn
+	access      sync.Mutex
+	record      []byte
+	clientHello *ClientHello
+	done        bool
+}
+
+func NewClientHelloConn(conn net.Conn) *ClientHelloConn {
+	return &ClientHelloConn{Conn: conn}
+}
+
+func (c *ClientHelloConn) Read(p []byte) (n int, err error) {
+	n, err = c.Conn.Read(p)
+	if n > 0 {
+		c.access.Lock()
+		if !c.done {
+			c.record = append(c.record, p[:n]...)
+			clientHello, parseErr := ReadClientHello(bytes.NewReader(c.record))
+			if parseErr == nil {
+				c.clientHello = clientHello
+				c.done = true
+				c.record = nil
+			} else if len(c.record) > maxClientHelloRecord || !isUnexpectedEOF(parseErr) {
+				c.done = true
+				c.record = nil
+			}
+		}
+		c.access.Unlock()
+	}
+	return
+}
+
+// ClientHello returns the recorded ClientHello, or nil if none was read (yet).
+func (c *ClientHelloConn) ClientHello() *ClientHello {
+	c.access.Lock()
+	defer c.access.Unlock()
+	return c.clientHello
+}
+
+func (c *ClientHelloConn) Upstream() any {
+	return c.Conn
+}
+
+func (c *ClientHelloConn) ReaderReplaceable() bool {
+	c.access.Lock()
+	defer c.access.Unlock()
+	return c.done
+}
+
+func (c *ClientHelloConn) WriterReplaceable() bool {
+	return true
+}
+
+func isUnexpectedEOF(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+type ClientHelloListener struct {
+	net.Listener
+}
+
+// NewClientHelloListener wraps accepted connections with ClientHelloConn. Use it as the inner listener of NewListener.
+func NewClientHelloListener(inner net.Listener) net.Listener {
+	return &ClientHelloListener{inner}
+}
+
+func (l *ClientHelloListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewClientHelloConn(conn), nil
+}
+
+// ClientHelloFromConn finds the ClientHello recorded by a ClientHelloConn below conn.
+func ClientHelloFromConn(conn net.Conn) *ClientHello {
+	clientHelloConn, loaded := common.Cast[*ClientHelloConn](conn)
+	if !loaded {
+		return nil
+	}
+	return clientHelloConn.ClientHello()
+}
@@ -0,0 +1,100 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJA4(t *testing.T) {
+	t.Parallel()
+	clientHello := &ClientHello{
+		Version:      0x0303,
+		CipherSuites: []uint16{0x0a0a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035},
+		Extensions: []uint16{
+			0x1a1a, 0x0000, 0x0017, 0xff01, 0x000a, 0x000b, 0x0023, 0x0010, 0x0005, 0x000d,
+			0x0012, 0x0033, 0x002d, 0x002b, 0x001b, 0x4469, 0x0015,
+		},
+		ALPNProtocols:       []string{"h2", "http/1.1"},
+		SupportedVersions:   []uint16{0x2a2a, 0x0304, 0x0303},
+		SignatureAlgorithms: []uint16{0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601},
+	}
+	require.Equal(t, "t13d1516h2_8daaf6152771_e5627efa2ab1", clientHello.JA4(false))
+	require.Equal(t, "q13d1516h2_8daaf6152771_e5627efa2ab1", clientHello.JA4(true))
+}
+
+func TestJA3(t *testing.T) {
+	t.Parallel()
+	clientHello := &ClientHello{
+		Version:         0x0303,
+		CipherSuites:    []uint16{0x0a0a, 0x1301, 0x1302},
+		Extensions:      []uint16{0x1a1a, 0x0000, 0x000a, 0x000b},
+		SupportedGroups: []uint16{0x2a2a, 29, 23},
+		SupportedPoints: []uint8{0},
+	}
+	require.Equal(t, "771,4865-4866,0-10-11,29-23,0", clientHello.JA3())
+	require.Len(t, clientHello.JA3Hash(), 32)
+}
+
+func captureClientHelloRecord(t *testing.T, config *tls.Config) []byte {
+	client, server := net.Pipe()
+	go func() {
+		tls.Client(client, config).Handshake()
+		client.Close()
+	}()
+	defer server.Close()
+	var record bytes.Buffer
+	clientHello, err := ReadClientHello(io.TeeReader(server, &record))
+	require.NoError(t, err)
+	require.Equal(t, config.ServerName, clientHello.ServerName)
+	return record.Bytes()
+}
+
+func TestClientHelloConn(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: "example.com"}).Handshake()
+		client.Close()
+	}()
+	conn := NewClientHelloConn(server)
+	tlsConn := tls.Server(conn, &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return nil, net.ErrClosed
+		},
+	})
+	require.Error(t, tlsConn.Handshake())
+	clientHello := ClientHelloFromConn(tlsConn)
+	require.NotNil(t, clientHello)
+	require.Equal(t, "example.com", clientHello.ServerName)
+	require.NotEmpty(t, clientHello.JA3())
+}
+
+func TestFragmentClientHello(t *testing.T) {
+	t.Parallel()
+	record := captureClientHelloRecord(t, &tls.Config{ServerName: "fragment.example.com"})
+	for _, config := range []FragmentConfig{
+		{Points: []int{1, 40}, SplitRecords: true},
+		{SplitServerName: true, SplitRecords: true},
+		{Points: []int{3}, SplitServerName: true, SplitPackets: true},
+	} {
+		fragments := FragmentClientHello(record, config)
+		require.Greater(t, len(fragments), 1)
+		joined := bytes.Join(fragments, nil)
+		if config.SplitRecords {
+			require.Equal(t, len(record)+recordHeaderLen*(len(fragments)-1), len(joined))
+		} else {
+			require.Equal(t, record, joined)
+		}
+		clientHello, err := ReadClientHello(bytes.NewReader(joined))
+		require.NoError(t, err)
+		require.Equal(t, "fragment.example.com", clientHello.ServerName)
+		require.Equal(t, record[recordHeaderLen:], clientHello.Raw)
+	}
+	require.Nil(t, FragmentClientHello(record, FragmentConfig{}))
+	require.Nil(t, FragmentClientHello([]byte{23, 3, 3, 0, 1, 0}, FragmentConfig{Points: []int{1}}))
+}
@@ -0,0 +1,136 @@
+package tls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sort"
+	"time"
+)
+
+type FragmentConfig struct {
+	// Points are offsets into the ClientHello handshake message at which it is split.
+	Points []int
+	// SplitServerName adds a split point in the middle of the server name.
+	SplitServerName bool
+	// SplitRecords sends each fragment in its own TLS record.
+	SplitRecords bool
+	// SplitPackets sends each fragment (or record) in its own write, so that it leaves in a separate TCP segment.
+	SplitPackets bool
+	// Delay is slept between fragment writes when SplitPackets is set.
+	Delay time.Duration
+}
+
+// FragmentConn splits the first ClientHello written through it according to FragmentConfig.
+// Wrap the raw connection before passing it to ClientHandshake.
+type FragmentConn struct {
+	net.Conn
+	config FragmentConfig
+	done   bool
+}
+
+func NewFragmentConn(conn net.Conn, config FragmentConfig) *FragmentConn {
+	return &FragmentConn{
+		Conn:   conn,
+		config: config,
+	}
+}
+
+func (c *FragmentConn) Write(p []byte) (n int, err error) {
+	if c.done {
+		return c.Conn.Write(p)
+	}
+	c.done = true
+	fragments := FragmentClientHello(p, c.config)
+	if fragments == nil {
+		return c.Conn.Write(p)
+	}
+	if tcpConn, isTCPConn := c.Conn.(*net.TCPConn); isTCPConn && c.config.SplitPackets {
+		tcpConn.SetNoDelay(true)
+	}
+	if !c.config.SplitPackets {
+		_, err = c.Conn.Write(bytes.Join(fragments, nil))
+		if err != nil {
+			return
+		}
+		return len(p), nil
+	}
+	for i, fragment := range fragments {
+		if i > 0 && c.config.Delay > 0 {
+			time.Sleep(c.config.Delay)
+		}
+		_, err = c.Conn.Write(fragment)
+		if err != nil {
+			return
+		}
+	}
+	return len(p), nil
+}
+
+func (c *FragmentConn) Upstream() any {
+	return c.Conn
+}
+
+func (c *FragmentConn) ReaderReplaceable() bool {
+	return true
+}
+
+func (c *FragmentConn) WriterReplaceable() bool {
+	return c.done
+}
+
+// FragmentClientHello splits a ClientHello record (and anything following it in data) into fragments.
+// It returns nil if data does not start with a complete ClientHello record or no split point applies.
+func FragmentClientHello(data []byte, config FragmentConfig) [][]byte {
+	if len(data) < recordHeaderLen || data[0] != RecordTypeHandshake {
+		return nil
+	}
+	recordEnd := recordHeaderLen + int(binary.BigEndian.Uint16(data[3:5]))
+	if len(data) < recordEnd {
+		return nil
+	}
+	message := data[recordHeaderLen:recordEnd]
+	clientHello, err := ParseClientHello(message)
+	if err != nil {
+		return nil
+	}
+	points := append([]int(nil), config.Points...)
+	if config.SplitServerName && clientHello.ServerName != "" {
+		index := bytes.Index(message, []byte(clientHello.ServerName))
+		if index > 0 {
+			points = append(points, index+len(clientHello.ServerName)/2)
+		}
+	}
+	sort.Ints(points)
+	var (
+		pieces [][]byte
+		offset int
+	)
+	for _, point := range points {
+		if point <= offset || point >= len(message) {
+			continue
+		}
+		pieces = append(pieces, message[offset:point])
+		offset = point
+	}
+	if len(pieces) == 0 {
+		return nil
+	}
+	pieces = append(pieces, message[offset:])
+	var fragments [][]byte
+	if config.SplitRecords {
+		for _, piece := range pieces {
+			record := make([]byte, recordHeaderLen, recordHeaderLen+len(piece))
+			copy(record, data[:3])
+			binary.BigEndian.PutUint16(record[3:], uint16(len(piece)))
+			fragments = append(fragments, append(record, piece...))
+		}
+	} else {
+		fragments = append(fragments, append(append([]byte(nil), data[:recordHeaderLen]...), pieces[0]...))
+		fragments = append(fragments, pieces[1:]...)
+	}
+	if len(data) > recordEnd {
+		fragments[len(fragments)-1] = append(append([]byte(nil), fragments[len(fragments)-1]...), data[recordEnd:]...)
+	}
+	return fragments
+}
@@ -0,0 +1,384 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/atomic"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/logger"
+	"github.com/sagernet/sing/service/filemanager"
+)
+
+type CertificateFile struct {
+	Certificate string
+	Key         string
+}
+
+type ReloadOptions struct {
+	Context context.Context
+	Logger  logger.Logger
+	// Config is the base configuration. Certificates, GetCertificate and ClientCAs are managed by the store.
+	Config       *STDConfig
+	Certificates []CertificateFile
+	// ClientCertificateAuthorities enables client certificate verification;
+	// RequireAndVerifyClientCert is used unless Config.ClientAuth says otherwise.
+	ClientCertificateAuthorities []string
+	Interval                     time.Duration
+}
+
+var (
+	_ ServerConfig       = (*ReloadServerConfig)(nil)
+	_ ServerConfigCompat = (*ReloadServerConfig)(nil)
+)
+
+// ReloadServerConfig is a ServerConfig whose certificates and client CAs are loaded from files and
+// reloaded when their content changes. Established connections keep the certificate they were
+// handshaken with.
+type ReloadServerConfig struct {
+	store            *certificateStore
+	config           *STDConfig
+	access           sync.Mutex
+	cached           *STDConfig
+	cachedGeneration uint64
+	closed           bool
+}
+
+type certificateStore struct {
+	ctx              context.Context
+	cancel           common.ContextCancelCauseFunc
+	logger           logger.Logger
+	certificateFiles []CertificateFile
+	clientCAFiles    []string
+	interval         time.Duration
+	ticketKey        [32]byte
+	access           sync.Mutex
+	digest           [sha256.Size]byte
+	state            atomic.Pointer[certificateState]
+	// lifecycle guards the ticker and the number of open configs sharing the store
+	lifecycle  sync.Mutex
+	ticker     *time.Ticker
+	references int
+}
+
+type certificateState struct {
+	generation   uint64
+	certificates []*tls.Certificate
+	nameMap      map[string]*tls.Certificate
+	clientCAs    *x509.CertPool
+}
+
+func NewReloadServerConfig(options ReloadOptions) (*ReloadServerConfig, error) {
+	if len(options.Certificates) == 0 {
+		return nil, E.New("missing certificates")
+	}
+	ctx := options.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := common.ContextWithCancelCause(ctx)
+	if options.Logger == nil {
+		options.Logger = logger.NOP()
+	}
+	interval := options.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	var config *STDConfig
+	if options.Config != nil {
+		config = options.Config.Clone()
+	} else {
+		config = &STDConfig{}
+	}
+	store := &certificateStore{
+		ctx:              ctx,
+		cancel:           cancel,
+		logger:           options.Logger,
+		certificateFiles: options.Certificates,
+		clientCAFiles:    options.ClientCertificateAuthorities,
+		interval:         interval,
+		references:       1,
+	}
+	_, err := rand.Read(store.ticketKey[:])
+	if err != nil {
+		return nil, err
+	}
+	_, err = store.reload()
+	if err != nil {
+		return nil, err
+	}
+	return &ReloadServerConfig{
+		store:  store,
+		config: config,
+	}, nil
+}
+
+func (c *ReloadServerConfig) ServerName() string {
+	c.access.Lock()
+	defer c.access.Unlock()
+	return c.config.ServerName
+}
+
+func (c *ReloadServerConfig) SetServerName(serverName string) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.config.ServerName = serverName
+	c.cached = nil
+}
+
+func (c *ReloadServerConfig) NextProtos() []string {
+	c.access.Lock()
+	defer c.access.Unlock()
+	return c.config.NextProtos
+}
+
+func (c *ReloadServerConfig) SetNextProtos(nextProto []string) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.config.NextProtos = nextProto
+	c.cached = nil
+}
+
+// Config returns a configuration that always serves the latest loaded certificates.
+func (c *ReloadServerConfig) Config() (*STDConfig, error) {
+	c.access.Lock()
+	config := c.config.Clone()
+	c.access.Unlock()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return c.currentConfig(), nil
+	}
+	config.SetSessionTicketKeys([][32]byte{c.store.ticketKey})
+	return config, nil
+}
+
+func (c *ReloadServerConfig) Client(conn net.Conn) (Conn, error) {
+	return tls.Client(conn, c.currentConfig()), nil
+}
+
+// Clone returns a config sharing the certificate store, which keeps reloading until all of its configs are closed.
+func (c *ReloadServerConfig) Clone() Config {
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.store.lifecycle.Lock()
+	c.store.references++
+	c.store.lifecycle.Unlock()
+	return &ReloadServerConfig{
+		store:  c.store,
+		config: c.config.Clone(),
+	}
+}
+
+func (c *ReloadServerConfig) Start() error {
+	s := c.store
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	if s.ticker != nil || s.references == 0 {
+		return nil
+	}
+	s.ticker = time.NewTicker(s.interval)
+	go s.loopReload(s.ticker)
+	return nil
+}
+
+func (c *ReloadServerConfig) Close() error {
+	c.access.Lock()
+	if c.closed {
+		c.access.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.access.Unlock()
+	s := c.store
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	s.references--
+	if s.references > 0 {
+		return nil
+	}
+	if s.ticker != nil {
+		s.ticker.Stop()
+		s.ticker = nil
+	}
+	s.cancel(os.ErrClosed)
+	return nil
+}
+
+func (c *ReloadServerConfig) Server(conn net.Conn) (Conn, error) {
+	return tls.Server(conn, c.currentConfig()), nil
+}
+
+func (c *ReloadServerConfig) ServerHandshake(ctx context.Context, conn net.Conn) (Conn, error) {
+	tlsConn, _ := c.Server(conn)
+	err := tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// Reload rereads all files immediately and reports whether the certificates changed.
+func (c *ReloadServerConfig) Reload() (bool, error) {
+	return c.store.reload()
+}
+
+// Certificates returns the currently served certificates.
+func (c *ReloadServerConfig) Certificates() []*tls.Certificate {
+	return c.store.state.Load().certificates
+}
+
+func (c *ReloadServerConfig) currentConfig() *STDConfig {
+	state := c.store.state.Load()
+	c.access.Lock()
+	defer c.access.Unlock()
+	if c.cached != nil && c.cachedGeneration == state.generation {
+		return c.cached
+	}
+	config := c.config.Clone()
+	config.Certificates = nil
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return state.certificate(hello.ServerName), nil
+	}
+	if state.clientCAs != nil {
+		config.ClientCAs = state.clientCAs
+		if config.ClientAuth == tls.NoClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	config.SetSessionTicketKeys([][32]byte{c.store.ticketKey})
+	c.cached = config
+	c.cachedGeneration = state.generation
+	return config
+}
+
+func (s *certificateStore) loopReload(ticker *time.Ticker) {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := s.reload()
+		if err != nil {
+			s.logger.Error(E.Cause(err, "reload certificates"))
+		} else if changed {
+			s.logger.Info("reloaded certificates")
+		}
+	}
+}
+
+func (s *certificateStore) reload() (bool, error) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	digest := sha256.New()
+	certificateContents := make([][2][]byte, 0, len(s.certificateFiles))
+	for _, file := range s.certificateFiles {
+		certificateContent, err := s.readFile(file.Certificate)
+		if err != nil {
+			return false, err
+		}
+		keyContent, err := s.readFile(file.Key)
+		if err != nil {
+			return false, err
+		}
+		digest.Write(certificateContent)
+		digest.Write(keyContent)
+		certificateContents = append(certificateContents, [2][]byte{certificateContent, keyContent})
+	}
+	clientCAContents := make([][]byte, 0, len(s.clientCAFiles))
+	for _, path := range s.clientCAFiles {
+		content, err := s.readFile(path)
+		if err != nil {
+			return false, err
+		}
+		digest.Write(content)
+		clientCAContents = append(clientCAContents, content)
+	}
+	var newDigest [sha256.Size]byte
+	digest.Sum(newDigest[:0])
+	oldState := s.state.Load()
+	if oldState != nil && bytes.Equal(newDigest[:], s.digest[:]) {
+		return false, nil
+	}
+	state := &certificateState{
+		nameMap: make(map[string]*tls.Certificate),
+	}
+	if oldState != nil {
+		state.generation = oldState.generation + 1
+	}
+	for i, content := range certificateContents {
+		certificate, err := tls.X509KeyPair(content[0], content[1])
+		if err != nil {
+			return false, E.Cause(err, "load key pair ", s.certificateFiles[i].Certificate)
+		}
+		if certificate.Leaf == nil {
+			certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+			if err != nil {
+				return false, E.Cause(err, "parse certificate ", s.certificateFiles[i].Certificate)
+			}
+		}
+		state.addCertificate(&certificate)
+	}
+	if len(clientCAContents) > 0 {
+		state.clientCAs = x509.NewCertPool()
+		for i, content := range clientCAContents {
+			if !state.clientCAs.AppendCertsFromPEM(content) {
+				return false, E.New("no certificates found in ", s.clientCAFiles[i])
+			}
+		}
+	}
+	s.digest = newDigest
+	s.state.Store(state)
+	return oldState != nil, nil
+}
+
+func (s *certificateStore) readFile(path string) ([]byte, error) {
+	file, err := filemanager.OpenFile(s.ctx, path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+func (s *certificateState) addCertificate(certificate *tls.Certificate) {
+	s.certificates = append(s.certificates, certificate)
+	names := certificate.Leaf.DNSNames
+	if len(names) == 0 && certificate.Leaf.Subject.CommonName != "" {
+		names = []string{certificate.Leaf.Subject.CommonName}
+	}
+	for _, ip := range certificate.Leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if _, loaded := s.nameMap[name]; !loaded {
+			s.nameMap[name] = certificate
+		}
+	}
+}
+
+// certificate selects the certificate for serverName: an exact match first, then a wildcard
+// covering its first label, then the first configured certificate.
+func (s *certificateState) certificate(serverName string) *tls.Certificate {
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if certificate, loaded := s.nameMap[serverName]; loaded {
+		return certificate
+	}
+	if index := strings.IndexByte(serverName, '.'); index > 0 {
+		if certificate, loaded := s.nameMap["*"+serverName[index:]]; loaded {
+			return certificate
+		}
+	}
+	return s.certificates[0]
+}
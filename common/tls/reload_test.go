@@ -0,0 +1,133 @@
+package tls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeCertificate(t *testing.T, directory string, name string, commonName string, dnsNames ...string) CertificateFile {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	file := CertificateFile{
+		Certificate: filepath.Join(directory, name+".crt"),
+		Key:         filepath.Join(directory, name+".key"),
+	}
+	require.NoError(t, os.WriteFile(file.Certificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), 0o644))
+	require.NoError(t, os.WriteFile(file.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0o600))
+	return file
+}
+
+func handshakePeerCommonName(t *testing.T, config ServerConfig, serverName string) string {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go ServerHandshake(context.Background(), server, config)
+	tlsConn := tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	require.NoError(t, tlsConn.Handshake())
+	return tlsConn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestReloadServerConfig(t *testing.T) {
+	t.Parallel()
+	directory := t.TempDir()
+	first := writeCertificate(t, directory, "first", "first", "example.com")
+	wildcard := writeCertificate(t, directory, "wildcard", "wildcard", "*.example.org")
+	config, err := NewReloadServerConfig(ReloadOptions{
+		Certificates: []CertificateFile{first, wildcard},
+	})
+	require.NoError(t, err)
+	defer config.Close()
+	require.NoError(t, config.Start())
+	require.Equal(t, "first", handshakePeerCommonName(t, config, "example.com"))
+	require.Equal(t, "wildcard", handshakePeerCommonName(t, config, "www.EXAMPLE.org"))
+	require.Equal(t, "first", handshakePeerCommonName(t, config, "unknown.test"))
+
+	changed, err := config.Reload()
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	writeCertificate(t, directory, "first", "second", "example.com")
+	changed, err = config.Reload()
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, "second", handshakePeerCommonName(t, config, "example.com"))
+
+	require.NoError(t, os.WriteFile(first.Key, []byte("broken"), 0o600))
+	_, err = config.Reload()
+	require.Error(t, err)
+	require.Equal(t, "second", handshakePeerCommonName(t, config, "example.com"))
+}
+
+func TestReloadServerConfigClone(t *testing.T) {
+	t.Parallel()
+	directory := t.TempDir()
+	file := writeCertificate(t, directory, "server", "first", "example.com")
+	config, err := NewReloadServerConfig(ReloadOptions{
+		Certificates: []CertificateFile{file},
+		Interval:     10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer config.Close()
+	require.NoError(t, config.Start())
+	clone := config.Clone().(*ReloadServerConfig)
+	require.NoError(t, clone.Start())
+	require.NoError(t, clone.Close())
+	require.NoError(t, clone.Close())
+
+	writeCertificate(t, directory, "server", "second", "example.com")
+	require.Eventually(t, func() bool {
+		return config.Certificates()[0].Leaf.Subject.CommonName == "second"
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "second", handshakePeerCommonName(t, config, "example.com"))
+}
+
+func TestReloadServerConfigConcurrentAccess(t *testing.T) {
+	t.Parallel()
+	directory := t.TempDir()
+	config, err := NewReloadServerConfig(ReloadOptions{
+		Certificates: []CertificateFile{writeCertificate(t, directory, "server", "server", "example.com")},
+	})
+	require.NoError(t, err)
+	defer config.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			config.SetServerName("example.com")
+			config.SetNextProtos([]string{"h2"})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		config.ServerName()
+		config.NextProtos()
+		_, err = config.Config()
+		require.NoError(t, err)
+		require.NoError(t, config.Start())
+	}
+	<-done
+}
@@ -23,13 +23,23 @@ import (
 type Handler = N.TCPConnectionHandler
 
 func HandleConnection(ctx context.Context, conn net.Conn, reader *std_bufio.Reader, authenticator *auth.Authenticator, handler Handler, metadata M.Metadata) error {
+	var certificateAuthenticated bool
 	for {
 		request, err := ReadRequest(reader)
 		if err != nil {
 			return E.Cause(err, "read http request")
 		}
 
-		if authenticator != nil {
+		// a TLS server handshake completes on the first read
+		if !certificateAuthenticated {
+			var certificateUser string
+			certificateUser, certificateAuthenticated = authenticator.VerifyConn(conn)
+			if certificateAuthenticated {
+				ctx = auth.ContextWithUser(ctx, certificateUser)
+			}
+		}
+
+		if authenticator != nil && !certificateAuthenticated {
 			var (
 				username string
 				password string
@@ -76,7 +86,10 @@ func HandleConnection(ctx context.Context, conn net.Conn, reader *std_bufio.Read
 			if portStr == "" {
 				portStr = "80"
 			}
-			destination := M.ParseSocksaddrHostPortStr(request.URL.Hostname(), portStr)
+			destination, err := M.NormalizeFqdn(M.ParseSocksaddrHostPortStr(request.URL.Hostname(), portStr))
+			if err != nil {
+				return E.Errors(E.Cause(err, "http: bad destination"), responseWith(request, http.StatusBadRequest).Write(conn))
+			}
 			_, err = conn.Write([]byte(F.ToString("HTTP/", request.ProtoMajor, ".", request.ProtoMinor, " 200 Connection established\r\n\r\n")))
 			if err != nil {
 				return E.Cause(err, "write http response")
@@ -113,6 +126,10 @@ func HandleConnection(ctx context.Context, conn net.Conn, reader *std_bufio.Read
 		if request.URL.Scheme == "" || request.URL.Host == "" {
 			return responseWith(request, http.StatusBadRequest).Write(conn)
 		}
+		_, err = M.NormalizeFqdn(M.ParseSocksaddrHostPort(request.URL.Hostname(), 0))
+		if err != nil {
+			return E.Errors(E.Cause(err, "http: bad destination"), responseWith(request, http.StatusBadRequest).Write(conn))
+		}
 
 		var innerErr atomic.TypedValue[error]
 		httpClient := &http.Client{
@@ -113,7 +113,10 @@ func HandleConnection0(ctx context.Context, conn net.Conn, reader *std_bufio.Rea
 		}
 		switch request.Command {
 		case socks4.CommandConnect:
-			if authenticator != nil && !authenticator.Verify(request.Username, "") {
+			username := request.Username
+			if certificateUser, loaded := authenticator.VerifyConn(conn); loaded {
+				username = certificateUser
+			} else if authenticator != nil && !authenticator.Verify(request.Username, "") {
 				err = socks4.WriteResponse(conn, socks4.Response{
 					ReplyCode:   socks4.ReplyCodeRejectedOrFailed,
 					Destination: request.Destination,
@@ -123,6 +126,14 @@ func HandleConnection0(ctx context.Context, conn net.Conn, reader *std_bufio.Rea
 				}
 				return E.New("socks4: authentication failed, username=", request.Username)
 			}
+			destination, err := M.NormalizeFqdn(request.Destination)
+			if err != nil {
+				// the destination of a rejected socks4a request has no address to send back
+				return E.Errors(E.Cause(err, "socks4: bad destination"), socks4.WriteResponse(conn, socks4.Response{
+					ReplyCode:   socks4.ReplyCodeRejectedOrFailed,
+					Destination: M.SocksaddrFrom(netip.IPv4Unspecified(), 0),
+				}))
+			}
 			err = socks4.WriteResponse(conn, socks4.Response{
 				ReplyCode:   socks4.ReplyCodeGranted,
 				Destination: M.SocksaddrFromNet(conn.LocalAddr()),
@@ -131,8 +142,8 @@ func HandleConnection0(ctx context.Context, conn net.Conn, reader *std_bufio.Rea
 				return err
 			}
 			metadata.Protocol = "socks4"
-			metadata.Destination = request.Destination
-			return handler.NewConnection(auth.ContextWithUser(ctx, request.Username), conn, metadata)
+			metadata.Destination = destination
+			return handler.NewConnection(auth.ContextWithUser(ctx, username), conn, metadata)
 		default:
 			err = socks4.WriteResponse(conn, socks4.Response{
 				ReplyCode:   socks4.ReplyCodeRejectedOrFailed,
@@ -149,7 +160,11 @@ func HandleConnection0(ctx context.Context, conn net.Conn, reader *std_bufio.Rea
 			return err
 		}
 		var authMethod byte
-		if authenticator != nil && !common.Contains(authRequest.Methods, socks5.AuthTypeUsernamePassword) {
+		certificateUser, certificateAuthenticated := authenticator.VerifyConn(conn)
+		if certificateAuthenticated {
+			ctx = auth.ContextWithUser(ctx, certificateUser)
+		}
+		if authenticator != nil && !certificateAuthenticated && !common.Contains(authRequest.Methods, socks5.AuthTypeUsernamePassword) {
 			err = socks5.WriteAuthResponse(conn, socks5.AuthResponse{
 				Method: socks5.AuthTypeNoAcceptedMethods,
 			})
@@ -157,7 +172,7 @@ func HandleConnection0(ctx context.Context, conn net.Conn, reader *std_bufio.Rea
 				return err
 			}
 		}
-		if authenticator != nil {
+		if authenticator != nil && !(certificateAuthenticated && common.Contains(authRequest.Methods, socks5.AuthTypeNotRequired)) {
 			authMethod = socks5.AuthTypeUsernamePassword
 		} else {
 			authMethod = socks5.AuthTypeNotRequired
@@ -173,9 +188,11 @@ func HandleConnection0(ctx context.Context, conn net.Conn, reader *std_bufio.Rea
 			if err != nil {
 				return err
 			}
-			ctx = auth.ContextWithUser(ctx, usernamePasswordAuthRequest.Username)
+			if !certificateAuthenticated {
+				ctx = auth.ContextWithUser(ctx, usernamePasswordAuthRequest.Username)
+			}
 			response := socks5.UsernamePasswordAuthResponse{}
-			if authenticator.Verify(usernamePasswordAuthRequest.Username, usernamePasswordAuthRequest.Password) {
+			if certificateAuthenticated || authenticator.Verify(usernamePasswordAuthRequest.Username, usernamePasswordAuthRequest.Password) {
 				response.Status = socks5.UsernamePasswordStatusSuccess
 			} else {
 				response.Status = socks5.UsernamePasswordStatusFailure
@@ -192,6 +209,14 @@ func HandleConnection0(ctx context.Context, conn net.Conn, reader *std_bufio.Rea
 		if err != nil {
 			return err
 		}
+		if request.Command == socks5.CommandConnect || request.Command == socks5.CommandUDPAssociate {
+			request.Destination, err = M.NormalizeFqdn(request.Destination)
+			if err != nil {
+				return E.Errors(E.Cause(err, "socks5: bad destination"), socks5.WriteResponse(conn, socks5.Response{
+					ReplyCode: socks5.ReplyCodeHostUnreachable,
+				}))
+			}
+		}
 		switch request.Command {
 		case socks5.CommandConnect:
 			err = socks5.WriteResponse(conn, socks5.Response{
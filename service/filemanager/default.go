@@ -175,6 +175,10 @@ func (m *defaultManager) RemoveAll(path string) error {
 	return os.RemoveAll(path)
 }
 
+func (m *defaultManager) Rename(oldPath string, newPath string) error {
+	return os.Rename(m.BasePath(oldPath), m.BasePath(newPath))
+}
+
 func fixRootDirectory(p string) string {
 	if len(p) == len(`\\?\c:`) {
 		if os.IsPathSeparator(p[0]) && os.IsPathSeparator(p[1]) && p[2] == '?' && os.IsPathSeparator(p[3]) && p[5] == ':' {
@@ -91,6 +91,17 @@ func RemoveAll(ctx context.Context, path string) error {
 	return manager.RemoveAll(path)
 }
 
+// Rename uses the Rename method of the manager if it has one, as it is not part of Manager.
+func Rename(ctx context.Context, oldPath string, newPath string) error {
+	manager := service.FromContext[Manager](ctx)
+	if renamer, isRenamer := manager.(interface {
+		Rename(oldPath string, newPath string) error
+	}); isRenamer {
+		return renamer.Rename(oldPath, newPath)
+	}
+	return os.Rename(BasePath(ctx, oldPath), BasePath(ctx, newPath))
+}
+
 func WriteFile(ctx context.Context, name string, data []byte, perm os.FileMode) error {
 	manager := service.FromContext[Manager](ctx)
 	if manager == nil {